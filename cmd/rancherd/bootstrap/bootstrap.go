@@ -13,16 +13,18 @@ func NewBootstrap() *cobra.Command {
 }
 
 type Bootstrap struct {
-	Force bool `usage:"Run bootstrap even if already bootstrapped" short:"f"`
-	//DataDir string `usage:"Path to rancherd state" default:"/var/lib/rancher/rancherd"`
-	//Config string `usage:"Custom config path" default:"/etc/rancher/rancherd/config.yaml" short:"c"`
+	Force   bool   `usage:"Run bootstrap even if already bootstrapped" short:"f"`
+	DryRun  bool   `usage:"Print the plan that would be applied and exit"`
+	DataDir string `usage:"Path to rancherd state" default:"/var/lib/rancher/rancherd"`
+	Config  string `usage:"Custom config path" default:"/etc/rancher/rancherd/config.yaml" short:"c"`
 }
 
 func (b *Bootstrap) Run(cmd *cobra.Command, args []string) error {
 	r := rancherd.New(rancherd.Config{
 		Force:      b.Force,
-		DataDir:    rancherd.DefaultDataDir,
-		ConfigPath: rancherd.DefaultConfigFile,
+		DryRun:     b.DryRun,
+		DataDir:    b.DataDir,
+		ConfigPath: b.Config,
 	})
 	return r.Run(cmd.Context())
 }
@@ -0,0 +1,65 @@
+package bundle
+
+import (
+	"github.com/rancher/rancherd/pkg/rancherd"
+	cli "github.com/rancher/wrangler-cli"
+	"github.com/spf13/cobra"
+)
+
+func NewBundle() *cobra.Command {
+	root := cli.Command(&Bundle{}, cobra.Command{
+		Short: "Create and load air-gap bundles",
+	})
+	root.AddCommand(
+		cli.Command(&Create{}, cobra.Command{
+			Short: "Create a bundle with the images, the Rancher chart and a config for the versions",
+		}),
+		cli.Command(&Load{}, cobra.Command{
+			Use:   "load [flags] BUNDLE",
+			Short: "Load the images of a bundle so bootstrap and upgrade do not pull them",
+			Args:  cobra.ExactArgs(1),
+		}),
+	)
+	return root
+}
+
+type Bundle struct {
+}
+
+func (b *Bundle) Run(cmd *cobra.Command, args []string) error {
+	return cmd.Help()
+}
+
+type Create struct {
+	Config            string   `usage:"Config file to read the versions and images from" default:"/etc/rancher/rancherd/config.yaml"`
+	RancherVersion    string   `usage:"Rancher version, defaults to the version of the config" short:"r"`
+	KubernetesVersion string   `usage:"Kubernetes version, defaults to the version of the config" short:"k"`
+	Image             []string `usage:"Additional image to bundle"`
+	ChartRepo         string   `usage:"Helm repository of the Rancher chart, set to empty to not bundle the chart" default:"https://releases.rancher.com/server-charts/stable"`
+	Output            string   `usage:"Path of the bundle" short:"o" default:"rancherd-bundle.tar.gz"`
+}
+
+func (c *Create) Run(cmd *cobra.Command, args []string) error {
+	r := rancherd.New(rancherd.Config{
+		DataDir:    rancherd.DefaultDataDir,
+		ConfigPath: c.Config,
+	})
+	return r.BundleCreate(cmd.Context(), rancherd.BundleConfig{
+		KubernetesVersion: c.KubernetesVersion,
+		RancherVersion:    c.RancherVersion,
+		Images:            c.Image,
+		ChartRepo:         c.ChartRepo,
+		Output:            c.Output,
+	})
+}
+
+type Load struct {
+}
+
+func (l *Load) Run(cmd *cobra.Command, args []string) error {
+	r := rancherd.New(rancherd.Config{
+		DataDir:    rancherd.DefaultDataDir,
+		ConfigPath: rancherd.DefaultConfigFile,
+	})
+	return r.BundleLoad(cmd.Context(), args[0])
+}
@@ -0,0 +1,77 @@
+package etcdsnapshot
+
+import (
+	"github.com/rancher/rancherd/pkg/rancherd"
+	cli "github.com/rancher/wrangler-cli"
+	"github.com/spf13/cobra"
+)
+
+func NewEtcdSnapshot() *cobra.Command {
+	root := cli.Command(&EtcdSnapshot{}, cobra.Command{
+		Use:   "etcd-snapshot",
+		Short: "Save, list and restore etcd snapshots",
+	})
+	root.AddCommand(
+		cli.Command(&Save{}, cobra.Command{
+			Short: "Save an etcd snapshot with the versions of Rancher and Kubernetes",
+		}),
+		cli.Command(&List{}, cobra.Command{
+			Short: "List etcd snapshots",
+		}),
+		cli.Command(&Restore{}, cobra.Command{
+			Use:   "restore [flags] SNAPSHOT",
+			Short: "Reset the cluster to an etcd snapshot",
+			Args:  cobra.ExactArgs(1),
+		}),
+	)
+	return root
+}
+
+type EtcdSnapshot struct {
+}
+
+func (e *EtcdSnapshot) Run(cmd *cobra.Command, args []string) error {
+	return cmd.Help()
+}
+
+type Save struct {
+	Name string `usage:"Name of the snapshot, the runtime appends the node name and a timestamp" default:"rancherd"`
+	Dir  string `usage:"Directory of the snapshots, overrides etcdSnapshot.dir of the config"`
+}
+
+func (s *Save) Run(cmd *cobra.Command, args []string) error {
+	return newRancherd(false).EtcdSnapshotSave(cmd.Context(), rancherd.EtcdSnapshotConfig{
+		Name: s.Name,
+		Dir:  s.Dir,
+	})
+}
+
+type List struct {
+	Dir string `usage:"Directory of the snapshots, overrides etcdSnapshot.dir of the config"`
+}
+
+func (l *List) Run(cmd *cobra.Command, args []string) error {
+	return newRancherd(false).EtcdSnapshotList(cmd.Context(), rancherd.EtcdSnapshotConfig{
+		Dir: l.Dir,
+	})
+}
+
+type Restore struct {
+	Dir   string `usage:"Directory of the snapshots, overrides etcdSnapshot.dir of the config"`
+	Force bool   `usage:"Run without prompting for confirmation" short:"f"`
+}
+
+func (r *Restore) Run(cmd *cobra.Command, args []string) error {
+	return newRancherd(r.Force).EtcdSnapshotRestore(cmd.Context(), rancherd.EtcdSnapshotConfig{
+		Name: args[0],
+		Dir:  r.Dir,
+	})
+}
+
+func newRancherd(force bool) *rancherd.Rancherd {
+	return rancherd.New(rancherd.Config{
+		Force:      force,
+		DataDir:    rancherd.DefaultDataDir,
+		ConfigPath: rancherd.DefaultConfigFile,
+	})
+}
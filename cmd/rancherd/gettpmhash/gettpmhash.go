@@ -2,10 +2,13 @@ package gettpmhash
 
 import (
 	"fmt"
+	"os"
+	"text/tabwriter"
 
 	"github.com/rancher/rancherd/pkg/tpm"
 	cli "github.com/rancher/wrangler-cli"
 	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
 )
 
 func NewGetTPMHash() *cobra.Command {
@@ -16,13 +19,62 @@ func NewGetTPMHash() *cobra.Command {
 }
 
 type GetTPMHash struct {
+	All  bool     `usage:"List all EKs of the TPM with their algorithm and hash, the selected EK is marked with *"`
+	PCRs []string `usage:"Also print the SHA256 values of these PCRs, in the format of the inventory server PCR policy" name:"pcrs"`
 }
 
 func (p *GetTPMHash) Run(cmd *cobra.Command, args []string) error {
-	str, err := tpm.GetPubHash()
+	t, err := tpm.New(tpm.Options{})
 	if err != nil {
 		return err
 	}
-	fmt.Println(str)
+
+	if p.All {
+		return p.listEKs(t)
+	}
+
+	str, err := t.GetPubHash()
+	if err != nil {
+		return err
+	}
+
+	if len(p.PCRs) == 0 {
+		fmt.Println(str)
+		return nil
+	}
+
+	indexes, err := tpm.ParsePCRs(p.PCRs)
+	if err != nil {
+		return err
+	}
+
+	pcrs, err := t.ReadPCRs(indexes)
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(tpm.NewMachinePCRs(str, pcrs))
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(data))
 	return nil
 }
+
+func (p *GetTPMHash) listEKs(t *tpm.TPM) error {
+	eks, err := t.ListEKs()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tALGORITHM\tHASH\tCERTIFICATE")
+	for _, ek := range eks {
+		selected := ""
+		if ek.Selected {
+			selected = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", selected, ek.Algorithm, ek.Hash, ek.Certificate)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,47 @@
+package images
+
+import (
+	"fmt"
+
+	"github.com/rancher/rancherd/pkg/rancherd"
+	cli "github.com/rancher/wrangler-cli"
+	"github.com/spf13/cobra"
+)
+
+func NewImages() *cobra.Command {
+	return cli.Command(&Images{}, cobra.Command{
+		Short: "List the images rancherd will pull",
+		Long: `List the images rancherd will pull
+
+The installer images of Kubernetes and Rancher and the images of the pre and post
+instructions of the config are listed, honoring systemDefaultRegistry and the
+installer image overrides. With --output mirror a mirror manifest of
+source=destination lines is printed.`,
+	})
+}
+
+type Images struct {
+	Config            string `usage:"Config file to read the versions and images from" default:"/etc/rancher/rancherd/config.yaml"`
+	RancherVersion    string `usage:"Rancher version, defaults to the version of the config" short:"r"`
+	KubernetesVersion string `usage:"Kubernetes version, defaults to the version of the config" short:"k"`
+	RancherOSVersion  string `usage:"RancherOS image or channel, the RancherOS image is only listed if set" short:"o" name:"rancher-os-version"`
+	Output            string `usage:"Output format, text, json or mirror" default:"text"`
+	Registry          string `usage:"Registry to mirror the images to with --output mirror"`
+}
+
+func (i *Images) Run(cmd *cobra.Command, args []string) error {
+	if i.Registry != "" && i.Output != "mirror" {
+		return fmt.Errorf("--registry is only supported with --output mirror")
+	}
+	r := rancherd.New(rancherd.Config{
+		DataDir:    rancherd.DefaultDataDir,
+		ConfigPath: i.Config,
+	})
+	return r.Images(cmd.Context(), rancherd.ImagesConfig{
+		KubernetesVersion: i.KubernetesVersion,
+		RancherVersion:    i.RancherVersion,
+		RancherOSVersion:  i.RancherOSVersion,
+		Output:            i.Output,
+		Registry:          i.Registry,
+	})
+}
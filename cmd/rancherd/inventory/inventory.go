@@ -0,0 +1,24 @@
+package inventory
+
+import (
+	"github.com/rancher/rancherd/pkg/rancherd"
+	cli "github.com/rancher/wrangler-cli"
+	"github.com/spf13/cobra"
+)
+
+func NewInventory() *cobra.Command {
+	return cli.Command(&Inventory{}, cobra.Command{
+		Short: "Print the config last downloaded from the machine inventory",
+	})
+}
+
+type Inventory struct {
+}
+
+func (i *Inventory) Run(cmd *cobra.Command, args []string) error {
+	r := rancherd.New(rancherd.Config{
+		DataDir:    rancherd.DefaultDataDir,
+		ConfigPath: rancherd.DefaultConfigFile,
+	})
+	return r.Inventory(cmd.Context())
+}
@@ -0,0 +1,53 @@
+package inventoryserver
+
+import (
+	"io/ioutil"
+
+	"github.com/rancher/rancherd/pkg/inventory"
+	"github.com/rancher/rancherd/pkg/tpm"
+	cli "github.com/rancher/wrangler-cli"
+	"github.com/spf13/cobra"
+)
+
+func NewInventoryServer() *cobra.Command {
+	return cli.Command(&InventoryServer{}, cobra.Command{
+		Use:   "inventory-server",
+		Short: "Serve machine configs to nodes identified by their TPM",
+		Long: `Serve machine configs to nodes identified by their TPM
+
+Nodes configured with only server and a tpm:// token download their config from
+<dir>/<tpm hash>.yaml after proving they own the TPM. The TPM hash of a node is
+printed by "rancherd get-tpm-hash". Nodes are identified by this hash only, EK
+certificates are not validated against the CAs of the TPM manufacturers.`,
+	})
+}
+
+type InventoryServer struct {
+	Listen  string   `usage:"Address to listen on" default:":8443"`
+	Dir     string   `usage:"Directory containing the machine configs named <tpm hash>.yaml" default:"/var/lib/rancher/rancherd/inventory"`
+	Cert    string   `usage:"TLS certificate file, a self-signed certificate is generated if not set"`
+	Key     string   `usage:"TLS key file"`
+	CACerts string   `usage:"CA certificate file sent to the nodes, defaults to the TLS certificate" name:"cacerts"`
+	TLSSan  []string `usage:"Additional hostnames or IPs for the generated certificate" name:"tls-san"`
+	PCRs    []string `usage:"Require machines without a <tpm hash>.pcrs.yaml policy to quote these PCRs and record their values as policy" name:"pcrs"`
+}
+
+func (p *InventoryServer) Run(cmd *cobra.Command, args []string) error {
+	pcrs, err := tpm.ParsePCRs(p.PCRs)
+	if err != nil {
+		return err
+	}
+
+	server := &inventory.Server{
+		Dir:         p.Dir,
+		DefaultPCRs: pcrs,
+	}
+	if p.CACerts != "" {
+		data, err := ioutil.ReadFile(p.CACerts)
+		if err != nil {
+			return err
+		}
+		server.CACerts = data
+	}
+	return server.ListenAndServe(cmd.Context(), p.Listen, p.Cert, p.Key, p.TLSSan)
+}
@@ -0,0 +1,26 @@
+package leave
+
+import (
+	"github.com/rancher/rancherd/pkg/rancherd"
+	cli "github.com/rancher/wrangler-cli"
+	"github.com/spf13/cobra"
+)
+
+func NewLeave() *cobra.Command {
+	return cli.Command(&Leave{}, cobra.Command{
+		Short: "Remove this node from the cluster and reset it",
+	})
+}
+
+type Leave struct {
+	Force bool `usage:"Run without prompting for confirmation" short:"f"`
+}
+
+func (l *Leave) Run(cmd *cobra.Command, args []string) error {
+	r := rancherd.New(rancherd.Config{
+		Force:      l.Force,
+		DataDir:    rancherd.DefaultDataDir,
+		ConfigPath: rancherd.DefaultConfigFile,
+	})
+	return r.Leave(cmd.Context())
+}
@@ -2,20 +2,42 @@ package main
 
 import (
 	cli "github.com/rancher/wrangler-cli"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
 	"github.com/rancher/rancherd/cmd/rancherd/bootstrap"
+	"github.com/rancher/rancherd/cmd/rancherd/bundle"
+	"github.com/rancher/rancherd/cmd/rancherd/etcdsnapshot"
 	"github.com/rancher/rancherd/cmd/rancherd/gettoken"
 	"github.com/rancher/rancherd/cmd/rancherd/gettpmhash"
+	"github.com/rancher/rancherd/cmd/rancherd/images"
 	"github.com/rancher/rancherd/cmd/rancherd/info"
+	"github.com/rancher/rancherd/cmd/rancherd/inventory"
+	"github.com/rancher/rancherd/cmd/rancherd/inventoryserver"
+	"github.com/rancher/rancherd/cmd/rancherd/leave"
+	"github.com/rancher/rancherd/cmd/rancherd/preflight"
+	"github.com/rancher/rancherd/cmd/rancherd/prepare"
 	"github.com/rancher/rancherd/cmd/rancherd/probe"
+	"github.com/rancher/rancherd/cmd/rancherd/reset"
 	"github.com/rancher/rancherd/cmd/rancherd/resetadmin"
 	"github.com/rancher/rancherd/cmd/rancherd/retry"
+	"github.com/rancher/rancherd/cmd/rancherd/runprepared"
+	"github.com/rancher/rancherd/cmd/rancherd/tpmseal"
+	"github.com/rancher/rancherd/cmd/rancherd/unsealsecrets"
 	"github.com/rancher/rancherd/cmd/rancherd/updateclientsecret"
 	"github.com/rancher/rancherd/cmd/rancherd/upgrade"
+	"github.com/rancher/rancherd/cmd/rancherd/upgradeos"
+	"github.com/rancher/rancherd/cmd/rancherd/verifyimage"
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/paths"
 )
 
 type Rancherd struct {
+	Root string `usage:"Root dir prepended to all host paths, to prepare a filesystem tree offline" env:"RANCHERD_ROOT"`
+}
+
+func (a *Rancherd) PersistentPre(cmd *cobra.Command, args []string) error {
+	return paths.SetRoot(a.Root)
 }
 
 func (a *Rancherd) Run(cmd *cobra.Command, args []string) error {
@@ -28,14 +50,31 @@ func main() {
 	})
 	root.AddCommand(
 		bootstrap.NewBootstrap(),
+		prepare.NewPrepare(),
 		gettoken.NewGetToken(),
 		resetadmin.NewResetAdmin(),
 		probe.NewProbe(),
 		retry.NewRetry(),
+		runprepared.NewRunPrepared(),
 		upgrade.NewUpgrade(),
+		reset.NewReset(),
+		leave.NewLeave(),
+		etcdsnapshot.NewEtcdSnapshot(),
 		info.NewInfo(),
+		images.NewImages(),
+		bundle.NewBundle(),
+		preflight.NewPreflight(),
 		gettpmhash.NewGetTPMHash(),
+		tpmseal.NewTPMSeal(),
+		unsealsecrets.NewUnsealSecrets(),
+		inventory.NewInventory(),
+		inventoryserver.NewInventoryServer(),
 		updateclientsecret.NewUpdateClientSecret(),
+		upgradeos.NewUpgradeOS(),
+		verifyimage.NewVerifyImage(),
 	)
+	logrus.SetFormatter(&config.RedactingFormatter{
+		Formatter: logrus.StandardLogger().Formatter,
+	})
 	cli.Main(root)
 }
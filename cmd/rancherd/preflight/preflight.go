@@ -0,0 +1,24 @@
+package preflight
+
+import (
+	"github.com/rancher/rancherd/pkg/rancherd"
+	cli "github.com/rancher/wrangler-cli"
+	"github.com/spf13/cobra"
+)
+
+func NewPreflight() *cobra.Command {
+	return cli.Command(&Preflight{}, cobra.Command{
+		Short: "Check if this host is ready to be bootstrapped",
+	})
+}
+
+type Preflight struct {
+}
+
+func (p *Preflight) Run(cmd *cobra.Command, args []string) error {
+	r := rancherd.New(rancherd.Config{
+		DataDir:    rancherd.DefaultDataDir,
+		ConfigPath: rancherd.DefaultConfigFile,
+	})
+	return r.Preflight(cmd.Context())
+}
@@ -0,0 +1,31 @@
+package prepare
+
+import (
+	"github.com/rancher/rancherd/pkg/rancherd"
+	cli "github.com/rancher/wrangler-cli"
+	"github.com/spf13/cobra"
+)
+
+func NewPrepare() *cobra.Command {
+	return cli.Command(&Prepare{}, cobra.Command{
+		Short: "Prepare bootstrap when building a machine image",
+		Long: `Prepare bootstrap when building a machine image
+
+The versions of the config are resolved and the installer images are staged
+locally and extracted with their install scripts, without generating a token
+or installing Kubernetes. On first boot bootstrap uses the prepared versions
+and runs the installers from the extracted images as long as the versions of
+the config were not changed.`,
+	})
+}
+
+type Prepare struct {
+}
+
+func (p *Prepare) Run(cmd *cobra.Command, args []string) error {
+	r := rancherd.New(rancherd.Config{
+		DataDir:    rancherd.DefaultDataDir,
+		ConfigPath: rancherd.DefaultConfigFile,
+	})
+	return r.Prepare(cmd.Context())
+}
@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/plan"
 	"github.com/rancher/rancherd/pkg/probe"
+	"github.com/rancher/rancherd/pkg/rancherd"
 	cli "github.com/rancher/wrangler-cli"
 	"github.com/spf13/cobra"
 )
@@ -18,7 +21,8 @@ func NewProbe() *cobra.Command {
 
 type Probe struct {
 	Interval string `usage:"Polling interval to run probes" default:"2s" short:"i"`
-	File     string `usage:"Plan file" default:"/var/lib/rancher/rancherd/plan/plan.json" short:"f"`
+	File     string `usage:"Plan file, defaults to the plan of the rancherd data dir" short:"f"`
+	Role     string `usage:"Wait for the runtime to be installed and run the probes of a node with this role instead of the probes of the plan"`
 }
 
 func (p *Probe) Run(cmd *cobra.Command, args []string) error {
@@ -27,5 +31,9 @@ func (p *Probe) Run(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("parsing duration %s: %w", p.Interval, err)
 	}
 
-	return probe.RunProbes(cmd.Context(), p.File, interval)
+	file := p.File
+	if file == "" {
+		file = plan.GetPlanFile(paths.Host(rancherd.DefaultDataDir))
+	}
+	return probe.RunProbes(cmd.Context(), file, interval, p.Role)
 }
@@ -0,0 +1,29 @@
+package reset
+
+import (
+	"github.com/rancher/rancherd/pkg/rancherd"
+	cli "github.com/rancher/wrangler-cli"
+	"github.com/spf13/cobra"
+)
+
+func NewReset() *cobra.Command {
+	return cli.Command(&Reset{}, cobra.Command{
+		Short: "Uninstall Rancher and Kubernetes and remove all files written by bootstrap",
+	})
+}
+
+type Reset struct {
+	Drain bool `usage:"Drain and delete the node from the cluster before uninstalling"`
+	Force bool `usage:"Run without prompting for confirmation" short:"f"`
+}
+
+func (b *Reset) Run(cmd *cobra.Command, args []string) error {
+	r := rancherd.New(rancherd.Config{
+		Force:      b.Force,
+		DataDir:    rancherd.DefaultDataDir,
+		ConfigPath: rancherd.DefaultConfigFile,
+	})
+	return r.Reset(cmd.Context(), rancherd.ResetConfig{
+		Drain: b.Drain,
+	})
+}
@@ -13,7 +13,7 @@ func NewResetAdmin() *cobra.Command {
 }
 
 type ResetAdmin struct {
-	Password     string `usage:"Password for Rancher login" env:"PASSWORD"`
+	Password     string `usage:"Password for Rancher login, or a file://, env:// or tpm-sealed:// reference to it" env:"PASSWORD"`
 	PasswordFile string `usage:"Password for Rancher login, from file" env:"PASSWORD_FILE"`
 	Kubeconfig   string `usage:"Kubeconfig file" env:"KUBECONFIG"`
 }
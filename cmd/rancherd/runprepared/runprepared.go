@@ -0,0 +1,28 @@
+package runprepared
+
+import (
+	"fmt"
+
+	"github.com/rancher/rancherd/pkg/plan"
+	cli "github.com/rancher/wrangler-cli"
+	"github.com/spf13/cobra"
+)
+
+func NewRunPrepared() *cobra.Command {
+	return cli.Command(&RunPrepared{}, cobra.Command{
+		Use:                "run-prepared DIR COMMAND [ARG...]",
+		Short:              "Run command in an image dir extracted by prepare",
+		DisableFlagParsing: true,
+		Hidden:             true,
+	})
+}
+
+type RunPrepared struct {
+}
+
+func (p *RunPrepared) Run(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: %s", cmd.Use)
+	}
+	return plan.RunPrepared(cmd.Context(), args[0], args[1:])
+}
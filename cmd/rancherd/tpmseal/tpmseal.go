@@ -0,0 +1,53 @@
+package tpmseal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/tpm"
+	cli "github.com/rancher/wrangler-cli"
+	"github.com/spf13/cobra"
+)
+
+func NewTPMSeal() *cobra.Command {
+	return cli.Command(&TPMSeal{}, cobra.Command{
+		Use:   "tpm-seal",
+		Short: "Seal a secret read from stdin to this machine's TPM and print a tpm-sealed:// reference for the config",
+	})
+}
+
+type TPMSeal struct {
+	PCRs []string `usage:"Bind the secret to the current values of these PCRs, for example 0,7" name:"pcrs"`
+}
+
+func (p *TPMSeal) Run(cmd *cobra.Command, args []string) error {
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	pcrs, err := tpm.ParsePCRs(p.PCRs)
+	if err != nil {
+		return err
+	}
+
+	t, err := tpm.New(tpm.Options{})
+	if err != nil {
+		return err
+	}
+
+	sealed, err := t.Seal([]byte(strings.TrimSpace(string(data))), pcrs)
+	if err != nil {
+		return err
+	}
+
+	str, err := sealed.Encode()
+	if err != nil {
+		return err
+	}
+	fmt.Println(config.TPMSealedRefPrefix + str)
+	return nil
+}
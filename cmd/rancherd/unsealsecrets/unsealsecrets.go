@@ -0,0 +1,27 @@
+package unsealsecrets
+
+import (
+	"github.com/rancher/rancherd/pkg/rancherd"
+	cli "github.com/rancher/wrangler-cli"
+	"github.com/spf13/cobra"
+)
+
+func NewUnsealSecrets() *cobra.Command {
+	return cli.Command(&UnsealSecrets{}, cobra.Command{
+		Use:    "unseal-secrets",
+		Short:  "Unseal the secrets sealed to the TPM for the runtime, run before the runtime starts",
+		Hidden: true,
+	})
+}
+
+type UnsealSecrets struct {
+	DataDir string `usage:"Path to rancherd state" default:"/var/lib/rancher/rancherd"`
+}
+
+func (u *UnsealSecrets) Run(cmd *cobra.Command, args []string) error {
+	r := rancherd.New(rancherd.Config{
+		DataDir:    u.DataDir,
+		ConfigPath: rancherd.DefaultConfigFile,
+	})
+	return r.UnsealSecrets(cmd.Context())
+}
@@ -1,15 +1,46 @@
 package upgrade
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/rancher/rancherd/pkg/rancherd"
+	"github.com/rancher/rancherd/pkg/selfupdate"
 	cli "github.com/rancher/wrangler-cli"
 	"github.com/spf13/cobra"
 )
 
 func NewUpgrade() *cobra.Command {
-	return cli.Command(&Upgrade{}, cobra.Command{
+	upgrade := cli.Command(&Upgrade{}, cobra.Command{
 		Short: "Upgrade Rancher and Kubernetes",
+		Long: `Upgrade Rancher and Kubernetes
+
+With --plan-only the upgrade is only planned and the exit code is 0 if there is
+nothing to upgrade, 2 if an upgrade is available and 3 if the target Kubernetes
+version is not compatible with the installed runtime.
+
+With --self only the rancherd binary is upgraded. The new binary is extracted
+from the rancherd installer image of --rancherd-version or read from --file,
+verified against its checksum and, with --public-key, its signature, and then
+replaces the running binary. The replaced binary is kept next to it with a
+.prev suffix and restored by --self --rollback.`,
 	})
+	upgrade.AddCommand(cli.Command(&Status{}, cobra.Command{
+		Short: "Show the upgrade progress of every node of the cluster",
+	}))
+	return upgrade
+}
+
+type Status struct {
+	Watch bool `usage:"Print the status again whenever it changes" short:"w"`
+}
+
+func (s *Status) Run(cmd *cobra.Command, args []string) error {
+	r := rancherd.New(rancherd.Config{
+		DataDir:    rancherd.DefaultDataDir,
+		ConfigPath: rancherd.DefaultConfigFile,
+	})
+	return r.UpgradeStatus(cmd.Context(), s.Watch)
 }
 
 type Upgrade struct {
@@ -17,6 +48,18 @@ type Upgrade struct {
 	RancherOSVersion  string `usage:"Target RancherOS version" short:"o" default:"latest" name:"rancher-os-version"`
 	KubernetesVersion string `usage:"Target Kubernetes version" short:"k" default:"stable"`
 	Force             bool   `usage:"Run without prompting for confirmation" short:"f"`
+	Snapshot          bool   `usage:"Save an etcd snapshot before upgrading and abort if it fails"`
+	PlanOnly          bool   `usage:"Print the upgrade plan without upgrading"`
+	Output            string `usage:"Output format of --plan-only, text or json" default:"text"`
+	Self              bool   `usage:"Only upgrade the rancherd binary"`
+	Rollback          bool   `usage:"With --self, restore the rancherd binary replaced by the last upgrade"`
+	RancherdVersion   string `usage:"Upgrade rancherd to this version before upgrading the cluster"`
+	RancherdImage     string `usage:"Installer image to extract the new rancherd binary from"`
+	File              string `usage:"Local rancherd binary to upgrade to"`
+	Checksum          string `usage:"Expected SHA256 checksum of the new rancherd binary"`
+	PublicKey         string `usage:"PEM ECDSA public key to verify the signature of the new rancherd binary"`
+	Signature         string `usage:"Signature of the new rancherd binary, defaults to rancherd.sig of the image"`
+	Insecure          bool   `usage:"Verify the new rancherd binary only against the checksums of its image when there is no --checksum or --public-key"`
 }
 
 func (b *Upgrade) Run(cmd *cobra.Command, args []string) error {
@@ -25,9 +68,54 @@ func (b *Upgrade) Run(cmd *cobra.Command, args []string) error {
 		DataDir:    rancherd.DefaultDataDir,
 		ConfigPath: rancherd.DefaultConfigFile,
 	})
-	return r.Upgrade(cmd.Context(), rancherd.UpgradeConfig{
+	upgradeConfig := rancherd.UpgradeConfig{
 		RancherVersion:    b.RancherVersion,
 		KubernetesVersion: b.KubernetesVersion,
 		RancherOSVersion:  b.RancherOSVersion,
-	})
+		Snapshot:          b.Snapshot,
+		RancherdVersion:   b.RancherdVersion,
+		SelfUpdate: selfupdate.Options{
+			Image:     b.RancherdImage,
+			File:      b.File,
+			Checksum:  b.Checksum,
+			PublicKey: b.PublicKey,
+			Signature: b.Signature,
+			Insecure:  b.Insecure,
+		},
+	}
+
+	if b.Rollback && !b.Self {
+		return fmt.Errorf("--rollback is only supported with --self")
+	}
+	if b.Self {
+		if b.Rollback {
+			return r.SelfRollback(cmd.Context())
+		}
+		opts := upgradeConfig.SelfUpdate
+		opts.Version = b.RancherdVersion
+		return r.SelfUpgrade(cmd.Context(), opts)
+	}
+	if b.File != "" || b.RancherdImage != "" {
+		return fmt.Errorf("--file and --rancherd-image are only supported with --self")
+	}
+
+	if !b.PlanOnly {
+		if b.Output != "text" {
+			return fmt.Errorf("--output is only supported with --plan-only")
+		}
+		return r.Upgrade(cmd.Context(), upgradeConfig)
+	}
+
+	upgradePlan, err := r.PlanUpgrade(cmd.Context(), upgradeConfig)
+	if err != nil {
+		return err
+	}
+	if err := upgradePlan.Print(os.Stdout, b.Output); err != nil {
+		return err
+	}
+	if b.Output == "text" {
+		upgradePlan.PrintInstructions(os.Stdout)
+	}
+	os.Exit(upgradePlan.ExitCode())
+	return nil
 }
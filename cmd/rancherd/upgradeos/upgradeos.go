@@ -0,0 +1,48 @@
+package upgradeos
+
+import (
+	"os"
+	"time"
+
+	rancheros "github.com/rancher/rancherd/pkg/os"
+	cli "github.com/rancher/wrangler-cli"
+	"github.com/spf13/cobra"
+)
+
+func NewUpgradeOS() *cobra.Command {
+	return cli.Command(&UpgradeOS{}, cobra.Command{
+		Use:    "upgrade-os",
+		Short:  "Set the image of the ManagedOSImage and wait for all nodes to be upgraded to it",
+		Hidden: true,
+	})
+}
+
+type UpgradeOS struct {
+	Kubeconfig  string `usage:"Kubeconfig file" env:"KUBECONFIG"`
+	OSImage     string `usage:"Image to upgrade the nodes to" name:"os-image"`
+	Timeout     string `usage:"Fail if not all nodes are upgraded within this duration" default:"30m"`
+	NodeTimeout string `usage:"Fail if a node makes no progress within this duration" default:"15m"`
+	NodeName    string `usage:"Name of this node, which is not waited for as it reboots when upgraded, defaults to the hostname"`
+}
+
+func (u *UpgradeOS) Run(cmd *cobra.Command, args []string) error {
+	timeout, err := time.ParseDuration(u.Timeout)
+	if err != nil {
+		return err
+	}
+	nodeTimeout, err := time.ParseDuration(u.NodeTimeout)
+	if err != nil {
+		return err
+	}
+	nodeName := u.NodeName
+	if nodeName == "" {
+		nodeName, _ = os.Hostname()
+	}
+	return rancheros.Upgrade(cmd.Context(), rancheros.UpgradeOptions{
+		Kubeconfig:  u.Kubeconfig,
+		OSImage:     u.OSImage,
+		NodeName:    nodeName,
+		Timeout:     timeout,
+		NodeTimeout: nodeTimeout,
+	})
+}
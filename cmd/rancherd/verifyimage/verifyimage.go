@@ -0,0 +1,33 @@
+package verifyimage
+
+import (
+	"github.com/rancher/rancherd/pkg/imageverify"
+	cli "github.com/rancher/wrangler-cli"
+	"github.com/spf13/cobra"
+)
+
+func NewVerifyImage() *cobra.Command {
+	return cli.Command(&VerifyImage{}, cobra.Command{
+		Use:    "verify-image",
+		Short:  "Verify the digest and signature of an installer image before it is run",
+		Hidden: true,
+	})
+}
+
+type VerifyImage struct {
+	Image          string `usage:"Image to verify"`
+	Digest         string `usage:"Expected manifest digest of the image"`
+	PublicKeys     string `usage:"File with the PEM ECDSA public keys the image must be signed with" name:"public-keys"`
+	LockFile       string `usage:"File recording the digest of the image the first time it is verified" name:"lock-file"`
+	RegistriesFile string `usage:"Private registry config of the runtime" name:"registries-file"`
+}
+
+func (v *VerifyImage) Run(cmd *cobra.Command, args []string) error {
+	return imageverify.Verify(cmd.Context(), imageverify.Options{
+		Image:          v.Image,
+		Digest:         v.Digest,
+		PublicKeysFile: v.PublicKeys,
+		LockFile:       v.LockFile,
+		RegistriesFile: v.RegistriesFile,
+	})
+}
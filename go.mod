@@ -35,8 +35,13 @@ replace (
 require (
 	github.com/google/certificate-transparency-go v1.1.2
 	github.com/google/go-attestation v0.3.2
+	github.com/google/go-containerregistry v0.5.0
+	github.com/google/go-tpm v0.3.2
+	github.com/google/go-tpm-tools v0.3.2
 	github.com/gorilla/websocket v1.4.2
 	github.com/hashicorp/go-discover v0.0.0-20201029210230-738cb3105cd0
+	github.com/klauspost/compress v1.15.9
+	github.com/pierrec/lz4 v2.6.0+incompatible
 	github.com/pkg/errors v0.9.1
 	github.com/rancher/rancher/pkg/apis v0.0.0-20210920193801-79027c456224
 	github.com/rancher/system-agent v0.0.1-alpha30
@@ -98,9 +103,7 @@ require (
 	github.com/google/gnostic v0.7.0 // indirect
 	github.com/google/gnostic-models v0.6.9-0.20230804172637-c7be7c783f49 // indirect
 	github.com/google/go-cmp v0.6.0 // indirect
-	github.com/google/go-containerregistry v0.5.0 // indirect
 	github.com/google/go-querystring v1.0.0 // indirect
-	github.com/google/go-tpm v0.3.2 // indirect
 	github.com/google/go-tspi v0.2.1-0.20190423175329-115dea689aad // indirect
 	github.com/google/gofuzz v1.2.0 // indirect
 	github.com/google/s2a-go v0.1.4 // indirect
@@ -118,7 +121,6 @@ require (
 	github.com/josharian/intern v1.0.0 // indirect
 	github.com/joyent/triton-go v0.0.0-20180628001255-830d2b111e62 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
-	github.com/klauspost/compress v1.15.9 // indirect
 	github.com/linode/linodego v0.7.1 // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
 	github.com/matttproud/golang_protobuf_extensions/v2 v2.0.0 // indirect
@@ -131,7 +133,6 @@ require (
 	github.com/opencontainers/go-digest v1.0.0 // indirect
 	github.com/opencontainers/image-spec v1.0.2 // indirect
 	github.com/packethost/packngo v0.1.1-0.20180711074735-b9cb5096f54c // indirect
-	github.com/pierrec/lz4 v2.6.0+incompatible // indirect
 	github.com/prometheus/client_golang v1.17.0 // indirect
 	github.com/prometheus/client_model v0.5.0 // indirect
 	github.com/prometheus/common v0.45.0 // indirect
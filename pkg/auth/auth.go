@@ -8,7 +8,10 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/rancher/rancherd/pkg/config"
 	"github.com/rancher/rancherd/pkg/kubectl"
+	"github.com/rancher/rancherd/pkg/runtime"
+	"github.com/rancher/rancherd/pkg/tpm"
 	"github.com/rancher/wrangler/pkg/randomtoken"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
@@ -68,7 +71,14 @@ func resetAdmin(ctx context.Context, kubeconfig, password, passwordFile string)
 	}
 	mustChangePassword := true
 	if password != "" {
-		token = password
+		t, err := tpm.New(tpm.Options{})
+		if err != nil {
+			return err
+		}
+		token, err = config.ResolveSecret(t, password)
+		if err != nil {
+			return err
+		}
 		mustChangePassword = false
 	}
 	if passwordFile != "" {
@@ -294,7 +304,7 @@ func printServerURL(ctx context.Context, nodeClient corev1interface.NodeInterfac
 
 // getServerURL reads the possible serverUrl in following order
 // 1. First fetch from server-url setting from rancher
-// 2. Fetch From tls-san set in rke2 config
+// 2. Fetch From tls-san set in the config of the installed runtime
 // 3. Fetch the externalNodeIP then internalNodeIP
 func getServerURL(ctx context.Context, nodeClient corev1interface.NodeInterface, settingClient dynamic.NamespaceableResourceInterface) (string, error) {
 	serverURLSettings, err := settingClient.Get(ctx, "server-url", v1.GetOptions{})
@@ -383,7 +393,12 @@ func setConditionToFalse(object map[string]interface{}, cond string) {
 }
 
 func readTLSSan() (string, error) {
-	bytes, err := ioutil.ReadFile("/etc/rancher/rke2/config.yaml")
+	runtimeName, err := kubectl.InstalledRuntime()
+	if err != nil {
+		return "", err
+	}
+
+	bytes, err := ioutil.ReadFile(runtime.Get(runtimeName).ConfigFile())
 	if err != nil && !os.IsNotExist(err) {
 		return "", err
 	}
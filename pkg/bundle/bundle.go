@@ -0,0 +1,837 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/images"
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/runtime"
+	"github.com/rancher/rancherd/pkg/versions"
+	"github.com/rancher/wharfie/pkg/registries"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	imagesDir     = "/var/lib/rancher/agent/images"
+	signaturesDir = "/var/lib/rancher/agent/signatures"
+
+	manifestFile = "manifest.yaml"
+	configFile   = "config.yaml"
+	channelsFile = "channels.yaml"
+	// loadedFile records which bundle was extracted to the bundle dir of the data dir
+	loadedFile = "loaded.yaml"
+	ociDir     = "oci"
+	chartsDir  = "charts"
+	// refNameAnnotation is the OCI layout annotation of the image reference
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+	// imagePrefix is the prefix of the image tarballs written by Load
+	imagePrefix = "rancherd-bundle-"
+	// signatureSuffix is the tag suffix of cosign signatures
+	signatureSuffix = ".sig"
+	// signatureAnnotation is the annotation of a cosign signature layer holding the
+	// base64 signature of the layer
+	signatureAnnotation = "dev.cosignproject.cosign/signature"
+)
+
+var (
+	// savedImage matches the file names of SaveImage, the name ends with the digest of the
+	// image manifest as it was pulled from the registry
+	savedImage = regexp.MustCompile(`^` + regexp.QuoteMeta(imagePrefix) + `(.+)-([0-9a-f]{64})\.tar$`)
+)
+
+// ImagesDir returns where the image utility of plans looks for image tarballs before
+// pulling an image
+func ImagesDir() string {
+	return paths.Host(imagesDir)
+}
+
+// SignaturesDir returns where the cosign signatures of the images in ImagesDir are kept.
+// Signatures are not kept as image tarballs as those do not have the annotations of the
+// signature layers.
+func SignaturesDir() string {
+	return paths.Host(signaturesDir)
+}
+
+// Signature is a cosign signature of an image
+type Signature struct {
+	// Payload is the simple signing payload that is signed
+	Payload []byte `json:"payload"`
+	// Signature is the base64 signature of the payload
+	Signature string `json:"signature"`
+}
+
+// Manifest describes the contents of a bundle
+type Manifest struct {
+	Created           time.Time      `json:"created"`
+	KubernetesVersion string         `json:"kubernetesVersion"`
+	RancherVersion    string         `json:"rancherVersion"`
+	Images            []images.Image `json:"images"`
+	// Chart is the path of the Rancher chart in the bundle
+	Chart string `json:"chart,omitempty"`
+}
+
+// Channels are the channels resolved when the bundle was created, the version of each
+// channel. Load resolves these channels to the bundled versions without the channel server.
+type Channels struct {
+	Kubernetes map[string]string `json:"kubernetes,omitempty"`
+	Rancher    map[string]string `json:"rancher,omitempty"`
+}
+
+// loaded identifies the bundle tarball extracted by Load
+type loaded struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+type CreateOptions struct {
+	Config *config.Config
+	// KubernetesVersion and RancherVersion are the resolved versions to bundle
+	KubernetesVersion string
+	RancherVersion    string
+	// Channels are the channels resolved to KubernetesVersion and RancherVersion
+	Channels Channels
+	// Images are additional images to bundle
+	Images []string
+	// ChartRepo is the Helm repository of the Rancher chart, the chart is not bundled if empty
+	ChartRepo      string
+	RegistriesFile string
+	// Output is the path of the bundle tarball
+	Output string
+	// NodePath is the path of the bundle on the nodes, used in the generated config
+	NodePath string
+}
+
+// Create pulls the images of the plans for the versions and their cosign signatures
+// and writes them as an OCI layout, together with the Rancher chart, a manifest, the
+// resolved channels and a config snippet, to a gzipped tarball
+func Create(ctx context.Context, opts CreateOptions) (*Manifest, error) {
+	dir, err := ioutil.TempDir("", "rancherd-bundle")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := &Manifest{
+		Created:           time.Now().UTC(),
+		KubernetesVersion: opts.KubernetesVersion,
+		RancherVersion:    opts.RancherVersion,
+		Images:            images.List(opts.Config, runtime.ForVersion(opts.KubernetesVersion).Name(), opts.KubernetesVersion, opts.RancherVersion, ""),
+	}
+	for _, image := range opts.Images {
+		manifest.Images = append(manifest.Images, images.Image{
+			Image:  image,
+			Source: image,
+			Use:    "additional",
+		})
+	}
+
+	if err := pullImages(ctx, filepath.Join(dir, ociDir), manifest.Images, opts.RegistriesFile); err != nil {
+		return nil, err
+	}
+
+	if opts.ChartRepo != "" {
+		chart, err := downloadChart(ctx, dir, opts.ChartRepo, opts.RancherVersion)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Chart = chart
+	}
+
+	if err := writeYAML(filepath.Join(dir, manifestFile), manifest); err != nil {
+		return nil, err
+	}
+
+	if err := writeYAML(filepath.Join(dir, channelsFile), opts.Channels); err != nil {
+		return nil, err
+	}
+
+	bundleConfig := map[string]interface{}{
+		"kubernetesVersion": opts.KubernetesVersion,
+		"rancherVersion":    opts.RancherVersion,
+		"bundle":            opts.NodePath,
+	}
+	if opts.Config.SystemDefaultRegistry != "" {
+		bundleConfig["systemDefaultRegistry"] = opts.Config.SystemDefaultRegistry
+	}
+	if err := writeYAML(filepath.Join(dir, configFile), bundleConfig); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Writing bundle %s", opts.Output)
+	return manifest, writeTarball(dir, opts.Output)
+}
+
+// Load extracts the bundle to dataDir and writes its images as tarballs to ImagesDir, so
+// plans use them instead of pulling the images. The channels of the bundle are resolved
+// to the bundled versions. A bundle is only extracted once, loading it again only reads
+// its manifest and channels.
+func Load(path, dataDir string) (*Manifest, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	current := loaded{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UTC(),
+	}
+
+	dir := bundleDir(dataDir)
+	if previous, err := readLoaded(dir); err != nil || previous == nil || *previous != current {
+		if err := extract(path, dir, current); err != nil {
+			return nil, err
+		}
+	} else {
+		logrus.Infof("Bundle %s is already loaded", path)
+	}
+
+	manifest := &Manifest{}
+	if err := readYAML(filepath.Join(dir, manifestFile), manifest); err != nil {
+		return nil, fmt.Errorf("%s is not a rancherd bundle: %w", path, err)
+	}
+
+	channels := &Channels{}
+	if err := readYAML(filepath.Join(dir, channelsFile), channels); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading channels of bundle %s: %w", path, err)
+	}
+	for channel, version := range channels.Kubernetes {
+		versions.SetK8sVersion(channel, version)
+	}
+	for channel, version := range channels.Rancher {
+		versions.SetRancherVersion(channel, version)
+	}
+
+	return manifest, nil
+}
+
+// Chart returns the path of the Rancher chart of rancherVersion in the bundle loaded to
+// dataDir, or an empty string if no loaded bundle has the chart
+func Chart(dataDir, rancherVersion string) string {
+	dir := bundleDir(dataDir)
+	manifest := &Manifest{}
+	if err := readYAML(filepath.Join(dir, manifestFile), manifest); err != nil {
+		return ""
+	}
+	if manifest.Chart == "" || manifest.RancherVersion != rancherVersion {
+		return ""
+	}
+	return filepath.Join(dir, manifest.Chart)
+}
+
+func bundleDir(dataDir string) string {
+	return filepath.Join(dataDir, "bundle")
+}
+
+// extract extracts the bundle at path to dir and writes its images to ImagesDir. The
+// loaded record is written last, so a failed extraction is retried.
+func extract(path, dir string, current loaded) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := extractTarball(path, dir); err != nil {
+		return fmt.Errorf("extracting bundle %s: %w", path, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, manifestFile)); err != nil {
+		return fmt.Errorf("%s is not a rancherd bundle: %w", path, err)
+	}
+
+	if err := writeImages(filepath.Join(dir, ociDir)); err != nil {
+		return err
+	}
+
+	// the images are kept in ImagesDir, only keep the manifest, channels, config and chart
+	if err := os.RemoveAll(filepath.Join(dir, ociDir)); err != nil {
+		return err
+	}
+	return writeYAML(filepath.Join(dir, loadedFile), current)
+}
+
+func readLoaded(dir string) (*loaded, error) {
+	result := &loaded{}
+	if err := readYAML(filepath.Join(dir, loadedFile), result); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Stage pulls the images and their cosign signatures and writes them as tarballs to
+// ImagesDir, so plans use them instead of pulling the images. Images already written by
+// Stage or Load are skipped.
+func Stage(ctx context.Context, list []images.Image, registriesFile string) error {
+	puller, err := newPuller(registriesFile)
+	if err != nil {
+		return err
+	}
+
+	for _, image := range list {
+		img, err := puller.pull(ctx, image.Source)
+		if err != nil {
+			return err
+		}
+		tag, err := name.NewTag(image.Image)
+		if err != nil {
+			return err
+		}
+		if err := SaveImage(tag, img); err != nil {
+			return err
+		}
+
+		sigTag, sig, err := puller.pullSignature(ctx, image, img)
+		if err != nil {
+			return err
+		} else if sig != nil {
+			if err := SaveSignatures(sigTag, sig); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Pull pulls image with the private registry config in registriesFile, like the image
+// utility of plans
+func Pull(ctx context.Context, image, registriesFile string) (v1.Image, error) {
+	puller, err := newPuller(registriesFile)
+	if err != nil {
+		return nil, err
+	}
+	return puller.pull(ctx, image)
+}
+
+// SignatureTag returns the tag of the cosign signature of the image with the manifest digest
+func SignatureTag(repo name.Repository, digest v1.Hash) name.Tag {
+	return repo.Tag(fmt.Sprintf("%s-%s%s", digest.Algorithm, digest.Hex, signatureSuffix))
+}
+
+func pullImages(ctx context.Context, dir string, list []images.Image, registriesFile string) error {
+	puller, err := newPuller(registriesFile)
+	if err != nil {
+		return err
+	}
+
+	oci, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		return err
+	}
+
+	for _, image := range list {
+		img, err := puller.pull(ctx, image.Source)
+		if err != nil {
+			return err
+		}
+
+		// the image is stored with the name it is pulled with, not the source it is mirrored from
+		if err := oci.AppendImage(img, layout.WithAnnotations(map[string]string{
+			refNameAnnotation: image.Image,
+		})); err != nil {
+			return fmt.Errorf("writing image %s: %w", image.Image, err)
+		}
+
+		sigTag, sig, err := puller.pullSignature(ctx, image, img)
+		if err != nil {
+			return err
+		} else if sig == nil {
+			continue
+		}
+		if err := oci.AppendImage(sig, layout.WithAnnotations(map[string]string{
+			refNameAnnotation: sigTag.Name(),
+		})); err != nil {
+			return fmt.Errorf("writing signature %s: %w", sigTag.Name(), err)
+		}
+	}
+	return nil
+}
+
+// puller pulls images with the private registry config of the runtime, like the image
+// utility of plans
+type puller struct {
+	registry privateRegistry
+	keychain authn.Keychain
+}
+
+// privateRegistry is the private registry config returned by registries.GetPrivateRegistries
+type privateRegistry interface {
+	authn.Keychain
+	http.RoundTripper
+	Rewrite(ref name.Reference) name.Reference
+}
+
+func newPuller(registriesFile string) (*puller, error) {
+	registry, err := registries.GetPrivateRegistries(registriesFile)
+	if err != nil {
+		return nil, err
+	}
+	return &puller{
+		registry: registry,
+		keychain: authn.NewMultiKeychain(registry, authn.DefaultKeychain),
+	}, nil
+}
+
+func (p *puller) pull(ctx context.Context, image string) (v1.Image, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Pulling image %s", ref.Name())
+	img, err := remote.Image(p.registry.Rewrite(ref), p.options(ctx)...)
+	if err != nil {
+		return nil, fmt.Errorf("%v: failed to get image %s", err, ref.Name())
+	}
+	return img, nil
+}
+
+// pullSignature pulls the cosign signature of img, which was pulled from the source of
+// image. The returned tag is the signature tag in the repository of the image. A nil
+// image is returned if the image is not signed.
+func (p *puller) pullSignature(ctx context.Context, image images.Image, img v1.Image) (name.Tag, v1.Image, error) {
+	digest, err := img.Digest()
+	if err != nil {
+		return name.Tag{}, nil, err
+	}
+	source, err := name.ParseReference(image.Source)
+	if err != nil {
+		return name.Tag{}, nil, err
+	}
+	target, err := name.ParseReference(image.Image)
+	if err != nil {
+		return name.Tag{}, nil, err
+	}
+
+	sourceTag := SignatureTag(source.Context(), digest)
+	desc, err := remote.Head(p.registry.Rewrite(sourceTag), p.options(ctx)...)
+	if err != nil || desc == nil {
+		logrus.Debugf("No signature %s: %v", sourceTag.Name(), err)
+		return name.Tag{}, nil, nil
+	}
+
+	sig, err := p.pull(ctx, sourceTag.Name())
+	return SignatureTag(target.Context(), digest), sig, err
+}
+
+func (p *puller) options(ctx context.Context) []remote.Option {
+	return []remote.Option{
+		remote.WithAuthFromKeychain(p.keychain),
+		remote.WithTransport(p.registry),
+		remote.WithContext(ctx),
+	}
+}
+
+func writeImages(dir string) error {
+	index, err := layout.ImageIndexFromPath(dir)
+	if err != nil {
+		return err
+	}
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range indexManifest.Manifests {
+		refName := desc.Annotations[refNameAnnotation]
+		if refName == "" {
+			continue
+		}
+		tag, err := name.NewTag(refName)
+		if err != nil {
+			return err
+		}
+		img, err := index.Image(desc.Digest)
+		if err != nil {
+			return err
+		}
+		if isSignatureTag(tag) {
+			err = SaveSignatures(tag, img)
+		} else {
+			err = SaveImage(tag, img)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveImage writes img as a tarball to ImagesDir, unless it was already saved. The manifest
+// the image was pulled with is written next to the tarball, FindImage checks the tarball
+// against it. Saved copies of tag with another digest are removed, so plans always use the
+// last saved image.
+func SaveImage(tag name.Tag, img v1.Image) error {
+	digest, err := img.Digest()
+	if err != nil {
+		return err
+	}
+
+	fileName := imageFileName(tag)
+	target := filepath.Join(ImagesDir(), fmt.Sprintf("%s%s-%s.tar", imagePrefix, fileName, digest.Hex))
+	if _, err := os.Stat(target); err == nil {
+		logrus.Infof("Image %s is already loaded", tag.Name())
+		return nil
+	}
+
+	if err := os.MkdirAll(ImagesDir(), 0755); err != nil {
+		return err
+	}
+
+	logrus.Infof("Loading image %s", tag.Name())
+	manifest, err := img.RawManifest()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(savedManifest(target), manifest, 0644); err != nil {
+		return err
+	}
+	tmp := target + ".tmp"
+	if err := tarball.WriteToFile(tmp, tag, img); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing image %s: %w", tag.Name(), err)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(ImagesDir())
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		match := savedImage.FindStringSubmatch(file.Name())
+		if match != nil && match[1] == fileName && match[2] != digest.Hex {
+			logrus.Infof("Removing previously loaded image %s", file.Name())
+			path := filepath.Join(ImagesDir(), file.Name())
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			if err := os.Remove(savedManifest(path)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FindImage returns the image of tag in ImagesDir and the digest of its manifest, both
+// read from the tarball the image utility of plans would run. For tarballs written by
+// SaveImage this is the digest of the manifest the image was pulled with, once the config
+// and layers of the tarball are checked against it. Other tarballs do not have the original
+// manifest, the digest of their manifest is returned instead, which only matches the
+// registry if the image was pulled with the same manifest format. The image utility picks
+// any tarball with the image, so an error is returned if more than one has it. Nil is
+// returned if no tarball has the image.
+func FindImage(tag name.Tag) (v1.Image, v1.Hash, error) {
+	files, err := findImageTarballs(tag)
+	if err != nil {
+		return nil, v1.Hash{}, err
+	}
+	switch len(files) {
+	case 0:
+		return nil, v1.Hash{}, nil
+	case 1:
+	default:
+		return nil, v1.Hash{}, fmt.Errorf("image %s is in more than one tarball, keep only one of %s",
+			tag.Name(), strings.Join(files, ", "))
+	}
+
+	opener, err := tarballOpener(files[0])
+	if err != nil {
+		return nil, v1.Hash{}, err
+	}
+	img, err := tarball.Image(opener, &tag)
+	if err != nil {
+		return nil, v1.Hash{}, fmt.Errorf("reading image %s: %w", files[0], err)
+	}
+
+	if !savedImage.MatchString(filepath.Base(files[0])) {
+		digest, err := img.Digest()
+		return img, digest, err
+	}
+	digest, err := checkSavedManifest(files[0], img)
+	return img, digest, err
+}
+
+// checkSavedManifest returns the digest of the manifest SaveImage wrote next to file, if
+// the config and layers of img, hashed from the content of file, are the ones of the
+// manifest
+func checkSavedManifest(file string, img v1.Image) (v1.Hash, error) {
+	raw, err := ioutil.ReadFile(savedManifest(file))
+	if err != nil {
+		return v1.Hash{}, fmt.Errorf("reading manifest of image %s: %w", file, err)
+	}
+	digest, _, err := v1.SHA256(bytes.NewReader(raw))
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	manifest, err := v1.ParseManifest(bytes.NewReader(raw))
+	if err != nil {
+		return v1.Hash{}, fmt.Errorf("parsing manifest of image %s: %w", file, err)
+	}
+
+	content, err := img.Manifest()
+	if err != nil {
+		return v1.Hash{}, fmt.Errorf("reading image %s: %w", file, err)
+	}
+	if content.Config.Digest != manifest.Config.Digest {
+		return v1.Hash{}, fmt.Errorf("image %s has config %s, its manifest %s has %s", file,
+			content.Config.Digest, digest, manifest.Config.Digest)
+	}
+	if len(content.Layers) != len(manifest.Layers) {
+		return v1.Hash{}, fmt.Errorf("image %s has %d layers, its manifest %s has %d", file,
+			len(content.Layers), digest, len(manifest.Layers))
+	}
+	for i := range content.Layers {
+		if content.Layers[i].Digest != manifest.Layers[i].Digest {
+			return v1.Hash{}, fmt.Errorf("image %s has layer %s, its manifest %s has %s", file,
+				content.Layers[i].Digest, digest, manifest.Layers[i].Digest)
+		}
+	}
+	return digest, nil
+}
+
+func savedManifest(file string) string {
+	return strings.TrimSuffix(file, ".tar") + ".manifest.json"
+}
+
+// Signatures returns the cosign signatures in the signature image sig
+func Signatures(sig v1.Image) (result []Signature, _ error) {
+	manifest, err := sig.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range manifest.Layers {
+		signature, ok := desc.Annotations[signatureAnnotation]
+		if !ok {
+			continue
+		}
+		layer, err := sig.LayerByDigest(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return nil, err
+		}
+		payload, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, Signature{
+			Payload:   payload,
+			Signature: signature,
+		})
+	}
+	return result, nil
+}
+
+// SaveSignatures writes the signatures of the cosign signature image sig to SignaturesDir
+func SaveSignatures(tag name.Tag, sig v1.Image) error {
+	signatures, err := Signatures(sig)
+	if err != nil {
+		return fmt.Errorf("reading signature %s: %w", tag.Name(), err)
+	}
+	data, err := json.Marshal(signatures)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(SignaturesDir(), 0755); err != nil {
+		return err
+	}
+
+	logrus.Infof("Loading signature %s", tag.Name())
+	return ioutil.WriteFile(filepath.Join(SignaturesDir(), imageFileName(tag)+".json"), data, 0644)
+}
+
+// FindSignatures returns the signatures of tag written by SaveSignatures, nil if there are none
+func FindSignatures(tag name.Tag) ([]Signature, error) {
+	data, err := ioutil.ReadFile(filepath.Join(SignaturesDir(), imageFileName(tag)+".json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var signatures []Signature
+	return signatures, json.Unmarshal(data, &signatures)
+}
+
+func isSignatureTag(tag name.Tag) bool {
+	return strings.HasPrefix(tag.TagStr(), "sha256-") && strings.HasSuffix(tag.TagStr(), signatureSuffix)
+}
+
+func imageFileName(tag name.Tag) string {
+	return strings.NewReplacer("/", "_", ":", "_").Replace(tag.Name())
+}
+
+func downloadChart(ctx context.Context, dir, repo, rancherVersion string) (string, error) {
+	chart := fmt.Sprintf("rancher-%s.tgz", strings.TrimPrefix(rancherVersion, "v"))
+	url := strings.TrimSuffix(repo, "/") + "/" + chart
+
+	logrus.Infof("Downloading chart %s", url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("downloading chart %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloading chart %s: %s", url, resp.Status)
+	}
+
+	path := filepath.Join(chartsDir, chart)
+	if err := os.MkdirAll(filepath.Join(dir, chartsDir), 0755); err != nil {
+		return "", err
+	}
+	f, err := os.Create(filepath.Join(dir, path))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
+
+func readYAML(path string, obj interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, obj)
+}
+
+func writeYAML(path string, obj interface{}) error {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func writeTarball(dir, output string) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(tw, in)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func extractTarball(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, header.Name)
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path %s in bundle", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
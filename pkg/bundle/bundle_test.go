@@ -0,0 +1,161 @@
+package bundle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/versions"
+)
+
+func writeTestBundle(t *testing.T, output string) {
+	t.Helper()
+	dir := t.TempDir()
+	if _, err := layout.Write(filepath.Join(dir, ociDir), empty.Index); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeYAML(filepath.Join(dir, manifestFile), &Manifest{
+		KubernetesVersion: "v1.22.3+k3s1",
+		RancherVersion:    "v2.6.2",
+		Chart:             "charts/rancher-2.6.2.tgz",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeYAML(filepath.Join(dir, channelsFile), &Channels{
+		Kubernetes: map[string]string{"bundle-test-stable": "v1.22.3+k3s1"},
+		Rancher:    map[string]string{"bundle-test-stable": "v2.6.2"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, chartsDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, chartsDir, "rancher-2.6.2.tgz"), []byte("chart"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTarball(dir, output); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Setenv(paths.RootEnv, t.TempDir())
+	dataDir := t.TempDir()
+	path := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	writeTestBundle(t, path)
+
+	manifest, err := Load(path, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.KubernetesVersion != "v1.22.3+k3s1" || manifest.RancherVersion != "v2.6.2" {
+		t.Errorf("manifest = %+v", manifest)
+	}
+
+	// the channels resolve to the bundled versions without the channel servers
+	if version, err := versions.K8sVersion("bundle-test-stable"); err != nil || version != "v1.22.3+k3s1" {
+		t.Errorf("Kubernetes channel resolved to %q, %v", version, err)
+	}
+	if version, err := versions.RancherVersion("bundle-test-stable"); err != nil || version != "v2.6.2" {
+		t.Errorf("Rancher channel resolved to %q, %v", version, err)
+	}
+
+	chart := Chart(dataDir, "v2.6.2")
+	if data, err := ioutil.ReadFile(chart); err != nil || string(data) != "chart" {
+		t.Errorf("chart %q = %q, %v", chart, data, err)
+	}
+	if chart := Chart(dataDir, "v2.6.3"); chart != "" {
+		t.Errorf("chart of another Rancher version = %q", chart)
+	}
+
+	// the bundle is not extracted again
+	marker := filepath.Join(bundleDir(dataDir), "marker")
+	if err := ioutil.WriteFile(marker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path, dataDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("bundle was extracted again: %v", err)
+	}
+
+	// a changed bundle is extracted again
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path, dataDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("changed bundle was not extracted again: %v", err)
+	}
+}
+
+func TestFindImage(t *testing.T) {
+	t.Setenv(paths.RootEnv, t.TempDir())
+	tag, err := name.NewTag("rancher/system-agent-installer-k3s:v1.22.3-k3s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _, err := FindImage(tag); err != nil || found != nil {
+		t.Fatalf("FindImage without images = %v, %v", found, err)
+	}
+
+	if err := SaveImage(tag, img); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := FindImage(tag); err != nil || found != digest {
+		t.Fatalf("FindImage = %s, %v, expected %s", found, err, digest)
+	}
+
+	// the digest is checked against the content of the tarball, not its file name
+	files, err := findImageTarballs(tag)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("tarballs = %v, %v", files, err)
+	}
+	saved, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tarball.WriteToFile(files[0], tag, other); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := FindImage(tag); err == nil {
+		t.Errorf("expected a replaced tarball to not match its manifest")
+	}
+	if err := ioutil.WriteFile(files[0], saved, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the image utility of plans could run any tarball with the image
+	if err := tarball.WriteToFile(filepath.Join(ImagesDir(), "other.tar"), tag, other); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := FindImage(tag); err == nil || !strings.Contains(err.Error(), "more than one") {
+		t.Errorf("expected the image in two tarballs to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package bundle
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/rancher/wharfie/pkg/tarfile"
+	"github.com/rancher/wharfie/pkg/util"
+)
+
+// findImageTarballs returns the tarballs in ImagesDir with the image of tag. It looks at
+// the same files as the image utility of plans, which runs the image of any of them.
+func findImageTarballs(tag name.Tag) ([]string, error) {
+	var result []string
+	err := filepath.Walk(ImagesDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") || !util.HasSuffixI(info.Name(), tarfile.SupportedExtensions...) {
+			return nil
+		}
+		opener, err := tarballOpener(path)
+		if err != nil {
+			return err
+		}
+		// like the image utility, files that can not be read do not have the image
+		if _, err := tarball.Image(opener, &tag); err == nil {
+			result = append(result, path)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	sort.Strings(result)
+	return result, err
+}
+
+// tarballOpener opens the tarball path, decompressing it by its extension like the image
+// utility of plans
+func tarballOpener(path string) (tarball.Opener, error) {
+	switch {
+	case util.HasSuffixI(path, ".tar"):
+		return func() (io.ReadCloser, error) {
+			return os.Open(path)
+		}, nil
+	case util.HasSuffixI(path, ".tar.lz4"):
+		return func() (io.ReadCloser, error) {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			return tarfile.SplitReadCloser(lz4.NewReader(file), file), nil
+		}, nil
+	case util.HasSuffixI(path, ".tar.bz2", ".tbz"):
+		return func() (io.ReadCloser, error) {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			return tarfile.SplitReadCloser(bzip2.NewReader(file), file), nil
+		}, nil
+	case util.HasSuffixI(path, ".tar.gz", ".tgz"):
+		return func() (io.ReadCloser, error) {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			zr, err := gzip.NewReader(file)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			return tarfile.MultiReadCloser(zr, file), nil
+		}, nil
+	case util.HasSuffixI(path, ".tar.zst", ".tzst"):
+		return func() (io.ReadCloser, error) {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			zr, err := zstd.NewReader(file, zstd.WithDecoderMaxMemory(tarfile.MaxDecoderMemory))
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			return tarfile.ZstdReadCloser(zr, file), nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported image tarball %s", path)
+}
@@ -14,11 +14,19 @@ import (
 	url2 "net/url"
 	"time"
 
+	"github.com/rancher/rancherd/pkg/paths"
 	"github.com/rancher/rancherd/pkg/tpm"
 	"github.com/rancher/system-agent/pkg/applyinator"
 	"github.com/rancher/wrangler/pkg/randomtoken"
 )
 
+const caAnchorFile = "/etc/pki/trust/anchors/embedded-rancher-ca.pem"
+
+// CAAnchorFile returns the trust anchor for the CA of the server joined
+func CAAnchorFile() string {
+	return paths.Host(caAnchorFile)
+}
+
 var insecureClient = &http.Client{
 	Timeout: time.Second * 5,
 	Transport: &http.Transport{
@@ -29,18 +37,35 @@ var insecureClient = &http.Client{
 	},
 }
 
+type response struct {
+	data       []byte
+	caChecksum string
+	etag       string
+}
+
 func Get(server, token, path string) ([]byte, string, error) {
-	return get(server, token, path, true)
+	resp, err := get(nil, server, token, path, true, "")
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.data, resp.caChecksum, nil
 }
 
-func MachineGet(server, token, path string) ([]byte, string, error) {
-	return get(server, token, path, false)
+// MachineGet downloads path authenticated with a machine token, a tpm:// token is
+// resolved with t. If etag is set it is sent as If-None-Match and tpm.ErrNotModified
+// is returned if the content did not change. The ETag of the content is returned.
+func MachineGet(t *tpm.TPM, server, token, path, etag string) ([]byte, string, error) {
+	resp, err := get(t, server, token, path, false, etag)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.data, resp.etag, nil
 }
 
-func get(server, token, path string, clusterToken bool) ([]byte, string, error) {
+func get(t *tpm.TPM, server, token, path string, clusterToken bool, etag string) (*response, error) {
 	u, err := url2.Parse(server)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 	u.Path = path
 
@@ -48,35 +73,45 @@ func get(server, token, path string, clusterToken bool) ([]byte, string, error)
 		isTPM bool
 	)
 	if !clusterToken {
-		isTPM, token, err = tpm.ResolveToken(token)
+		isTPM, token, err = t.ResolveToken(token)
 		if err != nil {
-			return nil, "", err
+			return nil, err
 		}
 	}
 
 	cacert, caChecksum, err := CACerts(server, token, clusterToken)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 
 	if isTPM {
-		data, err := tpm.Get(cacert, u.String(), nil)
-		return data, caChecksum, err
+		data, respETag, err := t.GetIfNoneMatch(cacert, u.String(), etag)
+		if err != nil {
+			return nil, err
+		}
+		return &response{
+			data:       data,
+			caChecksum: caChecksum,
+			etag:       respETag,
+		}, nil
 	}
 
 	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 	if !clusterToken {
 		req.Header.Set("Authorization", "Bearer "+base64.StdEncoding.EncodeToString([]byte(token)))
 	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
 
 	var resp *http.Response
 	if len(cacert) == 0 {
 		resp, err = http.DefaultClient.Do(req)
 		if err != nil {
-			return nil, "", err
+			return nil, err
 		}
 	} else {
 		pool := x509.NewCertPool()
@@ -94,15 +129,24 @@ func get(server, token, path string, clusterToken bool) ([]byte, string, error)
 
 		resp, err = client.Do(req)
 		if err != nil {
-			return nil, "", err
+			return nil, err
 		}
 	}
 
+	defer resp.Body.Close()
+
+	if etag != "" && resp.StatusCode == http.StatusNotModified {
+		return nil, tpm.ErrNotModified
+	}
 	data, err := ioutil.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("%s: %s", data, resp.Status)
+		return nil, fmt.Errorf("%s: %s", data, resp.Status)
 	}
-	return data, caChecksum, err
+	return &response{
+		data:       data,
+		caChecksum: caChecksum,
+		etag:       resp.Header.Get("ETag"),
+	}, err
 }
 
 func CACerts(server, token string, clusterToken bool) ([]byte, string, error) {
@@ -132,7 +176,7 @@ func CACerts(server, token string, clusterToken bool) ([]byte, string, error) {
 		return nil, "", err
 	}
 	req.Header.Set("X-Cattle-Nonce", nonce)
-	req.Header.Set("Authorization", "Bearer "+hashBase64([]byte(token)))
+	req.Header.Set("Authorization", "Bearer "+HashBase64([]byte(token)))
 
 	resp, err := insecureClient.Do(req)
 	if err != nil {
@@ -149,10 +193,10 @@ func CACerts(server, token string, clusterToken bool) ([]byte, string, error) {
 		return nil, "", fmt.Errorf("response %d: %s getting cacerts: %s", resp.StatusCode, resp.Status, data)
 	}
 
-	if resp.Header.Get("X-Cattle-Hash") != hash(token, nonce, data) {
+	if resp.Header.Get("X-Cattle-Hash") != Hash(token, nonce, data) {
 		return nil, "", fmt.Errorf("response hash (%s) does not match (%s)",
 			resp.Header.Get("X-Cattle-Hash"),
-			hash(token, nonce, data))
+			Hash(token, nonce, data))
 	}
 
 	if len(data) == 0 {
@@ -180,7 +224,7 @@ func ToFile(server, token string) (*applyinator.File, error) {
 
 	return &applyinator.File{
 		Content:     base64.StdEncoding.EncodeToString(cacert),
-		Path:        "/etc/pki/trust/anchors/embedded-rancher-ca.pem",
+		Path:        CAAnchorFile(),
 		Permissions: "0644",
 	}, nil
 }
@@ -190,12 +234,16 @@ func hashHex(token []byte) string {
 	return hex.EncodeToString(hash[:])
 }
 
-func hashBase64(token []byte) string {
+// HashBase64 returns the base64 encoded SHA256 of token, which is sent as the bearer
+// token when downloading the cacerts
+func HashBase64(token []byte) string {
 	hash := sha256.Sum256(token)
 	return base64.StdEncoding.EncodeToString(hash[:])
 }
 
-func hash(token, nonce string, bytes []byte) string {
+// Hash returns the value of the X-Cattle-Hash header, which proves that the server
+// serving the cacerts knows the token
+func Hash(token, nonce string, bytes []byte) string {
 	digest := hmac.New(sha512.New, []byte(token))
 	digest.Write([]byte(nonce))
 	digest.Write([]byte{0})
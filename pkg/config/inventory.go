@@ -0,0 +1,159 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rancher/rancherd/pkg/tpm"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	inventoryCacheFile = "inventory-cache"
+	// encryptedPrefix marks a cache encrypted with a key derived from the token
+	encryptedPrefix = "encrypted://"
+)
+
+// InventoryCache is the last config downloaded from the machine inventory. It is
+// stored in the data dir, sealed to the TPM if the token is tpm:// and otherwise
+// encrypted with the token.
+type InventoryCache struct {
+	Server string `json:"server"`
+	// Version is the SHA256 of the downloaded config
+	Version string `json:"version"`
+	// ETag is the ETag of the config sent by the server, sent back in If-None-Match
+	ETag    string                 `json:"etag,omitempty"`
+	Fetched time.Time              `json:"fetched"`
+	Config  map[string]interface{} `json:"config"`
+}
+
+// InventoryCachePath returns the path of the inventory cache in dataDir
+func InventoryCachePath(dataDir string) string {
+	return filepath.Join(dataDir, inventoryCacheFile)
+}
+
+func newInventoryCache(server, etag string, data []byte) (*InventoryCache, error) {
+	cache := &InventoryCache{
+		Server:  server,
+		ETag:    etag,
+		Fetched: time.Now().UTC(),
+	}
+	if err := json.Unmarshal(data, &cache.Config); err != nil {
+		return nil, fmt.Errorf("inventory response: %s: %w", data, err)
+	}
+	hash := sha256.Sum256(data)
+	cache.Version = hex.EncodeToString(hash[:])
+	return cache, nil
+}
+
+// ReadInventoryCache returns the cached inventory config in dataDir, or nil if there is none
+func ReadInventoryCache(t *tpm.TPM, dataDir, token string) (*InventoryCache, error) {
+	if dataDir == "" {
+		return nil, nil
+	}
+
+	path := InventoryCachePath(dataDir)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	data, err := decryptInventoryCache(t, token, strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	cache := &InventoryCache{}
+	if err := json.Unmarshal(data, cache); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return cache, nil
+}
+
+func writeInventoryCache(t *tpm.TPM, dataDir, token string, cache *InventoryCache) error {
+	if dataDir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	content, err := encryptInventoryCache(t, token, data)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return fmt.Errorf("mkdir %s: %w", dataDir, err)
+	}
+	return ioutil.WriteFile(InventoryCachePath(dataDir), []byte(content+"\n"), 0600)
+}
+
+func encryptInventoryCache(t *tpm.TPM, token string, data []byte) (string, error) {
+	if isTPMToken(token) {
+		sealed, err := t.Seal(data, nil)
+		if err != nil {
+			return "", fmt.Errorf("sealing inventory cache: %w", err)
+		}
+		str, err := sealed.Encode()
+		if err != nil {
+			return "", err
+		}
+		return TPMSealedRefPrefix + str, nil
+	}
+
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", err
+	}
+	key := tokenKey(token)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(secretbox.Seal(nonce[:], data, &nonce, &key)), nil
+}
+
+func decryptInventoryCache(t *tpm.TPM, token, content string) ([]byte, error) {
+	if strings.HasPrefix(content, TPMSealedRefPrefix) {
+		sealed, err := tpm.DecodeSealedData(strings.TrimPrefix(content, TPMSealedRefPrefix))
+		if err != nil {
+			return nil, err
+		}
+		return t.Unseal(sealed)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(content, encryptedPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [24]byte
+	if len(data) < len(nonce) {
+		return nil, fmt.Errorf("invalid encrypted data")
+	}
+	copy(nonce[:], data)
+	key := tokenKey(token)
+	result, ok := secretbox.Open(nil, data[len(nonce):], &nonce, &key)
+	if !ok {
+		return nil, fmt.Errorf("decrypting failed, the token may have changed")
+	}
+	return result, nil
+}
+
+func tokenKey(token string) [32]byte {
+	return sha256.Sum256([]byte("rancherd inventory cache\x00" + token))
+}
+
+func isTPMToken(token string) bool {
+	return strings.HasPrefix(token, "tpm://")
+}
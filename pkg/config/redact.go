@@ -0,0 +1,90 @@
+package config
+
+import (
+	"encoding/base64"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// minSecretLength is the shortest value that will be redacted. Redacting shorter
+	// values would mangle unrelated output more than it protects the secret.
+	minSecretLength = 4
+
+	redacted = "--redacted--"
+)
+
+var (
+	secretsLock sync.RWMutex
+	secrets     = map[string]bool{}
+	replacer    = strings.NewReplacer()
+)
+
+// AddSecrets registers values that must not be persisted or logged in plain text.
+// The values are replaced in the output of Redact as is and base64 encoded, as
+// they appear in the data of Kubernetes secrets.
+func AddSecrets(values ...string) {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+
+	changed := false
+	for _, value := range values {
+		if len(value) < minSecretLength || secrets[value] {
+			continue
+		}
+		secrets[value] = true
+		changed = true
+	}
+	if !changed {
+		return
+	}
+
+	olds := map[string]string{}
+	for secret := range secrets {
+		olds[secret] = redacted
+		olds[base64.StdEncoding.EncodeToString([]byte(secret))] = base64.StdEncoding.EncodeToString([]byte(redacted))
+	}
+
+	// strings.Replacer tries the old strings in argument order, so a secret that is the
+	// prefix of another secret must come after it or the tail of the longer secret is kept
+	sorted := make([]string, 0, len(olds))
+	for old := range olds {
+		sorted = append(sorted, old)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	replacements := make([]string, 0, 2*len(sorted))
+	for _, old := range sorted {
+		replacements = append(replacements, old, olds[old])
+	}
+	replacer = strings.NewReplacer(replacements...)
+}
+
+// Redact replaces all secrets registered with AddSecrets in str
+func Redact(str string) string {
+	secretsLock.RLock()
+	defer secretsLock.RUnlock()
+	return replacer.Replace(str)
+}
+
+// RedactingFormatter is a logrus.Formatter that redacts all registered secrets
+// from the output of the wrapped Formatter
+type RedactingFormatter struct {
+	logrus.Formatter
+}
+
+func (r *RedactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	data, err := r.Formatter.Format(entry)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(Redact(string(data))), nil
+}
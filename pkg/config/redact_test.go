@@ -0,0 +1,47 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRedactPrefix(t *testing.T) {
+	// the token is the prefix of the registry password, whichever is added first
+	AddSecrets("prefix-token")
+	AddSecrets("prefix-token-registry-password")
+
+	for i := 0; i < 10; i++ {
+		str := Redact("token prefix-token password prefix-token-registry-password")
+		if str != "token "+redacted+" password "+redacted {
+			t.Fatalf("Redact = %q", str)
+		}
+	}
+
+	encoded := base64.StdEncoding.EncodeToString([]byte("prefix-token-registry-password"))
+	if str := Redact(encoded); str != base64.StdEncoding.EncodeToString([]byte(redacted)) {
+		t.Errorf("Redact of base64 password = %q", str)
+	}
+}
+
+func TestRedactingFormatter(t *testing.T) {
+	AddSecrets("log-token")
+
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&RedactingFormatter{
+		Formatter: &logrus.TextFormatter{DisableTimestamp: true},
+	})
+	logger.WithField("token", "log-token").Infof("joining with token log-token")
+
+	if strings.Contains(out.String(), "log-token") {
+		t.Errorf("log output has the token: %s", out.String())
+	}
+	if !strings.Contains(out.String(), redacted) {
+		t.Errorf("log output is not redacted: %s", out.String())
+	}
+}
@@ -1,29 +1,34 @@
 package config
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/rancher/rancherd/pkg/cacerts"
+	"github.com/rancher/rancherd/pkg/tpm"
 	"github.com/rancher/wrangler/pkg/data"
 	"github.com/rancher/wrangler/pkg/data/convert"
 	"github.com/sirupsen/logrus"
 )
 
-func processRemote(cfg Config) (Config, error) {
+const inventoryAttempts = 5
+
+// inventoryBackoff is the wait before the first retry of an inventory download, it doubles
+// with every retry
+var inventoryBackoff = 2 * time.Second
+
+func processRemote(ctx context.Context, cfg Config, dataDir string) (Config, error) {
 	if cfg.Role != "" || cfg.Server == "" || cfg.Token == "" {
 		return cfg, nil
 	}
 
 	logrus.Infof("server and token set but required role is not set. Trying to bootstrapping config from machine inventory")
-	resp, _, err := cacerts.MachineGet(cfg.Server, cfg.Token, "/v1-rancheros/inventory")
+	cache, err := fetchInventory(ctx, cfg, dataDir)
 	if err != nil {
-		return cfg, fmt.Errorf("from machine inventory: %w", err)
-	}
-
-	config := map[string]interface{}{}
-	if err := json.Unmarshal(resp, &config); err != nil {
-		return cfg, fmt.Errorf("inventory response: %s: %w", resp, err)
+		return cfg, err
 	}
 
 	currentConfig, err := convert.EncodeToMap(cfg)
@@ -32,7 +37,7 @@ func processRemote(cfg Config) (Config, error) {
 	}
 
 	var (
-		newConfig = data.MergeMapsConcatSlice(currentConfig, config)
+		newConfig = data.MergeMapsConcatSlice(currentConfig, cache.Config)
 		result    Config
 	)
 
@@ -40,12 +45,90 @@ func processRemote(cfg Config) (Config, error) {
 		return result, err
 	}
 
-	copyConfig := result
-	copyConfig.Token = "--redacted--"
-	downloadedConfig, err := json.Marshal(copyConfig)
+	// the inventory can reference secrets too, they are resolved once merged
+	if err := resolveSecrets(&result); err != nil {
+		return result, err
+	}
+
+	downloadedConfig, err := json.Marshal(result)
 	if err == nil {
-		logrus.Infof("Downloaded config: %s", downloadedConfig)
+		logrus.Infof("Downloaded config: %s", Redact(string(downloadedConfig)))
 	}
 
 	return result, nil
 }
+
+// fetchInventory downloads the config from the machine inventory, retrying with backoff, and
+// caches it in dataDir. The cached config is not downloaded again if the server still has
+// its ETag, and it is returned if the server can not be reached.
+func fetchInventory(ctx context.Context, cfg Config, dataDir string) (*InventoryCache, error) {
+	t, err := NewTPM(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	cached, err := ReadInventoryCache(t, dataDir, cfg.Token)
+	if err != nil {
+		logrus.Warnf("ignoring machine inventory cache: %v", err)
+		cached = nil
+	}
+	if cached != nil && cached.Server != cfg.Server {
+		cached = nil
+	}
+
+	var (
+		resp    []byte
+		etag    string
+		newETag string
+		backoff = inventoryBackoff
+	)
+	if cached != nil {
+		etag = cached.ETag
+	}
+
+	for attempt := 1; attempt <= inventoryAttempts; attempt++ {
+		// the ETag of the cache is sent on every attempt, a failed attempt returns none
+		resp, newETag, err = cacerts.MachineGet(t, cfg.Server, cfg.Token, "/v1-rancheros/inventory", etag)
+		if err == nil || errors.Is(err, tpm.ErrNotModified) || attempt == inventoryAttempts {
+			break
+		}
+		logrus.Warnf("failed to download config from machine inventory (attempt %d/%d), retrying in %s: %v",
+			attempt, inventoryAttempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	var cache *InventoryCache
+	switch {
+	case errors.Is(err, tpm.ErrNotModified):
+		logrus.Infof("Machine inventory config is unchanged (version %s)", cached.Version)
+		cache = cached
+		cache.Fetched = time.Now().UTC()
+	case err != nil:
+		if cached == nil {
+			return nil, fmt.Errorf("from machine inventory: %w", err)
+		}
+		logrus.Warnf("failed to download config from machine inventory, using the config cached at %s (version %s): %v",
+			cached.Fetched.Format(time.RFC3339), cached.Version, err)
+		return cached, nil
+	default:
+		cache, err = newInventoryCache(cfg.Server, newETag, resp)
+		if err != nil {
+			return nil, err
+		}
+		if cached != nil && cached.Version == cache.Version {
+			logrus.Infof("Machine inventory config is unchanged (version %s)", cache.Version)
+		} else {
+			logrus.Infof("Downloaded machine inventory config version %s", cache.Version)
+		}
+	}
+
+	if err := writeInventoryCache(t, dataDir, cfg.Token, cache); err != nil {
+		logrus.Warnf("failed to cache machine inventory config: %v", err)
+	}
+	return cache, nil
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/rancherd/pkg/cacerts"
+)
+
+func TestFetchInventoryRetryETag(t *testing.T) {
+	const (
+		token = "machine-token"
+		etag  = `"inventory-v1"`
+	)
+	inventoryBackoff = time.Millisecond
+	defer func() {
+		inventoryBackoff = 2 * time.Second
+	}()
+
+	var (
+		lock        sync.Mutex
+		ifNoneMatch []string
+	)
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	mux.HandleFunc("/v1-rancheros/cacerts", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Cattle-Hash", cacerts.Hash(token, req.Header.Get("X-Cattle-Nonce"), caCert))
+		_, _ = rw.Write(caCert)
+	})
+	mux.HandleFunc("/v1-rancheros/inventory", func(rw http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		ifNoneMatch = append(ifNoneMatch, req.Header.Get("If-None-Match"))
+		attempt := len(ifNoneMatch)
+		lock.Unlock()
+
+		if attempt == 1 {
+			http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if req.Header.Get("If-None-Match") == etag {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+		rw.Header().Set("ETag", `"inventory-v2"`)
+		_, _ = rw.Write([]byte(`{"role":"agent"}`))
+	})
+
+	cfg := Config{
+		RuntimeConfig: RuntimeConfig{
+			Token: token,
+		},
+		Server: server.URL,
+	}
+	tpm, err := NewTPM(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataDir := t.TempDir()
+	cached, err := newInventoryCache(server.URL, etag, []byte(`{"role":"server"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeInventoryCache(tpm, dataDir, token, cached); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := fetchInventory(context.Background(), cfg, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ifNoneMatch) != 2 || ifNoneMatch[0] != etag || ifNoneMatch[1] != etag {
+		t.Errorf("If-None-Match of the attempts = %q, expected %s twice", ifNoneMatch, etag)
+	}
+	if cache.ETag != etag || cache.Config["role"] != "server" {
+		t.Errorf("cache = ETag %s, role %s, expected the unchanged cached config", cache.ETag, cache.Config["role"])
+	}
+}
@@ -1,7 +1,5 @@
 package config
 
-import "strings"
-
 var (
 	RuntimeRKE2    Runtime = "rke2"
 	RuntimeK3S     Runtime = "k3s"
@@ -21,14 +19,3 @@ type RuntimeConfig struct {
 	Token           string                 `json:"token,omitempty"`
 	ConfigValues    map[string]interface{} `json:"extraConfig,omitempty"`
 }
-
-func GetRuntime(kubernetesVersion string) Runtime {
-	if isRKE2(kubernetesVersion) {
-		return RuntimeRKE2
-	}
-	return RuntimeK3S
-}
-
-func isRKE2(kubernetesVersion string) bool {
-	return strings.Contains(kubernetesVersion, string(RuntimeRKE2))
-}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/tpm"
+	"github.com/rancher/wharfie/pkg/registries"
+)
+
+const (
+	// FileRefPrefix references a secret stored in a local file, for example file:///etc/rancher/token.
+	// The path is prefixed with the root, like all host paths.
+	FileRefPrefix = "file://"
+	// EnvRefPrefix references a secret stored in an environment variable, for example env://TOKEN
+	EnvRefPrefix = "env://"
+	// TPMSealedRefPrefix references a secret sealed to the local TPM, either inline as the output
+	// of "rancherd tpm-seal" or as a path to a file containing it, for example tpm-sealed:///etc/rancher/token.sealed
+	TPMSealedRefPrefix = "tpm-sealed://"
+)
+
+// IsSecretRef returns true if value is a reference to a secret instead of the secret itself
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, FileRefPrefix) ||
+		strings.HasPrefix(value, EnvRefPrefix) ||
+		strings.HasPrefix(value, TPMSealedRefPrefix)
+}
+
+// ResolveSecret returns the value of the secret referenced by value. If value is not
+// a reference it is returned as is. Sealed secrets are unsealed with t.
+func ResolveSecret(t *tpm.TPM, value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, FileRefPrefix):
+		path := paths.Host(strings.TrimPrefix(value, FileRefPrefix))
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading secret from %s: %w", path, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	case strings.HasPrefix(value, EnvRefPrefix):
+		name := strings.TrimPrefix(value, EnvRefPrefix)
+		result, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("reading secret from environment variable %s: not set", name)
+		}
+		return result, nil
+	case strings.HasPrefix(value, TPMSealedRefPrefix):
+		return resolveTPMSealed(t, strings.TrimPrefix(value, TPMSealedRefPrefix))
+	}
+	return value, nil
+}
+
+func resolveTPMSealed(t *tpm.TPM, blob string) (string, error) {
+	if strings.HasPrefix(blob, "/") {
+		path := paths.Host(blob)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading sealed secret from %s: %w", path, err)
+		}
+		blob = strings.TrimPrefix(strings.TrimSpace(string(data)), TPMSealedRefPrefix)
+	}
+
+	sealed, err := tpm.DecodeSealedData(blob)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := t.Unseal(sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func resolveSecrets(cfg *Config) error {
+	t, err := NewTPM(*cfg)
+	if err != nil {
+		return err
+	}
+
+	cfg.Token, err = ResolveSecret(t, cfg.Token)
+	if err != nil {
+		return fmt.Errorf("resolving token: %w", err)
+	}
+
+	if cfg.Registries != nil {
+		registry, err := resolveRegistrySecrets(t, *cfg.Registries)
+		if err != nil {
+			return fmt.Errorf("resolving registries: %w", err)
+		}
+		cfg.Registries = &registry
+	}
+
+	AddSecrets(cfg.Secrets()...)
+	return nil
+}
+
+func resolveRegistrySecrets(t *tpm.TPM, registry registries.Registry) (registries.Registry, error) {
+	return mapRegistryAuth(registry, func(auth registries.AuthConfig) (registries.AuthConfig, error) {
+		var err error
+		for _, value := range []*string{&auth.Username, &auth.Password, &auth.Auth, &auth.IdentityToken} {
+			*value, err = ResolveSecret(t, *value)
+			if err != nil {
+				return auth, err
+			}
+		}
+		return auth, nil
+	})
+}
+
+// mapRegistryAuth returns a copy of registry with f applied to every auth config
+func mapRegistryAuth(registry registries.Registry, f func(registries.AuthConfig) (registries.AuthConfig, error)) (registries.Registry, error) {
+	result := registries.Registry{
+		Mirrors: registry.Mirrors,
+	}
+
+	if registry.Configs != nil {
+		result.Configs = map[string]registries.RegistryConfig{}
+		for name, config := range registry.Configs {
+			if config.Auth != nil {
+				auth, err := f(*config.Auth)
+				if err != nil {
+					return result, fmt.Errorf("auth for %s: %w", name, err)
+				}
+				config.Auth = &auth
+			}
+			result.Configs[name] = config
+		}
+	}
+
+	if registry.Auths != nil {
+		result.Auths = map[string]registries.AuthConfig{}
+		for name, auth := range registry.Auths {
+			auth, err := f(auth)
+			if err != nil {
+				return result, fmt.Errorf("auth for %s: %w", name, err)
+			}
+			result.Auths[name] = auth
+		}
+	}
+
+	return result, nil
+}
+
+// Secrets returns the resolved values of all secrets in the config. Secrets are
+// registered with AddSecrets when the config is loaded.
+func (c Config) Secrets() (result []string) {
+	if c.Token != "" {
+		result = append(result, c.Token)
+	}
+	if c.Registries != nil {
+		_, _ = mapRegistryAuth(*c.Registries, func(auth registries.AuthConfig) (registries.AuthConfig, error) {
+			for _, value := range []string{auth.Password, auth.Auth, auth.IdentityToken} {
+				if value != "" {
+					result = append(result, value)
+				}
+			}
+			return auth, nil
+		})
+	}
+	return
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/wharfie/pkg/registries"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_REGISTRY_PASSWORD", "registry-password")
+
+	cfg := Config{
+		RuntimeConfig: RuntimeConfig{
+			Token: FileRefPrefix + tokenFile,
+		},
+		Registries: &registries.Registry{
+			Configs: map[string]registries.RegistryConfig{
+				"registry.example.com": {
+					Auth: &registries.AuthConfig{
+						Username: "user",
+						Password: EnvRefPrefix + "TEST_REGISTRY_PASSWORD",
+					},
+				},
+			},
+		},
+	}
+
+	if err := resolveSecrets(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Token != "file-token" {
+		t.Errorf("token = %q, expected file-token", cfg.Token)
+	}
+	if password := cfg.Registries.Configs["registry.example.com"].Auth.Password; password != "registry-password" {
+		t.Errorf("registry password = %q, expected registry-password", password)
+	}
+
+	// resolved secrets are redacted from logs and persisted files
+	if str := Redact("token file-token"); str != "token "+redacted {
+		t.Errorf("Redact = %q", str)
+	}
+}
+
+func TestResolveSecretsMissing(t *testing.T) {
+	cfg := Config{
+		RuntimeConfig: RuntimeConfig{
+			Token: EnvRefPrefix + "TEST_MISSING_TOKEN",
+		},
+	}
+	if err := resolveSecrets(&cfg); err == nil {
+		t.Fatal("expected an error for an unset environment variable")
+	}
+}
+
+func TestResolveSecretRoot(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv(paths.RootEnv, root)
+	if err := os.MkdirAll(filepath.Join(root, "etc/rancher"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "etc/rancher/token"), []byte("root-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file of the root is read, not the file of the running host
+	token, err := ResolveSecret(nil, FileRefPrefix+"/etc/rancher/token")
+	if err != nil || token != "root-token" {
+		t.Errorf("ResolveSecret = %q, %v, expected root-token", token, err)
+	}
+}
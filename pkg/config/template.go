@@ -0,0 +1,105 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	v1 "github.com/rancher/rancher/pkg/apis/rke.cattle.io/v1"
+)
+
+// Render evaluates Go templates found in tlsSans, labels, extraConfig and resources
+// using values, which is normally the node facts from the facts package.
+func Render(cfg Config, values interface{}) (Config, error) {
+	var err error
+
+	cfg.SANS, err = renderStrings(cfg.SANS, values)
+	if err != nil {
+		return cfg, fmt.Errorf("rendering tlsSans: %w", err)
+	}
+
+	cfg.Labels, err = renderStrings(cfg.Labels, values)
+	if err != nil {
+		return cfg, fmt.Errorf("rendering labels: %w", err)
+	}
+
+	if cfg.ConfigValues != nil {
+		configValues, err := renderValue(cfg.ConfigValues, values)
+		if err != nil {
+			return cfg, fmt.Errorf("rendering extraConfig: %w", err)
+		}
+		cfg.ConfigValues = configValues.(map[string]interface{})
+	}
+
+	var resources []v1.GenericMap
+	for _, resource := range cfg.Resources {
+		data, err := renderValue(resource.Data, values)
+		if err != nil {
+			return cfg, fmt.Errorf("rendering resources: %w", err)
+		}
+		resources = append(resources, v1.GenericMap{
+			Data: data.(map[string]interface{}),
+		})
+	}
+	cfg.Resources = resources
+
+	return cfg, nil
+}
+
+func renderStrings(strs []string, values interface{}) (result []string, _ error) {
+	for _, str := range strs {
+		rendered, err := renderString(str, values)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, rendered)
+	}
+	return result, nil
+}
+
+func renderValue(obj interface{}, values interface{}) (interface{}, error) {
+	switch v := obj.(type) {
+	case string:
+		return renderString(v, values)
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			rendered, err := renderValue(value, values)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = rendered
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, 0, len(v))
+		for _, value := range v {
+			rendered, err := renderValue(value, values)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, rendered)
+		}
+		return result, nil
+	default:
+		return obj, nil
+	}
+}
+
+func renderString(str string, values interface{}) (string, error) {
+	if !strings.Contains(str, "{{") {
+		return str, nil
+	}
+
+	t, err := template.New("config").Option("missingkey=error").Parse(str)
+	if err != nil {
+		return "", fmt.Errorf("parsing template %q: %w", str, err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, values); err != nil {
+		return "", fmt.Errorf("executing template %q: %w", str, err)
+	}
+	return buf.String(), nil
+}
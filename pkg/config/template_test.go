@@ -0,0 +1,128 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "github.com/rancher/rancher/pkg/apis/rke.cattle.io/v1"
+)
+
+type testFacts struct {
+	Hostname string
+	IP       string
+}
+
+func TestRender(t *testing.T) {
+	values := testFacts{
+		Hostname: "node1",
+		IP:       "10.0.0.5",
+	}
+
+	cfg := Config{
+		RuntimeConfig: RuntimeConfig{
+			SANS:   []string{"{{ .Hostname }}.example.com", "static.example.com"},
+			Labels: []string{"node={{ .Hostname }}"},
+			ConfigValues: map[string]interface{}{
+				"node-ip": "{{ .IP }}",
+				"kubelet-arg": []interface{}{
+					"node-labels=host={{ .Hostname }}",
+					"max-pods=110",
+				},
+				"etcd-snapshot-retention": 5,
+			},
+		},
+		Resources: []v1.GenericMap{
+			{
+				Data: map[string]interface{}{
+					"kind": "ConfigMap",
+					"data": map[string]interface{}{
+						"ip": "{{ .IP }}",
+					},
+				},
+			},
+		},
+	}
+
+	rendered, err := Render(cfg, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"node1.example.com", "static.example.com"}; !reflect.DeepEqual(rendered.SANS, expected) {
+		t.Errorf("tlsSans = %v, expected %v", rendered.SANS, expected)
+	}
+	if expected := []string{"node=node1"}; !reflect.DeepEqual(rendered.Labels, expected) {
+		t.Errorf("labels = %v, expected %v", rendered.Labels, expected)
+	}
+
+	expectedValues := map[string]interface{}{
+		"node-ip": "10.0.0.5",
+		"kubelet-arg": []interface{}{
+			"node-labels=host=node1",
+			"max-pods=110",
+		},
+		"etcd-snapshot-retention": 5,
+	}
+	if !reflect.DeepEqual(rendered.ConfigValues, expectedValues) {
+		t.Errorf("extraConfig = %v, expected %v", rendered.ConfigValues, expectedValues)
+	}
+
+	expectedResource := map[string]interface{}{
+		"kind": "ConfigMap",
+		"data": map[string]interface{}{
+			"ip": "10.0.0.5",
+		},
+	}
+	if len(rendered.Resources) != 1 || !reflect.DeepEqual(rendered.Resources[0].Data, expectedResource) {
+		t.Errorf("resources = %v, expected %v", rendered.Resources, expectedResource)
+	}
+
+	// the input is not modified
+	if cfg.ConfigValues["node-ip"] != "{{ .IP }}" || cfg.SANS[0] != "{{ .Hostname }}.example.com" {
+		t.Errorf("Render modified the input config")
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		err  string
+	}{
+		{
+			name: "missing value",
+			cfg: Config{
+				RuntimeConfig: RuntimeConfig{
+					SANS: []string{"{{ .Serial }}"},
+				},
+			},
+			err: "rendering tlsSans",
+		},
+		{
+			name: "invalid template",
+			cfg: Config{
+				RuntimeConfig: RuntimeConfig{
+					ConfigValues: map[string]interface{}{
+						"node-name": "{{ .Hostname",
+					},
+				},
+			},
+			err: "rendering extraConfig",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Render(test.cfg, map[string]interface{}{
+				"Hostname": "node1",
+			})
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error %q does not contain %q", err, test.err)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"context"
+	"fmt"
 	"io/fs"
 	"io/ioutil"
 	"os"
@@ -8,6 +10,8 @@ import (
 	"strings"
 
 	v1 "github.com/rancher/rancher/pkg/apis/rke.cattle.io/v1"
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/tpm"
 	"github.com/rancher/system-agent/pkg/applyinator"
 	"github.com/rancher/wharfie/pkg/registries"
 	"github.com/rancher/wrangler/pkg/data"
@@ -41,10 +45,15 @@ var (
 
 type Config struct {
 	RuntimeConfig
-	KubernetesVersion string           `json:"kubernetesVersion,omitempty"`
-	RancherVersion    string           `json:"rancherVersion,omitempty"`
-	Server            string           `json:"server,omitempty"`
-	Discovery         *DiscoveryConfig `json:"discovery,omitempty"`
+	KubernetesVersion string              `json:"kubernetesVersion,omitempty"`
+	RancherVersion    string              `json:"rancherVersion,omitempty"`
+	RancherdVersion   string              `json:"rancherdVersion,omitempty"`
+	Server            string              `json:"server,omitempty"`
+	Discovery         *DiscoveryConfig    `json:"discovery,omitempty"`
+	TPM               *TPMConfig          `json:"tpm,omitempty"`
+	Preflight         *PreflightConfig    `json:"preflight,omitempty"`
+	EtcdSnapshot      *EtcdSnapshotConfig `json:"etcdSnapshot,omitempty"`
+	Bundle            string              `json:"bundle,omitempty"`
 
 	RancherValues    map[string]interface{}    `json:"rancherValues,omitempty"`
 	PreInstructions  []applyinator.Instruction `json:"preInstructions,omitempty"`
@@ -57,6 +66,12 @@ type Config struct {
 	RancherInstallerImage string               `json:"rancherInstallerImage,omitempty"`
 	SystemDefaultRegistry string               `json:"systemDefaultRegistry,omitempty"`
 	Registries            *registries.Registry `json:"registries,omitempty"`
+
+	// RuntimeInstallerDigest and RancherInstallerDigest pin the manifest digests of the
+	// installer images, the plan fails if the pulled image has another digest
+	RuntimeInstallerDigest string                   `json:"runtimeInstallerDigest,omitempty"`
+	RancherInstallerDigest string                   `json:"rancherInstallerDigest,omitempty"`
+	ImageVerification      *ImageVerificationConfig `json:"imageVerification,omitempty"`
 }
 
 type DiscoveryConfig struct {
@@ -67,8 +82,92 @@ type DiscoveryConfig struct {
 	ServerCacheDuration string `json:"serverCacheDuration,omitempty"`
 }
 
-func paths() (result []string) {
-	for _, file := range implicitPaths {
+type TPMConfig struct {
+	// SealSecrets will store the cluster token and the registry credentials sealed to the
+	// local TPM, the runtime reads them unsealed from tmpfs instead of its config files
+	SealSecrets bool `json:"sealSecrets,omitempty"`
+	// PCRs the sealed secrets are bound to. The secrets can only be unsealed while the
+	// SHA256 bank of these PCRs has the values from when they were sealed.
+	PCRs []int `json:"pcrs,omitempty"`
+	// Device is the TPM to use, "hardware" (the default) or "simulator". The
+	// RANCHERD_TPM_DEVICE environment variable takes precedence.
+	Device string `json:"device,omitempty"`
+	// SimulatorAddress is the command address of the TPM simulator, defaults to 127.0.0.1:2321
+	SimulatorAddress string `json:"simulatorAddress,omitempty"`
+	// Quote includes a quote of the PCRs and the boot event log when downloading the config
+	// from the machine inventory so the server can verify how the machine was booted. The
+	// quote is always included if the server asks for it.
+	Quote bool `json:"quote,omitempty"`
+	// EKAlgorithm selects the EK that identifies the machine if the TPM has more than
+	// one, "rsa" (the default) or "ecc". The machine inventory only attests the RSA EK,
+	// so "ecc" can not be used with a tpm:// token.
+	EKAlgorithm string `json:"ekAlgorithm,omitempty"`
+}
+
+// PreflightConfig configures the checks of the host that run before bootstrap
+type PreflightConfig struct {
+	// Skip disables all checks
+	Skip bool `json:"skip,omitempty"`
+	// Severity overrides the severity of a check by its name, a failed check with
+	// severity "fail" aborts bootstrap, "warn" only logs and "ignore" skips the check
+	Severity map[string]string `json:"severity,omitempty"`
+}
+
+// EtcdSnapshotConfig configures the etcd snapshots taken by rancherd
+type EtcdSnapshotConfig struct {
+	// Dir is where snapshots are stored, defaults to the snapshot dir of the runtime
+	Dir string `json:"dir,omitempty"`
+	// BeforeUpgrade takes a snapshot before rancherd upgrade changes the cluster and
+	// aborts the upgrade if the snapshot fails
+	BeforeUpgrade bool `json:"beforeUpgrade,omitempty"`
+}
+
+// ImageVerificationConfig configures the verification of the installer images before
+// they are run
+type ImageVerificationConfig struct {
+	// PublicKeys are PEM ECDSA public keys, or files containing them. If set the installer
+	// images must have a cosign signature made with one of the keys.
+	PublicKeys []string `json:"publicKeys,omitempty"`
+	// Lock records the digest of each installer image the first time it is pulled and
+	// requires the same digest afterwards
+	Lock bool `json:"lock,omitempty"`
+}
+
+// NewTPM returns the TPM selected by the tpm settings of the config
+func NewTPM(cfg Config) (*tpm.TPM, error) {
+	if cfg.TPM == nil {
+		return tpm.New(tpm.Options{})
+	}
+	return tpm.New(tpm.Options{
+		Device:           cfg.TPM.Device,
+		SimulatorAddress: cfg.TPM.SimulatorAddress,
+		Quote:            cfg.TPM.Quote,
+		EKAlgorithm:      cfg.TPM.EKAlgorithm,
+	})
+}
+
+// validateTPM fails if the tpm settings can not be used to download the config. The
+// machine inventory activates the credentials of the attestation with the RSA EK, which
+// must therefore also be the EK identifying the machine.
+func validateTPM(cfg *Config) error {
+	if cfg.TPM == nil || cfg.TPM.EKAlgorithm == "" {
+		return nil
+	}
+	switch cfg.TPM.EKAlgorithm {
+	case tpm.EKAlgorithmRSA:
+	case tpm.EKAlgorithmECC:
+		if strings.HasPrefix(cfg.Token, "tpm://") {
+			return fmt.Errorf("tpm.ekAlgorithm %s can not be used with a tpm:// token, the machine inventory only attests the %s EK",
+				tpm.EKAlgorithmECC, tpm.EKAlgorithmRSA)
+		}
+	default:
+		return fmt.Errorf("invalid tpm.ekAlgorithm %q, must be %s or %s", cfg.TPM.EKAlgorithm, tpm.EKAlgorithmRSA, tpm.EKAlgorithmECC)
+	}
+	return nil
+}
+
+func configFiles() (result []string) {
+	for _, file := range paths.HostAll(implicitPaths...) {
 		result = append(result, file)
 
 		files, err := ioutil.ReadDir(file)
@@ -85,7 +184,19 @@ func paths() (result []string) {
 	return
 }
 
-func Load(path string) (result Config, err error) {
+// Load reads the config from the implicit paths and path, and if the node has no role
+// yet downloads the rest of its config from the machine inventory. The downloaded config
+// is cached in dataDir to be used when the machine inventory can not be reached.
+func Load(ctx context.Context, path, dataDir string) (Config, error) {
+	result, err := LoadLocal(path)
+	if err != nil {
+		return result, err
+	}
+	return processRemote(ctx, result, dataDir)
+}
+
+// LoadLocal reads the config like Load without contacting the machine inventory
+func LoadLocal(path string) (result Config, err error) {
 	var (
 		values = map[string]interface{}{}
 	)
@@ -94,7 +205,7 @@ func Load(path string) (result Config, err error) {
 		return result, err
 	}
 
-	for _, file := range paths() {
+	for _, file := range configFiles() {
 		newValues, err := mergeFile(values, file)
 		if err == nil {
 			values = newValues
@@ -115,11 +226,19 @@ func Load(path string) (result Config, err error) {
 		return
 	}
 
-	return processRemote(result)
+	if err := validateTPM(&result); err != nil {
+		return result, err
+	}
+
+	if err := resolveSecrets(&result); err != nil {
+		return result, err
+	}
+
+	return result, nil
 }
 
 func populatedSystemResources(config *Config) error {
-	resources, err := loadResources(manifests...)
+	resources, err := loadResources(paths.HostAll(manifests...)...)
 	if err != nil {
 		return err
 	}
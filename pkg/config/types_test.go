@@ -0,0 +1,39 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateTPM(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		tpm   *TPMConfig
+		err   string
+	}{
+		{name: "no tpm settings", token: "tpm://"},
+		{name: "default", token: "tpm://", tpm: &TPMConfig{}},
+		{name: "rsa", token: "tpm://", tpm: &TPMConfig{EKAlgorithm: "rsa"}},
+		{name: "ecc without tpm token", token: "token", tpm: &TPMConfig{EKAlgorithm: "ecc"}},
+		{name: "ecc with tpm token", token: "tpm://", tpm: &TPMConfig{EKAlgorithm: "ecc"},
+			err: "tpm.ekAlgorithm ecc can not be used with a tpm:// token"},
+		{name: "invalid", tpm: &TPMConfig{EKAlgorithm: "dsa"}, err: `invalid tpm.ekAlgorithm "dsa"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Token = test.token
+			cfg.TPM = test.tpm
+
+			err := validateTPM(cfg)
+			if test.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package etcdsnapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/runtime"
+	"github.com/rancher/rancherd/pkg/self"
+	"github.com/rancher/system-agent/pkg/applyinator"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultName is the name of snapshots saved without a name
+	DefaultName = "rancherd"
+	// metadataDir is the directory in the snapshot dir with the metadata of each snapshot.
+	// The runtimes ignore directories when listing snapshots.
+	metadataDir = ".rancherd"
+)
+
+var (
+	invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+)
+
+// Metadata records the versions a snapshot was taken with
+type Metadata struct {
+	Name              string    `json:"name"`
+	Path              string    `json:"path"`
+	Created           time.Time `json:"created"`
+	NodeName          string    `json:"nodeName,omitempty"`
+	Runtime           string    `json:"runtime,omitempty"`
+	KubernetesVersion string    `json:"kubernetesVersion,omitempty"`
+	RancherVersion    string    `json:"rancherVersion,omitempty"`
+	RancherOSVersion  string    `json:"rancherOSVersion,omitempty"`
+}
+
+type SaveOptions struct {
+	Runtime config.Runtime
+	// Dir is the snapshot dir, the default of the runtime if empty
+	Dir               string
+	Name              string
+	NodeName          string
+	KubernetesVersion string
+	RancherVersion    string
+	RancherOSVersion  string
+}
+
+// Dir returns the snapshot dir, dir if set and otherwise the default of the runtime
+func Dir(runtimeName config.Runtime, dir string) string {
+	if dir != "" {
+		return dir
+	}
+	return runtime.Get(runtimeName).SnapshotDir()
+}
+
+// Save takes a snapshot with the etcd-snapshot command of the runtime and records the
+// versions of the cluster next to it. An error is returned if the runtime did not
+// create the snapshot.
+func Save(ctx context.Context, opts SaveOptions) (*Metadata, error) {
+	if opts.Name == "" {
+		opts.Name = DefaultName
+	}
+	dir := Dir(opts.Runtime, opts.Dir)
+
+	bin, err := binary(opts.Runtime)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := snapshotFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Saving etcd snapshot %s to %s", opts.Name, dir)
+	cmd := exec.CommandContext(ctx, bin, runtime.Get(opts.Runtime).SnapshotSaveArgs(opts.Name, dir)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("saving etcd snapshot: %w", err)
+	}
+
+	after, err := snapshotFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// the runtime appends the node name and a timestamp to the name
+	var created string
+	for _, file := range after {
+		if !contains(before, file) && strings.HasPrefix(file, opts.Name) {
+			created = file
+		}
+	}
+	if created == "" {
+		return nil, fmt.Errorf("etcd snapshot %s was not created in %s", opts.Name, dir)
+	}
+
+	metadata := &Metadata{
+		Name:              created,
+		Path:              filepath.Join(dir, created),
+		Created:           time.Now().UTC(),
+		NodeName:          opts.NodeName,
+		Runtime:           string(opts.Runtime),
+		KubernetesVersion: opts.KubernetesVersion,
+		RancherVersion:    opts.RancherVersion,
+		RancherOSVersion:  opts.RancherOSVersion,
+	}
+	if err := writeMetadata(dir, metadata); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Saved etcd snapshot %s", metadata.Path)
+	return metadata, nil
+}
+
+// List returns the snapshots in dir, oldest first. Snapshots not taken by rancherd only
+// have a name, path and creation time.
+func List(runtime config.Runtime, dir string) ([]Metadata, error) {
+	dir = Dir(runtime, dir)
+
+	files, err := snapshotFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Metadata
+	for _, file := range files {
+		metadata, err := Get(runtime, dir, file)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *metadata)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created.Before(result[j].Created)
+	})
+	return result, nil
+}
+
+// Get returns the snapshot name in dir, name can also be the path of the snapshot
+func Get(runtime config.Runtime, dir, name string) (*Metadata, error) {
+	dir = Dir(runtime, dir)
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, name)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("etcd snapshot %s: %w", name, err)
+	}
+
+	metadata := &Metadata{}
+	data, err := ioutil.ReadFile(metadataPath(filepath.Dir(path), filepath.Base(path)))
+	if err == nil {
+		if err := json.Unmarshal(data, metadata); err != nil {
+			return nil, fmt.Errorf("parsing metadata of etcd snapshot %s: %w", name, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	} else {
+		metadata.Created = stat.ModTime().UTC()
+	}
+
+	metadata.Name = filepath.Base(path)
+	metadata.Path = path
+	return metadata, nil
+}
+
+// ToSaveInstruction returns the instruction that saves the snapshot name with rancherd.
+// The instruction fails if the snapshot was not created.
+func ToSaveInstruction(name string) (*applyinator.Instruction, error) {
+	cmd, err := self.Self()
+	if err != nil {
+		return nil, fmt.Errorf("resolving location of %s: %w", os.Args[0], err)
+	}
+	return &applyinator.Instruction{
+		Name:       "etcd-snapshot-save",
+		SaveOutput: true,
+		Args:       []string{"etcd-snapshot", "save", "--name", name},
+		Command:    cmd,
+	}, nil
+}
+
+// UpgradeName returns the name of the snapshot taken before upgrading, made of the
+// from and to pairs of the changed versions
+func UpgradeName(versions ...string) string {
+	name := "pre-upgrade"
+	for _, version := range versions {
+		if version != "" {
+			name += "-" + version
+		}
+	}
+	return invalidNameChars.ReplaceAllString(name, "-")
+}
+
+// ToRestoreInstructions returns the instructions that stop the runtime, reset the cluster
+// to the snapshot at path and start the runtime again
+func ToRestoreInstructions(runtimeName config.Runtime, path string) ([]applyinator.Instruction, error) {
+	bin, err := binary(runtimeName)
+	if err != nil {
+		return nil, err
+	}
+	configured := runtime.Get(runtimeName)
+	service := configured.ServerService()
+
+	return []applyinator.Instruction{
+		{
+			Name:       "stop-" + service,
+			SaveOutput: true,
+			Args:       []string{"stop", service},
+			Command:    "systemctl",
+		},
+		{
+			Name:       "etcd-snapshot-restore",
+			SaveOutput: true,
+			Args:       configured.SnapshotRestoreArgs(path),
+			Command:    bin,
+		},
+		{
+			Name:       "start-" + service,
+			SaveOutput: true,
+			Args:       []string{"start", service},
+			Command:    "systemctl",
+		},
+	}, nil
+}
+
+func binary(runtimeName config.Runtime) (string, error) {
+	for _, bin := range runtime.Get(runtimeName).Binaries() {
+		if _, err := os.Stat(bin); err == nil {
+			return bin, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not installed", runtimeName)
+}
+
+func snapshotFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() {
+			result = append(result, entry.Name())
+		}
+	}
+	return result, nil
+}
+
+func writeMetadata(dir string, metadata *Metadata) error {
+	path := metadataPath(dir, metadata.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(path), err)
+	}
+
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func metadataPath(dir, name string) string {
+	return filepath.Join(dir, metadataDir, name+".json")
+}
+
+func contains(files []string, file string) bool {
+	for _, f := range files {
+		if f == file {
+			return true
+		}
+	}
+	return false
+}
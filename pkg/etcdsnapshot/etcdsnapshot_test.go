@@ -0,0 +1,114 @@
+package etcdsnapshot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/paths"
+)
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	created := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// taken by the runtime, only the file exists
+	external := filepath.Join(dir, "etcd-snapshot-node1-1672628645")
+	if err := ioutil.WriteFile(external, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(external, created.Add(time.Hour), created.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// taken by rancherd, the versions are recorded next to it
+	if err := ioutil.WriteFile(filepath.Join(dir, "rancherd-node1-1672628645"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeMetadata(dir, &Metadata{
+		Name:              "rancherd-node1-1672628645",
+		Created:           created,
+		KubernetesVersion: "v1.24.10+k3s1",
+		RancherVersion:    "v2.7.1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := List(config.RuntimeK3S, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("expected 2 snapshots, got %v", snapshots)
+	}
+
+	first, second := snapshots[0], snapshots[1]
+	if first.Name != "rancherd-node1-1672628645" || first.KubernetesVersion != "v1.24.10+k3s1" ||
+		first.RancherVersion != "v2.7.1" || !first.Created.Equal(created) {
+		t.Errorf("unexpected metadata of the rancherd snapshot: %+v", first)
+	}
+	if first.Path != filepath.Join(dir, first.Name) {
+		t.Errorf("expected path %s, got %s", filepath.Join(dir, first.Name), first.Path)
+	}
+	if second.Path != external || second.KubernetesVersion != "" || !second.Created.Equal(created.Add(time.Hour)) {
+		t.Errorf("unexpected metadata of the runtime snapshot: %+v", second)
+	}
+
+	byPath, err := Get(config.RuntimeK3S, "", external)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byPath.Name != filepath.Base(external) {
+		t.Errorf("expected snapshot %s, got %s", filepath.Base(external), byPath.Name)
+	}
+
+	if _, err := Get(config.RuntimeK3S, dir, "missing"); err == nil {
+		t.Error("expected an error for a missing snapshot")
+	}
+}
+
+func TestUpgradeName(t *testing.T) {
+	name := UpgradeName("v2.6.9", "v2.7.1", "", "", "v1.23.16+k3s1", "v1.24.10+k3s1")
+	if expected := "pre-upgrade-v2.6.9-v2.7.1-v1.23.16-k3s1-v1.24.10-k3s1"; name != expected {
+		t.Errorf("expected %s, got %s", expected, name)
+	}
+}
+
+func TestToRestoreInstructions(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv(paths.RootEnv, root)
+
+	if _, err := ToRestoreInstructions(config.RuntimeRKE2, "/snapshots/rancherd"); err == nil {
+		t.Error("expected an error if rke2 is not installed")
+	}
+
+	bin := filepath.Join(root, "/opt/rke2/bin/rke2")
+	if err := os.MkdirAll(filepath.Dir(bin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(bin, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	instructions, err := ToRestoreInstructions(config.RuntimeRKE2, "/snapshots/rancherd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var commands [][]string
+	for _, inst := range instructions {
+		commands = append(commands, append([]string{inst.Command}, inst.Args...))
+	}
+	expected := [][]string{
+		{"systemctl", "stop", "rke2-server"},
+		{bin, "server", "--cluster-reset", "--cluster-reset-restore-path=/snapshots/rancherd"},
+		{"systemctl", "start", "rke2-server"},
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected %v, got %v", expected, commands)
+	}
+}
@@ -0,0 +1,142 @@
+package facts
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/tpm"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	procNetRoute = "/proc/net/route"
+	dmiDir       = "/sys/class/dmi/id"
+)
+
+// Facts are the values describing the current node that can be referenced
+// from templates in the config, for example {{ .Hostname }} or {{ .IP }}.
+type Facts struct {
+	// Hostname is the short hostname of the node
+	Hostname string `json:"hostname,omitempty"`
+	// FQDN is the full hostname as reported by the kernel
+	FQDN string `json:"fqdn,omitempty"`
+	// Interface is the name of the network interface used by the default route
+	Interface string `json:"interface,omitempty"`
+	// IP is the primary IPv4 address of Interface
+	IP string `json:"ip,omitempty"`
+	// MAC is the hardware address of Interface
+	MAC string `json:"mac,omitempty"`
+	// TPMHash is the hash of the TPM EK, the same value printed by get-tpm-hash
+	TPMHash string `json:"tpmHash,omitempty"`
+	// Serial is the DMI product serial number
+	Serial string `json:"serial,omitempty"`
+	// UUID is the DMI product UUID
+	UUID string `json:"uuid,omitempty"`
+}
+
+// Gather collects the facts of the current node, the TPM hash is read from t if it
+// is not nil. Facts that can not be determined, such as the TPM hash on a machine
+// without a TPM, are left empty.
+func Gather(t *tpm.TPM) Facts {
+	var result Facts
+
+	if hostname, err := os.Hostname(); err == nil {
+		result.FQDN = hostname
+		result.Hostname = strings.Split(hostname, ".")[0]
+	} else {
+		logrus.Debugf("failed to determine hostname: %v", err)
+	}
+
+	if iface, err := primaryInterface(); err == nil {
+		result.Interface = iface.Name
+		result.MAC = iface.HardwareAddr.String()
+		result.IP = interfaceIP(iface)
+	} else {
+		logrus.Debugf("failed to determine primary network interface: %v", err)
+	}
+
+	if t != nil {
+		if hash, err := t.GetPubHash(); err == nil {
+			result.TPMHash = hash
+		} else {
+			logrus.Debugf("failed to determine TPM hash: %v", err)
+		}
+	}
+
+	result.Serial = readDMI("product_serial")
+	result.UUID = readDMI("product_uuid")
+	return result
+}
+
+func readDMI(name string) string {
+	data, err := ioutil.ReadFile(paths.Host(filepath.Join(dmiDir, name)))
+	if err != nil {
+		logrus.Debugf("failed to read DMI %s: %v", name, err)
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// primaryInterface returns the interface of the default route, falling back
+// to the first interface that is up and not a loopback.
+func primaryInterface() (*net.Interface, error) {
+	if name := defaultRouteInterface(); name != "" {
+		if iface, err := net.InterfaceByName(name); err == nil {
+			return iface, nil
+		}
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagUp == 0 || ifaces[i].Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if interfaceIP(&ifaces[i]) != "" {
+			return &ifaces[i], nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func defaultRouteInterface() string {
+	f, err := os.Open(paths.Host(procNetRoute))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		// Iface Destination Gateway Flags ...
+		fields := strings.Fields(scan.Text())
+		if len(fields) > 2 && fields[1] == "00000000" {
+			return fields[0]
+		}
+	}
+	return ""
+}
+
+func interfaceIP(iface *net.Interface) string {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ip := ipNet.IP.To4(); ip != nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
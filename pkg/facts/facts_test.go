@@ -0,0 +1,84 @@
+package facts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/rancherd/pkg/paths"
+)
+
+const testRoute = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+lo	0000000A	00000000	0001	0	0	0	000000FF	0	0	0
+lo	00000000	0100000A	0003	0	0	0	00000000	0	0	0
+`
+
+func writeFile(t *testing.T, root, path, content string) {
+	t.Helper()
+	path = filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGather(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv(paths.RootEnv, root)
+
+	writeFile(t, root, filepath.Join(dmiDir, "product_serial"), "SERIAL-1234\n")
+	writeFile(t, root, filepath.Join(dmiDir, "product_uuid"), "4c4c4544-0000-1000-8000-b4c04f000000\n")
+	writeFile(t, root, procNetRoute, testRoute)
+
+	facts := Gather(nil)
+
+	if facts.Serial != "SERIAL-1234" {
+		t.Errorf("serial = %q, expected SERIAL-1234", facts.Serial)
+	}
+	if facts.UUID != "4c4c4544-0000-1000-8000-b4c04f000000" {
+		t.Errorf("uuid = %q", facts.UUID)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if facts.FQDN != hostname || facts.Hostname != strings.Split(hostname, ".")[0] {
+		t.Errorf("hostname = %q, fqdn = %q, expected %q", facts.Hostname, facts.FQDN, hostname)
+	}
+
+	// the route table points at the loopback interface
+	if facts.Interface != "lo" {
+		t.Errorf("interface = %q, expected lo", facts.Interface)
+	}
+	if facts.IP != "127.0.0.1" {
+		t.Errorf("ip = %q, expected 127.0.0.1", facts.IP)
+	}
+}
+
+func TestGatherMissing(t *testing.T) {
+	t.Setenv(paths.RootEnv, t.TempDir())
+
+	facts := Gather(nil)
+	if facts.Serial != "" || facts.UUID != "" {
+		t.Errorf("expected no DMI facts, got serial %q uuid %q", facts.Serial, facts.UUID)
+	}
+}
+
+func TestDefaultRouteInterface(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv(paths.RootEnv, root)
+
+	if iface := defaultRouteInterface(); iface != "" {
+		t.Errorf("expected no interface without a route table, got %q", iface)
+	}
+
+	writeFile(t, root, procNetRoute, strings.Replace(testRoute, "lo\t00000000", "eth0\t00000000", 1))
+	if iface := defaultRouteInterface(); iface != "eth0" {
+		t.Errorf("interface = %q, expected eth0", iface)
+	}
+}
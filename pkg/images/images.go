@@ -15,8 +15,13 @@ func GetRancherInstallerImage(imageOverride, imagePrefix, rancherVersion string)
 	return getInstallerImage(imageOverride, imagePrefix, "rancher", rancherVersion)
 }
 
-func GetInstallerImage(imageOverride, imagePrefix, kubernetesVersion string) string {
-	return getInstallerImage(imageOverride, imagePrefix, string(config.GetRuntime(kubernetesVersion)), kubernetesVersion)
+func GetRancherdInstallerImage(imageOverride, imagePrefix, rancherdVersion string) string {
+	return getInstallerImage(imageOverride, imagePrefix, "rancherd", rancherdVersion)
+}
+
+// GetInstallerImage returns the installer image of the Kubernetes version of runtime
+func GetInstallerImage(imageOverride, imagePrefix string, runtime config.Runtime, kubernetesVersion string) string {
+	return getInstallerImage(imageOverride, imagePrefix, string(runtime), kubernetesVersion)
 }
 
 func getInstallerImage(imageOverride, imagePrefix, component, version string) string {
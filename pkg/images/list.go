@@ -0,0 +1,113 @@
+package images
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rancher/rancherd/pkg/config"
+)
+
+// Image is an image pulled while applying a rancherd plan
+type Image struct {
+	// Image is the image as it is pulled, honoring the overrides and systemDefaultRegistry
+	Image string `json:"image"`
+	// Source is the upstream image Image is mirrored from
+	Source string `json:"source"`
+	// Use describes what the image is used for
+	Use string `json:"use"`
+}
+
+// List returns the images the plans of cfg pull for the resolved versions, runtime is the
+// runtime of k8sVersion. The RancherOS image is only included if rancherOSVersion is set.
+func List(cfg *config.Config, runtime config.Runtime, k8sVersion, rancherVersion, rancherOSVersion string) []Image {
+	result := []Image{
+		{
+			Image:  GetInstallerImage(cfg.RuntimeInstallerImage, cfg.SystemDefaultRegistry, runtime, k8sVersion),
+			Source: GetInstallerImage(cfg.RuntimeInstallerImage, "", runtime, k8sVersion),
+			Use:    string(runtime) + " installer",
+		},
+		{
+			Image:  GetRancherInstallerImage(cfg.RancherInstallerImage, cfg.SystemDefaultRegistry, rancherVersion),
+			Source: GetRancherInstallerImage(cfg.RancherInstallerImage, "", rancherVersion),
+			Use:    "rancher installer",
+		},
+	}
+
+	if rancherOSVersion != "" {
+		result = append(result, Image{
+			Image:  rancherOSVersion,
+			Source: rancherOSVersion,
+			Use:    "rancheros",
+		})
+	}
+
+	for _, inst := range cfg.PreInstructions {
+		if inst.Image != "" {
+			result = append(result, Image{Image: inst.Image, Source: inst.Image, Use: "preInstruction " + inst.Name})
+		}
+	}
+	for _, inst := range cfg.PostInstructions {
+		if inst.Image != "" {
+			result = append(result, Image{Image: inst.Image, Source: inst.Image, Use: "postInstruction " + inst.Name})
+		}
+	}
+
+	return dedupe(result)
+}
+
+// Mirror returns the source=destination lines of a mirror manifest for images. If
+// registry is set the images are mirrored to registry instead of the registry they
+// are pulled from.
+func Mirror(images []Image, registry string) []string {
+	var result []string
+	for _, image := range images {
+		dest := image.Image
+		if registry != "" {
+			dest = strings.TrimSuffix(registry, "/") + "/" + stripRegistry(image.Image)
+		}
+		result = append(result, fmt.Sprintf("%s=%s", qualify(image.Source), dest))
+	}
+	sort.Strings(result)
+	return result
+}
+
+func dedupe(images []Image) []Image {
+	seen := map[string]bool{}
+	var result []Image
+	for _, image := range images {
+		if seen[image.Image] {
+			continue
+		}
+		seen[image.Image] = true
+		result = append(result, image)
+	}
+	return result
+}
+
+// hasRegistry returns true if the first component of image is a registry host
+func hasRegistry(image string) bool {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) < 2 {
+		return false
+	}
+	return strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost"
+}
+
+func stripRegistry(image string) string {
+	if !hasRegistry(image) {
+		return image
+	}
+	return strings.SplitN(image, "/", 2)[1]
+}
+
+// qualify adds the implicit docker.io registry to image
+func qualify(image string) string {
+	if hasRegistry(image) {
+		return image
+	}
+	if !strings.Contains(image, "/") {
+		return "docker.io/library/" + image
+	}
+	return "docker.io/" + image
+}
@@ -0,0 +1,66 @@
+package images
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQualify(t *testing.T) {
+	tests := map[string]string{
+		"busybox":                          "docker.io/library/busybox",
+		"busybox:1.35":                     "docker.io/library/busybox:1.35",
+		"rancher/rancher:v2.7.1":           "docker.io/rancher/rancher:v2.7.1",
+		"docker.io/rancher/rancher:v2.7.1": "docker.io/rancher/rancher:v2.7.1",
+		"registry.example.com/rancher/k3s": "registry.example.com/rancher/k3s",
+		"registry:5000/rancher/k3s":        "registry:5000/rancher/k3s",
+		"localhost/rancher/k3s":            "localhost/rancher/k3s",
+	}
+	for image, expected := range tests {
+		if qualified := qualify(image); qualified != expected {
+			t.Errorf("qualify(%q) = %q, expected %q", image, qualified, expected)
+		}
+	}
+}
+
+func TestMirror(t *testing.T) {
+	images := []Image{
+		{
+			Image:  "registry.example.com/rancher/system-agent-installer-k3s:v1.24.10-k3s1",
+			Source: "rancher/system-agent-installer-k3s:v1.24.10-k3s1",
+		},
+		{
+			Image:  "busybox",
+			Source: "busybox",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		registry string
+		expected []string
+	}{
+		{
+			name: "pulled registry",
+			expected: []string{
+				"docker.io/library/busybox=busybox",
+				"docker.io/rancher/system-agent-installer-k3s:v1.24.10-k3s1=registry.example.com/rancher/system-agent-installer-k3s:v1.24.10-k3s1",
+			},
+		},
+		{
+			name:     "other registry",
+			registry: "mirror.example.com:5000/",
+			expected: []string{
+				"docker.io/library/busybox=mirror.example.com:5000/busybox",
+				"docker.io/rancher/system-agent-installer-k3s:v1.24.10-k3s1=mirror.example.com:5000/rancher/system-agent-installer-k3s:v1.24.10-k3s1",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := Mirror(images, test.registry); !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package imageverify
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/rancher/rancherd/pkg/bundle"
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/self"
+	"github.com/rancher/system-agent/pkg/applyinator"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	lockFile       = "images.lock"
+	publicKeysFile = "image-keys.pem"
+	pemPrefix      = "-----BEGIN"
+)
+
+type Options struct {
+	Image string
+	// Digest is the expected manifest digest of the image
+	Digest string
+	// PublicKeysFile contains the PEM ECDSA public keys, if set the image must have a
+	// cosign signature made with one of them
+	PublicKeysFile string
+	// LockFile records the digest of the image the first time it is verified, if set
+	LockFile       string
+	RegistriesFile string
+}
+
+// payload is the cosign simple signing payload that is signed
+type payload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+func GetLockFile(dataDir string) string {
+	return filepath.Join(dataDir, lockFile)
+}
+
+func getPublicKeysFile(dataDir string) string {
+	return filepath.Join(dataDir, publicKeysFile)
+}
+
+// ToFile returns the file with the public keys of cfg, the keys are read when the plan
+// is created so verification does not depend on the config files on the host
+func ToFile(cfg *config.Config, dataDir string) (*applyinator.File, error) {
+	if cfg.ImageVerification == nil || len(cfg.ImageVerification.PublicKeys) == 0 {
+		return nil, nil
+	}
+
+	var keys []byte
+	for _, key := range cfg.ImageVerification.PublicKeys {
+		data := []byte(key)
+		if !strings.HasPrefix(strings.TrimSpace(key), pemPrefix) {
+			var err error
+			data, err = ioutil.ReadFile(paths.Host(key))
+			if err != nil {
+				return nil, fmt.Errorf("reading public key: %w", err)
+			}
+		}
+		if _, err := parsePublicKeys(data); err != nil {
+			return nil, err
+		}
+		keys = append(keys, bytes.TrimSpace(data)...)
+		keys = append(keys, '\n')
+	}
+
+	return &applyinator.File{
+		Content:     base64.StdEncoding.EncodeToString(keys),
+		Path:        getPublicKeysFile(dataDir),
+		Permissions: "0644",
+	}, nil
+}
+
+// ToInstruction returns the instruction that verifies image with the digest and the
+// verification config of cfg, nil if there is nothing to verify. The instruction must run
+// before the instructions using the image, as it leaves the verified image in the images
+// dir for them.
+func ToInstruction(cfg *config.Config, instructionName, image, digest, registriesFile, dataDir string) (*applyinator.Instruction, error) {
+	if digest == "" && cfg.ImageVerification == nil {
+		return nil, nil
+	}
+
+	cmd, err := self.Self()
+	if err != nil {
+		return nil, fmt.Errorf("resolving location of %s: %w", os.Args[0], err)
+	}
+
+	args := []string{"verify-image", "--image", image, "--registries-file", registriesFile}
+	if digest != "" {
+		args = append(args, "--digest", digest)
+	}
+	if v := cfg.ImageVerification; v != nil {
+		if len(v.PublicKeys) > 0 {
+			args = append(args, "--public-keys", getPublicKeysFile(dataDir))
+		}
+		if v.Lock {
+			args = append(args, "--lock-file", GetLockFile(dataDir))
+		}
+	}
+
+	return &applyinator.Instruction{
+		Name:       instructionName,
+		SaveOutput: true,
+		Args:       args,
+		Command:    cmd,
+	}, nil
+}
+
+// Verify checks the manifest digest and the signature of the image. The image is taken
+// from the images dir, like the image utility of plans does, or is pulled and saved to
+// the images dir so the plan runs the image that was verified. Signatures are looked up
+// in the images dir first too, so images loaded from a bundle are verified offline.
+func Verify(ctx context.Context, opts Options) error {
+	tag, err := name.NewTag(opts.Image)
+	if err != nil {
+		return err
+	}
+
+	img, digest, err := bundle.FindImage(tag)
+	if err != nil {
+		return err
+	}
+	pulled := img == nil
+	if pulled {
+		img, err = bundle.Pull(ctx, opts.Image, opts.RegistriesFile)
+		if err != nil {
+			return err
+		}
+		if digest, err = img.Digest(); err != nil {
+			return err
+		}
+	}
+	logrus.Infof("Image %s has digest %s", opts.Image, digest)
+
+	expected := opts.Digest
+	locked, err := readLock(opts.LockFile)
+	if err != nil {
+		return err
+	}
+	if expected == "" {
+		expected = locked[opts.Image]
+	}
+	if expected != "" && expected != digest.String() {
+		return fmt.Errorf("image %s has digest %s, expected %s", opts.Image, digest, expected)
+	}
+
+	if opts.PublicKeysFile != "" {
+		if err := verifySignature(ctx, tag, digest, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.LockFile != "" && locked[opts.Image] == "" {
+		logrus.Infof("Locking image %s to digest %s in %s", opts.Image, digest, opts.LockFile)
+		locked[opts.Image] = digest.String()
+		if err := writeLock(opts.LockFile, locked); err != nil {
+			return err
+		}
+	}
+
+	if pulled {
+		return bundle.SaveImage(tag, img)
+	}
+	return nil
+}
+
+func verifySignature(ctx context.Context, tag name.Tag, digest v1.Hash, opts Options) error {
+	keyData, err := ioutil.ReadFile(opts.PublicKeysFile)
+	if err != nil {
+		return err
+	}
+	keys, err := parsePublicKeys(keyData)
+	if err != nil {
+		return err
+	}
+
+	sigTag := bundle.SignatureTag(tag.Context(), digest)
+	signatures, err := bundle.FindSignatures(sigTag)
+	if err != nil {
+		return err
+	}
+	if signatures == nil {
+		sig, err := bundle.Pull(ctx, sigTag.Name(), opts.RegistriesFile)
+		if err != nil {
+			return fmt.Errorf("image %s is not signed: %w", opts.Image, err)
+		}
+		if signatures, err = bundle.Signatures(sig); err != nil {
+			return fmt.Errorf("reading signature %s: %w", sigTag.Name(), err)
+		}
+	}
+
+	for _, signature := range signatures {
+		if err := verifyPayload(signature, digest, keys); err != nil {
+			logrus.Infof("Skipping signature of image %s: %v", opts.Image, err)
+			continue
+		}
+		logrus.Infof("Image %s has a valid signature", opts.Image)
+		return nil
+	}
+
+	return fmt.Errorf("image %s has no signature made with the configured public keys", opts.Image)
+}
+
+// verifyPayload checks that the payload of the signature is signed with one of keys and
+// is the payload of the image with digest
+func verifyPayload(signature bundle.Signature, digest v1.Hash, keys []*ecdsa.PublicKey) error {
+	sigBytes, err := base64.StdEncoding.DecodeString(signature.Signature)
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+
+	hash := sha256.Sum256(signature.Payload)
+	var valid bool
+	for _, key := range keys {
+		if ecdsa.VerifyASN1(key, hash[:], sigBytes) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("signature is not made with any of the configured public keys")
+	}
+
+	// the payload is only trusted once the signature is verified
+	var p payload
+	if err := json.Unmarshal(signature.Payload, &p); err != nil {
+		return fmt.Errorf("parsing signature payload: %w", err)
+	}
+	if p.Critical.Image.DockerManifestDigest != digest.String() {
+		return fmt.Errorf("signature is for digest %s", p.Critical.Image.DockerManifestDigest)
+	}
+	return nil
+}
+
+func parsePublicKeys(data []byte) (result []*ecdsa.PublicKey, _ error) {
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		data = rest
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing public key: %w", err)
+		}
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key is not an ECDSA public key")
+		}
+		result = append(result, ecKey)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no PEM public key found")
+	}
+	return result, nil
+}
+
+func readLock(path string) (map[string]string, error) {
+	result := map[string]string{}
+	if path == "" {
+		return result, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return result, nil
+}
+
+func writeLock(path string, locked map[string]string) error {
+	data, err := yaml.Marshal(locked)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,287 @@
+package inventory
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/rancherd/pkg/cacerts"
+	"github.com/rancher/rancherd/pkg/tpm"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/util/cert"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	CACertsPath   = "/v1-rancheros/cacerts"
+	InventoryPath = "/v1-rancheros/inventory"
+)
+
+// Server implements the machine inventory endpoints used by rancherd when only
+// server and a tpm:// token are configured. The config of each machine is read
+// from <Dir>/<tpm hash>.yaml, the hash being the output of "rancherd get-tpm-hash".
+// Machines are only identified by the hash of their EK, EK certificates are not
+// validated against the CAs of the TPM manufacturers.
+type Server struct {
+	// Dir contains the machine configs
+	Dir string
+	// CACerts is the PEM encoded CA of the serving certificate
+	CACerts []byte
+	// DefaultPCRs are quoted and recorded as the PCR policy of machines that do not have one yet
+	DefaultPCRs []int
+
+	// hashes maps the hashed TPM hashes of the registered machines to their TPM hash,
+	// as of hashesModTime of Dir
+	hashesLock    sync.Mutex
+	hashes        map[string]string
+	hashesModTime time.Time
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(CACertsPath, s.serveCACerts)
+	mux.HandleFunc(InventoryPath, s.serveInventory)
+	return mux
+}
+
+// serveCACerts returns the CA to a client that does not trust the serving certificate
+// yet. The response is signed with the TPM hash of the client, which it sends hashed
+// as the bearer token.
+func (s *Server) serveCACerts(rw http.ResponseWriter, req *http.Request) {
+	nonce := req.Header.Get("X-Cattle-Nonce")
+	if nonce == "" {
+		// Plain request to check if the CA is already trusted
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+
+	bearer := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	hash, err := s.findHash(bearer)
+	if err != nil {
+		logrus.Errorf("cacerts request from %s: %v", req.RemoteAddr, err)
+		http.Error(rw, "unknown machine", http.StatusUnauthorized)
+		return
+	}
+
+	rw.Header().Set("X-Cattle-Hash", cacerts.Hash(hash, nonce, s.CACerts))
+	_, _ = rw.Write(s.CACerts)
+}
+
+func (s *Server) serveInventory(rw http.ResponseWriter, req *http.Request) {
+	if err := tpm.Serve(rw, req, s.config, s); err != nil {
+		logrus.Errorf("inventory request from %s: %v", req.RemoteAddr, err)
+	}
+}
+
+// config returns the machine config for hash converted to JSON
+func (s *Server) config(hash string) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path(hash))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("machine is not registered in the inventory")
+	} else if err != nil {
+		return nil, err
+	}
+
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", s.path(hash), err)
+	}
+	return data, nil
+}
+
+func (s *Server) path(hash string) string {
+	return filepath.Join(s.Dir, hash+".yaml")
+}
+
+func (s *Server) policyPath(hash string) string {
+	return filepath.Join(s.Dir, hash+".pcrs.yaml")
+}
+
+// policy returns the PCR policy of the machine, nil if it has none
+func (s *Server) policy(hash string) (*tpm.MachinePCRs, error) {
+	data, err := ioutil.ReadFile(s.policyPath(hash))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	policy := &tpm.MachinePCRs{}
+	if err := yaml.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", s.policyPath(hash), err)
+	}
+	return policy, nil
+}
+
+// PCRs implements tpm.PCRPolicy, machines with a policy file must quote the PCRs
+// in it, all other machines the DefaultPCRs
+func (s *Server) PCRs(hash string) ([]int, error) {
+	policy, err := s.policy(hash)
+	if err != nil || policy == nil {
+		return s.DefaultPCRs, err
+	}
+
+	var result []int
+	for index := range policy.PCRs {
+		result = append(result, index)
+	}
+	sort.Ints(result)
+	return result, nil
+}
+
+// Verify implements tpm.PCRPolicy. The PCR values of a machine without a policy file
+// are trusted on first use and recorded as its policy.
+func (s *Server) Verify(hash string, pcrs map[int][]byte) error {
+	policy, err := s.policy(hash)
+	if err != nil {
+		return err
+	}
+
+	actual := tpm.NewMachinePCRs(hash, pcrs)
+	if policy == nil {
+		data, err := yaml.Marshal(actual)
+		if err != nil {
+			return err
+		}
+		// trust on first use, the first boot state seen becomes the policy
+		logrus.Warnf("Trusting the boot state of TPM hash %s on first use, recording its PCR policy in %s", hash, s.policyPath(hash))
+		return ioutil.WriteFile(s.policyPath(hash), data, 0600)
+	}
+
+	for index, expected := range policy.PCRs {
+		if !strings.EqualFold(actual.PCRs[index], expected) {
+			return fmt.Errorf("PCR %d does not match the policy", index)
+		}
+	}
+	return nil
+}
+
+// findHash returns the TPM hash of the registered machine whose hashed TPM hash is bearer.
+// The hashes are only computed again when a machine is added to or removed from Dir.
+func (s *Server) findHash(bearer string) (string, error) {
+	info, err := os.Stat(s.Dir)
+	if err != nil {
+		return "", err
+	}
+
+	s.hashesLock.Lock()
+	defer s.hashesLock.Unlock()
+
+	if s.hashes == nil || !info.ModTime().Equal(s.hashesModTime) {
+		files, err := ioutil.ReadDir(s.Dir)
+		if err != nil {
+			return "", err
+		}
+
+		s.hashes = map[string]string{}
+		s.hashesModTime = info.ModTime()
+		for _, file := range files {
+			hash := strings.TrimSuffix(file.Name(), ".yaml")
+			if file.IsDir() || hash == file.Name() || strings.HasSuffix(hash, ".pcrs") {
+				continue
+			}
+			s.hashes[cacerts.HashBase64([]byte(hash))] = hash
+		}
+	}
+
+	if hash, ok := s.hashes[bearer]; ok {
+		return hash, nil
+	}
+	return "", fmt.Errorf("machine is not registered in the inventory")
+}
+
+// ListenAndServe serves the inventory on listen until ctx is done. If certFile is
+// empty a self-signed certificate is generated for the local addresses and sans.
+func (s *Server) ListenAndServe(ctx context.Context, listen, certFile, keyFile string, sans []string) error {
+	tlsCert, err := s.certificate(certFile, keyFile, sans)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:    listen,
+		Handler: s.Handler(),
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{tlsCert},
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	logrus.Infof("Serving machine inventory from %s on %s", s.Dir, listen)
+	err = server.ListenAndServeTLS("", "")
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) certificate(certFile, keyFile string, sans []string) (tls.Certificate, error) {
+	var (
+		certPEM, keyPEM []byte
+		err             error
+	)
+
+	if certFile == "" {
+		certPEM, keyPEM, err = generateCertificate(sans)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("generating self-signed certificate: %w", err)
+		}
+	} else {
+		certPEM, err = ioutil.ReadFile(certFile)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		keyPEM, err = ioutil.ReadFile(keyFile)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	if len(s.CACerts) == 0 {
+		s.CACerts = certPEM
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+func generateCertificate(sans []string) ([]byte, []byte, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		ips []net.IP
+		dns []string
+	)
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			dns = append(dns, san)
+		}
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+
+	return cert.GenerateSelfSignedCertKey(host, ips, dns)
+}
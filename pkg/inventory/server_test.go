@@ -0,0 +1,191 @@
+package inventory
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/rancherd/pkg/cacerts"
+	"github.com/rancher/rancherd/pkg/tpm"
+	"github.com/rancher/rancherd/pkg/tpm/tpmtest"
+	"sigs.k8s.io/yaml"
+)
+
+const testHash = "2c9cbd6c6bbd2a7ddff4b3d4b1b0b2a1e8e4d5a4f8a9f7ed0c3a1b2c3d4e5f60"
+
+func writeMachine(t *testing.T, dir, hash, config string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, hash+".yaml"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func cacertsRequest(t *testing.T, server *httptest.Server, hash, nonce string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, server.URL+CACertsPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonce != "" {
+		req.Header.Set("X-Cattle-Nonce", nonce)
+		req.Header.Set("Authorization", "Bearer "+cacerts.HashBase64([]byte(hash)))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		resp.Body.Close()
+	})
+	return resp
+}
+
+func TestServeCACerts(t *testing.T) {
+	dir := t.TempDir()
+	writeMachine(t, dir, testHash, "role: server\n")
+
+	s := &Server{
+		Dir:     dir,
+		CACerts: []byte("ca certs"),
+	}
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	resp := cacertsRequest(t, server, "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d without a nonce, expected 200", resp.StatusCode)
+	}
+
+	resp = cacertsRequest(t, server, testHash, "nonce")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, expected 200", resp.StatusCode)
+	}
+	if hash := resp.Header.Get("X-Cattle-Hash"); hash != cacerts.Hash(testHash, "nonce", s.CACerts) {
+		t.Errorf("X-Cattle-Hash = %q is not signed with the TPM hash", hash)
+	}
+	if data, _ := ioutil.ReadAll(resp.Body); string(data) != "ca certs" {
+		t.Errorf("cacerts = %q", data)
+	}
+
+	resp = cacertsRequest(t, server, "unknown", "nonce")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d for an unknown machine, expected 401", resp.StatusCode)
+	}
+}
+
+func TestFindHash(t *testing.T) {
+	dir := t.TempDir()
+	writeMachine(t, dir, testHash, "role: server\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, testHash+".pcrs.yaml"), []byte("pcrs: {}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		Dir: dir,
+	}
+	hash, err := s.findHash(cacerts.HashBase64([]byte(testHash)))
+	if err != nil || hash != testHash {
+		t.Fatalf("findHash = %q, %v, expected %s", hash, err, testHash)
+	}
+	if _, err := s.findHash(cacerts.HashBase64([]byte(testHash + ".pcrs"))); err == nil {
+		t.Errorf("PCR policy files are not machines")
+	}
+
+	// machines registered later are found
+	writeMachine(t, dir, "added", "role: agent\n")
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(dir, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := s.findHash(cacerts.HashBase64([]byte("added"))); err != nil || hash != "added" {
+		t.Errorf("findHash = %q, %v, expected added", hash, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	s := &Server{
+		Dir:         dir,
+		DefaultPCRs: []int{7},
+	}
+
+	pcrs, err := s.PCRs(testHash)
+	if err != nil || len(pcrs) != 1 || pcrs[0] != 7 {
+		t.Fatalf("PCRs = %v, %v, expected the default PCRs", pcrs, err)
+	}
+
+	// the first boot state is recorded as the policy
+	if err := s.Verify(testHash, map[int][]byte{7: {0x01}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(s.policyPath(testHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &tpm.MachinePCRs{}
+	if err := yaml.Unmarshal(data, policy); err != nil {
+		t.Fatal(err)
+	}
+	if policy.PCRs[7] != "01" {
+		t.Errorf("recorded policy = %v", policy.PCRs)
+	}
+
+	if err := s.Verify(testHash, map[int][]byte{7: {0x01}}); err != nil {
+		t.Errorf("same boot state: %v", err)
+	}
+	if err := s.Verify(testHash, map[int][]byte{7: {0x02}}); err == nil {
+		t.Errorf("expected a changed PCR 7 to be rejected")
+	}
+}
+
+func TestServeInventory(t *testing.T) {
+	device := tpmtest.NewDevice(t)
+	client, err := tpm.NewWithDevice(device, tpm.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := client.GetPubHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeMachine(t, dir, hash, "role: server\nserver: https://rancher.example.com\n")
+
+	s := &Server{
+		Dir:         dir,
+		DefaultPCRs: []int{7},
+	}
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	data, err := client.Get(nil, server.URL+InventoryPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := map[string]interface{}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config["role"] != "server" || config["server"] != "https://rancher.example.com" {
+		t.Errorf("config = %v", config)
+	}
+	if _, err := os.Stat(s.policyPath(hash)); err != nil {
+		t.Errorf("PCR policy was not recorded: %v", err)
+	}
+
+	if _, err := client.Get(nil, server.URL+InventoryPath, nil); err != nil {
+		t.Errorf("same boot state: %v", err)
+	}
+
+	device.ExtendPCR(t, 7, "untrusted bootloader")
+	if _, err := client.Get(nil, server.URL+InventoryPath, nil); err == nil || !strings.Contains(err.Error(), "PCR 7") {
+		t.Errorf("expected the changed boot state to be rejected, got %v", err)
+	}
+}
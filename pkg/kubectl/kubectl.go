@@ -5,40 +5,47 @@ import (
 	"os"
 
 	"github.com/rancher/rancherd/pkg/config"
-)
-
-var (
-	kubeconfigs = []string{
-		"/etc/rancher/k3s/k3s.yaml",
-		"/etc/rancher/rke2/rke2.yaml",
-	}
+	"github.com/rancher/rancherd/pkg/runtime"
 )
 
 func Env(k8sVersion string) []string {
-	runtime := config.GetRuntime(k8sVersion)
-	return []string{
-		fmt.Sprintf("KUBECONFIG=/etc/rancher/%s/%s.yaml", runtime, runtime),
-	}
+	return runtime.KubectlEnv(runtime.ForVersion(k8sVersion))
+}
+
+func EnvForRuntime(name config.Runtime) []string {
+	return runtime.KubectlEnv(runtime.Get(name))
 }
 
 func Command(k8sVersion string) string {
-	kubectl := "/usr/local/bin/kubectl"
-	runtime := config.GetRuntime(k8sVersion)
-	if runtime == config.RuntimeRKE2 {
-		kubectl = "/var/lib/rancher/rke2/bin/kubectl"
+	return runtime.ForVersion(k8sVersion).Kubectl()
+}
+
+func CommandForRuntime(name config.Runtime) string {
+	return runtime.Get(name).Kubectl()
+}
+
+// InstalledRuntime returns the runtime installed on this node, see runtime.Detect
+func InstalledRuntime() (config.Runtime, error) {
+	installed, err := runtime.Detect()
+	if err != nil {
+		return config.RuntimeUnknown, err
 	}
-	return kubectl
+	return installed.Name(), nil
 }
 
+// GetKubeconfig returns kubeconfig if set, otherwise the kubeconfig of the installed
+// runtime. An error is returned if the runtime has no kubeconfig, as on agents.
 func GetKubeconfig(kubeconfig string) (string, error) {
 	if kubeconfig != "" {
 		return kubeconfig, nil
 	}
 
-	for _, kubeconfig := range kubeconfigs {
-		if _, err := os.Stat(kubeconfig); err == nil {
-			return kubeconfig, nil
-		}
+	installed, err := runtime.Detect()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(installed.Kubeconfig()); err != nil {
+		return "", fmt.Errorf("%s is installed but has no kubeconfig at %s: %w", installed.Name(), installed.Kubeconfig(), err)
 	}
-	return "", fmt.Errorf("failed to find kubeconfig file at %v", kubeconfigs)
+	return installed.Kubeconfig(), nil
 }
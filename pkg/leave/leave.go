@@ -0,0 +1,222 @@
+package leave
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/rancher/rancherd/pkg/kubectl"
+	"github.com/rancher/rancherd/pkg/rancher"
+	"github.com/rancher/rancherd/pkg/reset"
+	"github.com/rancher/wrangler/pkg/data"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	etcdRoleLabel         = "node-role.kubernetes.io/etcd"
+	controlPlaneRoleLabel = "node-role.kubernetes.io/control-plane"
+	// k3s and RKE2 remove the etcd member of a node with this annotation and record the
+	// removal in removedNodeNameAnnotation
+	etcdRemoveAnnotation      = "etcd.k3s.cattle.io/remove"
+	removedNodeNameAnnotation = "etcd.k3s.cattle.io/removed-node-name"
+	etcdRemoveTimeout         = 5 * time.Minute
+	machineNamespace          = "fleet-local"
+)
+
+type Options struct {
+	DataDir  string
+	NodeName string
+}
+
+// Leave removes this node from the cluster: it is drained, its etcd member is removed,
+// the Rancher machine and the node are deleted and then the node is reset.
+func Leave(ctx context.Context, opts Options) error {
+	kubeconfig, err := kubectl.GetKubeconfig("")
+	if err != nil {
+		return fmt.Errorf("leave must be run on a server node, remove agents in Rancher and run rancherd reset: %w", err)
+	}
+
+	conf, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	k8s, err := kubernetes.NewForConfig(conf)
+	if err != nil {
+		return err
+	}
+
+	node, err := k8s.CoreV1().Nodes().Get(ctx, opts.NodeName, v1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting node %s: %w", opts.NodeName, err)
+	}
+
+	isEtcd := node.Labels[etcdRoleLabel] == "true"
+	if isEtcd {
+		if err := checkQuorum(ctx, k8s, node); err != nil {
+			return err
+		}
+	}
+
+	// the API server of this node stops once its etcd member is removed, so the
+	// cluster is accessed through another control plane node from now on
+	peer, err := peerConfig(ctx, k8s, conf, opts.NodeName)
+	if err != nil {
+		return err
+	}
+
+	if err := reset.Drain(ctx, opts.NodeName); err != nil {
+		return err
+	}
+
+	if k8s, err = kubernetes.NewForConfig(peer); err != nil {
+		return err
+	}
+	dynamicClient, err := dynamic.NewForConfig(peer)
+	if err != nil {
+		return err
+	}
+
+	if isEtcd {
+		if err := removeEtcdMember(ctx, k8s, opts.NodeName); err != nil {
+			return err
+		}
+	}
+
+	if err := deleteMachines(ctx, k8s, dynamicClient, opts.NodeName); err != nil {
+		return err
+	}
+
+	logrus.Infof("Deleting node %s", opts.NodeName)
+	if err := k8s.CoreV1().Nodes().Delete(ctx, opts.NodeName, v1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleting node %s: %w", opts.NodeName, err)
+	}
+
+	return reset.Reset(ctx, reset.Options{
+		DataDir:  opts.DataDir,
+		NodeName: opts.NodeName,
+	})
+}
+
+// checkQuorum returns an error if removing the etcd member of node would leave the
+// remaining etcd members without quorum
+func checkQuorum(ctx context.Context, k8s kubernetes.Interface, node *corev1.Node) error {
+	nodes, err := k8s.CoreV1().Nodes().List(ctx, v1.ListOptions{
+		LabelSelector: etcdRoleLabel + "=true",
+	})
+	if err != nil {
+		return fmt.Errorf("listing etcd nodes: %w", err)
+	}
+
+	var members, ready int
+	for _, other := range nodes.Items {
+		if other.Name == node.Name {
+			continue
+		}
+		members++
+		if isReady(&other) {
+			ready++
+		}
+	}
+
+	if members == 0 {
+		return fmt.Errorf("node %s is the last etcd member, removing it would destroy the cluster", node.Name)
+	}
+	if quorum := members/2 + 1; ready < quorum {
+		return fmt.Errorf("removing the etcd member of node %s would break quorum: %d of the remaining %d etcd members are ready, %d are required",
+			node.Name, ready, members, quorum)
+	}
+	return nil
+}
+
+// peerConfig returns conf pointing to the API server of another ready control plane node
+func peerConfig(ctx context.Context, k8s kubernetes.Interface, conf *rest.Config, nodeName string) (*rest.Config, error) {
+	nodes, err := k8s.CoreV1().Nodes().List(ctx, v1.ListOptions{
+		LabelSelector: controlPlaneRoleLabel + "=true",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing control plane nodes: %w", err)
+	}
+
+	for _, node := range nodes.Items {
+		if node.Name == nodeName || !isReady(&node) {
+			continue
+		}
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == corev1.NodeInternalIP {
+				peer := rest.CopyConfig(conf)
+				peer.Host = "https://" + net.JoinHostPort(addr.Address, "6443")
+				logrus.Infof("Using the API server of node %s", node.Name)
+				return peer, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no other ready control plane node found, node %s can not leave the cluster", nodeName)
+}
+
+func removeEtcdMember(ctx context.Context, k8s kubernetes.Interface, nodeName string) error {
+	logrus.Infof("Removing etcd member of node %s", nodeName)
+
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:"true"}}}`, etcdRemoveAnnotation)
+	if _, err := k8s.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, []byte(patch), v1.PatchOptions{}); err != nil {
+		return fmt.Errorf("annotating node %s for etcd member removal: %w", nodeName, err)
+	}
+
+	err := wait.PollImmediate(2*time.Second, etcdRemoveTimeout, func() (bool, error) {
+		node, err := k8s.CoreV1().Nodes().Get(ctx, nodeName, v1.GetOptions{})
+		if err != nil {
+			logrus.Debugf("waiting for etcd member removal of %s: %v", nodeName, err)
+			return false, nil
+		}
+		return node.Annotations[removedNodeNameAnnotation] != "", nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for etcd member of node %s to be removed: %w", nodeName, err)
+	}
+	return nil
+}
+
+// deleteMachines deletes the Rancher machines of the local cluster that reference nodeName
+func deleteMachines(ctx context.Context, k8s kubernetes.Interface, client dynamic.Interface, nodeName string) error {
+	machineGVR, err := rancher.MachineGVR(k8s.Discovery())
+	if err != nil {
+		return err
+	}
+
+	machines, err := client.Resource(machineGVR).Namespace(machineNamespace).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("listing machines: %w", err)
+	}
+
+	for _, machine := range machines.Items {
+		if data.Object(machine.Object).String("status", "nodeRef", "name") != nodeName {
+			continue
+		}
+		logrus.Infof("Deleting machine %s/%s", machine.GetNamespace(), machine.GetName())
+		err := client.Resource(machineGVR).Namespace(machine.GetNamespace()).Delete(ctx, machine.GetName(), v1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting machine %s/%s: %w", machine.GetNamespace(), machine.GetName(), err)
+		}
+	}
+	return nil
+}
+
+func isReady(node *corev1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -1,7 +1,6 @@
 package os
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 
@@ -10,23 +9,22 @@ import (
 	"github.com/rancher/system-agent/pkg/applyinator"
 )
 
-func ToUpgradeInstruction(k8sVersion, rancherOSVersion string) (*applyinator.Instruction, error) {
+// ToUpgradeInstruction returns the instruction that sets the image of the ManagedOSImage
+// to rancherOSVersion and waits until all nodes but nodeName are upgraded, see Upgrade
+func ToUpgradeInstruction(k8sVersion, rancherOSVersion, nodeName string) (*applyinator.Instruction, error) {
 	cmd, err := self.Self()
 	if err != nil {
 		return nil, fmt.Errorf("resolving location of %s: %w", os.Args[0], err)
 	}
-	patch, err := json.Marshal(map[string]interface{}{
-		"spec": map[string]interface{}{
-			"osImage": rancherOSVersion,
-		},
-	})
-	if err != nil {
-		return nil, err
+	args := []string{"upgrade-os", "--os-image", rancherOSVersion,
+		"--timeout", DefaultUpgradeTimeout.String(), "--node-timeout", DefaultNodeTimeout.String()}
+	if nodeName != "" {
+		args = append(args, "--node-name", nodeName)
 	}
 	return &applyinator.Instruction{
-		Name:       "patch-rancher-os-version",
+		Name:       "upgrade-rancher-os",
 		SaveOutput: true,
-		Args:       []string{"retry", kubectl.Command(k8sVersion), "--type=merge", "-n", "fleet-local", "patch", "managedosimages.rancheros.cattle.io", "default-os-image", "-p", string(patch)},
+		Args:       args,
 		Env:        kubectl.Env(k8sVersion),
 		Command:    cmd,
 	}, nil
@@ -0,0 +1,469 @@
+package os
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/rancherd/pkg/kubectl"
+	"github.com/rancher/wrangler/pkg/data"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	managedOSImageNamespace = "fleet-local"
+	managedOSImageName      = "default-os-image"
+	// upgradePlanPrefix is the prefix of the SUC plans created for ManagedOSImages
+	upgradePlanPrefix = "os-upgrader"
+	// SUC labels nodes with the hash of the plan they were upgraded to
+	planNodeLabelPrefix = "plan.upgrade.cattle.io/"
+	jobPlanLabel        = "upgrade.cattle.io/plan"
+	jobNodeLabel        = "upgrade.cattle.io/node"
+
+	// DefaultUpgradeTimeout is how long to wait for all nodes to be upgraded
+	DefaultUpgradeTimeout = 30 * time.Minute
+	// DefaultNodeTimeout is how long a node can make no upgrade progress
+	DefaultNodeTimeout = 15 * time.Minute
+)
+
+var (
+	managedOSImageGVR = schema.GroupVersionResource{
+		Group:    "rancheros.cattle.io",
+		Version:  "v1",
+		Resource: "managedosimages",
+	}
+	planGVR = schema.GroupVersionResource{
+		Group:    "upgrade.cattle.io",
+		Version:  "v1",
+		Resource: "plans",
+	}
+)
+
+type NodePhase string
+
+const (
+	NodePhasePending   NodePhase = "pending"
+	NodePhaseUpgrading NodePhase = "upgrading"
+	NodePhaseUpgraded  NodePhase = "upgraded"
+	NodePhaseFailed    NodePhase = "failed"
+)
+
+type UpgradeOptions struct {
+	Kubeconfig string
+	// OSImage is the image the nodes are upgraded to
+	OSImage string
+	// NodeName is the node running the upgrade, which is not waited for
+	NodeName string
+	// Timeout is how long to wait for all nodes to be upgraded
+	Timeout time.Duration
+	// NodeTimeout is how long a node can be upgrading, or pending while no node makes
+	// progress, before the upgrade fails
+	NodeTimeout time.Duration
+	Interval    time.Duration
+}
+
+// Upgrade sets the image of the ManagedOSImage to OSImage and waits until its SUC plans
+// upgraded all nodes. The progress of every node is logged. An error is returned if the
+// job of a node failed, a node made no progress within NodeTimeout or not all nodes were
+// upgraded within Timeout.
+//
+// The node NodeName is not waited for: it reboots into the new image when it is upgraded,
+// which ends Upgrade, so waiting for it could never succeed. Its upgrade is left to SUC and
+// shown by rancherd upgrade status.
+func Upgrade(ctx context.Context, opts UpgradeOptions) error {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultUpgradeTimeout
+	}
+	if opts.NodeTimeout == 0 {
+		opts.NodeTimeout = DefaultNodeTimeout
+	}
+	if opts.Interval == 0 {
+		opts.Interval = 10 * time.Second
+	}
+
+	kubeconfig, err := kubectl.GetKubeconfig(opts.Kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	conf, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	client, err := dynamic.NewForConfig(conf)
+	if err != nil {
+		return err
+	}
+
+	k8s, err := kubernetes.NewForConfig(conf)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	if opts.NodeName != "" {
+		logrus.Infof("Not waiting for the upgrade of node %s, it reboots when it is upgraded", opts.NodeName)
+	}
+
+	var (
+		previous map[string]string
+		progress = newUpgradeProgress()
+	)
+	for {
+		if previous == nil {
+			if hashes, err := patchOSImage(ctx, client, opts.OSImage); err != nil {
+				logrus.Infof("Waiting to set the image of ManagedOSImage %s/%s: %v", managedOSImageNamespace, managedOSImageName, err)
+			} else {
+				previous = hashes
+			}
+		}
+
+		if previous != nil {
+			done, err := checkUpgrade(ctx, client, k8s, opts.OSImage, opts.NodeName, previous, progress)
+			if err != nil {
+				return err
+			}
+			if done {
+				logrus.Infof("All nodes are upgraded to %s", opts.OSImage)
+				return nil
+			}
+			if err := progress.stuck(time.Now(), opts.NodeTimeout); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("nodes were not upgraded to %s within %s: %s", opts.OSImage, opts.Timeout, progress.pending())
+		case <-time.After(opts.Interval):
+		}
+	}
+}
+
+// patchOSImage sets the image of the ManagedOSImage and returns the latest hash of each of
+// its SUC plans that did not target osImage before
+func patchOSImage(ctx context.Context, client dynamic.Interface, osImage string) (map[string]string, error) {
+	plans, err := upgradePlans(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := map[string]string{}
+	for _, plan := range plans {
+		if !planTargets(plan, osImage) {
+			previous[plan.GetName()] = data.Object(plan.Object).String("status", "latestHash")
+		}
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"osImage": osImage,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Setting the image of ManagedOSImage %s/%s to %s", managedOSImageNamespace, managedOSImageName, osImage)
+	_, err = client.Resource(managedOSImageGVR).Namespace(managedOSImageNamespace).Patch(ctx, managedOSImageName, types.MergePatchType, patch, v1.PatchOptions{})
+	return previous, err
+}
+
+// checkUpgrade updates progress with the upgrade phase of each node but localNode and
+// returns true if they are all upgraded. A plan is only considered once it targets osImage
+// and its latest hash differs from the hash in previous, the hash it had before the upgrade.
+func checkUpgrade(ctx context.Context, client dynamic.Interface, k8s kubernetes.Interface, osImage, localNode string, previous map[string]string, progress *upgradeProgress) (bool, error) {
+	image, err := client.Resource(managedOSImageGVR).Namespace(managedOSImageNamespace).Get(ctx, managedOSImageName, v1.GetOptions{})
+	if err != nil {
+		logrus.Infof("Waiting for ManagedOSImage %s/%s: %v", managedOSImageNamespace, managedOSImageName, err)
+		return false, nil
+	}
+	if current := data.Object(image.Object).String("spec", "osImage"); current != osImage {
+		return false, fmt.Errorf("ManagedOSImage %s/%s was changed to %s while waiting for %s", managedOSImageNamespace, managedOSImageName, current, osImage)
+	}
+
+	plans, err := upgradePlans(ctx, client)
+	if err != nil {
+		return false, err
+	}
+	if len(plans) == 0 {
+		logrus.Infof("Waiting for the upgrade plan of ManagedOSImage %s/%s", managedOSImageNamespace, managedOSImageName)
+		return false, nil
+	}
+
+	done := true
+	for _, plan := range plans {
+		latestHash := data.Object(plan.Object).String("status", "latestHash")
+		if hash, ok := previous[plan.GetName()]; !planTargets(plan, osImage) || ok && hash == latestHash {
+			logrus.Infof("Waiting for upgrade plan %s/%s to be updated to %s", plan.GetNamespace(), plan.GetName(), osImage)
+			done = false
+			continue
+		}
+
+		planDone, err := checkPlan(ctx, k8s, plan, localNode, progress)
+		if err != nil {
+			return false, err
+		}
+		done = done && planDone
+	}
+	return done, nil
+}
+
+func checkPlan(ctx context.Context, k8s kubernetes.Interface, plan unstructured.Unstructured, localNode string, progress *upgradeProgress) (bool, error) {
+	planPhases, err := nodePhases(ctx, k8s, plan)
+	if err != nil {
+		return false, err
+	}
+	if planPhases == nil {
+		logrus.Infof("Waiting for upgrade plan %s/%s to be resolved", plan.GetNamespace(), plan.GetName())
+		return false, nil
+	}
+
+	var names []string
+	for name := range planPhases {
+		if name != localNode {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var failed []string
+	done := true
+	for _, name := range names {
+		phase := planPhases[name]
+		progress.update(name, phase, time.Now())
+
+		switch phase {
+		case NodePhaseFailed:
+			failed = append(failed, name)
+		case NodePhaseUpgraded:
+		default:
+			done = false
+		}
+	}
+
+	if len(failed) > 0 {
+		return false, fmt.Errorf("upgrade of nodes %s failed, see the jobs of plan %s/%s", strings.Join(failed, ", "), plan.GetNamespace(), plan.GetName())
+	}
+	return done, nil
+}
+
+// upgradeProgress records since when each node is in its upgrade phase
+type upgradeProgress struct {
+	phases map[string]NodePhase
+	since  map[string]time.Time
+	// changed is the last time a node changed phase
+	changed time.Time
+}
+
+func newUpgradeProgress() *upgradeProgress {
+	return &upgradeProgress{
+		phases:  map[string]NodePhase{},
+		since:   map[string]time.Time{},
+		changed: time.Now(),
+	}
+}
+
+func (p *upgradeProgress) update(name string, phase NodePhase, now time.Time) {
+	if p.phases[name] == phase {
+		return
+	}
+	logrus.Infof("Node %s: %s", name, phase)
+	p.phases[name] = phase
+	p.since[name] = now
+	p.changed = now
+}
+
+// stuck returns an error if a node is upgrading for longer than timeout, or is pending
+// while no node changed phase within timeout
+func (p *upgradeProgress) stuck(now time.Time, timeout time.Duration) error {
+	var stuck []string
+	for name, phase := range p.phases {
+		switch {
+		case phase == NodePhaseUpgrading && now.Sub(p.since[name]) > timeout:
+			stuck = append(stuck, fmt.Sprintf("%s (upgrading since %s)", name, p.since[name].Format(time.RFC3339)))
+		case phase == NodePhasePending && now.Sub(p.changed) > timeout:
+			stuck = append(stuck, fmt.Sprintf("%s (pending since %s)", name, p.since[name].Format(time.RFC3339)))
+		}
+	}
+	if len(stuck) == 0 {
+		return nil
+	}
+	sort.Strings(stuck)
+	return fmt.Errorf("nodes made no upgrade progress within %s: %s", timeout, strings.Join(stuck, ", "))
+}
+
+func (p *upgradeProgress) pending() string {
+	var pending []string
+	for node, phase := range p.phases {
+		if phase != NodePhaseUpgraded {
+			pending = append(pending, fmt.Sprintf("%s (%s)", node, phase))
+		}
+	}
+	if len(pending) == 0 {
+		return "upgrade plans were not updated"
+	}
+	sort.Strings(pending)
+	return strings.Join(pending, ", ")
+}
+
+// planTargets returns true if the SUC plan upgrades to osImage and was resolved by SUC.
+// The plans of a ManagedOSImage have the repository of the image, possibly prefixed with
+// a private registry, and the tag as version.
+func planTargets(plan unstructured.Unstructured, osImage string) bool {
+	obj := data.Object(plan.Object)
+	repo, tag := osImage, "latest"
+	if i := strings.LastIndex(osImage, ":"); i > strings.LastIndex(osImage, "/") {
+		repo, tag = osImage[:i], osImage[i+1:]
+	}
+	image := obj.String("spec", "upgrade", "image")
+	return obj.String("spec", "version") == tag && obj.String("status", "latestVersion") == tag &&
+		(image == repo || strings.HasSuffix(image, "/"+repo))
+}
+
+// UpgradePhases returns the image of the ManagedOSImage and the upgrade phase of every
+// node targeted by its SUC plans
+func UpgradePhases(ctx context.Context, client dynamic.Interface, k8s kubernetes.Interface) (string, map[string]NodePhase, error) {
+	image, err := client.Resource(managedOSImageGVR).Namespace(managedOSImageNamespace).Get(ctx, managedOSImageName, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	plans, err := upgradePlans(ctx, client)
+	if err != nil {
+		return "", nil, err
+	}
+
+	osImage := data.Object(image.Object).String("spec", "osImage")
+	result := map[string]NodePhase{}
+	for _, plan := range plans {
+		planPhases, err := nodePhases(ctx, k8s, plan)
+		if err != nil {
+			return "", nil, err
+		}
+		for name, phase := range planPhases {
+			// nodes still have the label of the previous image until the plan is updated
+			if phase == NodePhaseUpgraded && !planTargets(plan, osImage) {
+				phase = NodePhasePending
+			}
+			result[name] = phase
+		}
+	}
+
+	return osImage, result, nil
+}
+
+// nodePhases returns the upgrade phase of each node targeted by plan, or nil if the
+// plan is not resolved yet
+func nodePhases(ctx context.Context, k8s kubernetes.Interface, plan unstructured.Unstructured) (map[string]NodePhase, error) {
+	status := data.Object(plan.Object).Map("status")
+	latestHash := status.String("latestHash")
+	if latestHash == "" {
+		return nil, nil
+	}
+
+	selector, err := nodeSelector(plan)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := k8s.CoreV1().Nodes().List(ctx, v1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	applying := map[string]bool{}
+	for _, node := range status.StringSlice("applying") {
+		applying[node] = true
+	}
+
+	result := map[string]NodePhase{}
+	for _, node := range nodes.Items {
+		phase := NodePhasePending
+		switch {
+		case node.Labels[planNodeLabelPrefix+plan.GetName()] == latestHash:
+			phase = NodePhaseUpgraded
+		case applying[node.Name]:
+			phase = NodePhaseUpgrading
+			if jobFailed(ctx, k8s, plan, node.Name) {
+				phase = NodePhaseFailed
+			}
+		}
+		result[node.Name] = phase
+	}
+	return result, nil
+}
+
+// upgradePlans returns the SUC plans created for the ManagedOSImage
+func upgradePlans(ctx context.Context, client dynamic.Interface) ([]unstructured.Unstructured, error) {
+	plans, err := client.Resource(planGVR).List(ctx, v1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var result []unstructured.Unstructured
+	for _, plan := range plans.Items {
+		if strings.HasPrefix(plan.GetName(), upgradePlanPrefix) {
+			result = append(result, plan)
+		}
+	}
+	return result, nil
+}
+
+func nodeSelector(plan unstructured.Unstructured) (labels.Selector, error) {
+	obj, ok, err := unstructured.NestedMap(plan.Object, "spec", "nodeSelector")
+	if err != nil || !ok {
+		return labels.Everything(), err
+	}
+
+	selector := &v1.LabelSelector{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj, selector); err != nil {
+		return nil, fmt.Errorf("node selector of plan %s/%s: %w", plan.GetNamespace(), plan.GetName(), err)
+	}
+	return v1.LabelSelectorAsSelector(selector)
+}
+
+func jobFailed(ctx context.Context, k8s kubernetes.Interface, plan unstructured.Unstructured, nodeName string) bool {
+	jobs, err := k8s.BatchV1().Jobs(plan.GetNamespace()).List(ctx, v1.ListOptions{
+		LabelSelector: labels.Set{
+			jobPlanLabel: plan.GetName(),
+			jobNodeLabel: nodeName,
+		}.String(),
+	})
+	if err != nil {
+		logrus.Debugf("listing upgrade jobs of node %s: %v", nodeName, err)
+		return false
+	}
+
+	for _, job := range jobs.Items {
+		for _, cond := range job.Status.Conditions {
+			if cond.Type == "Failed" && cond.Status == corev1.ConditionTrue {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package os
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testPlanName = "os-upgrader-default-os-image"
+	oldOSImage   = "rancher/os2:v0.1.0"
+	newOSImage   = "rancher/os2:v0.2.0"
+)
+
+func testPlan(version, latestVersion, latestHash string, applying ...interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "upgrade.cattle.io/v1",
+			"kind":       "Plan",
+			"metadata": map[string]interface{}{
+				"name":      testPlanName,
+				"namespace": "cattle-system",
+			},
+			"spec": map[string]interface{}{
+				"version": version,
+				"upgrade": map[string]interface{}{
+					"image": "registry.example.com/rancher/os2",
+				},
+			},
+			"status": map[string]interface{}{
+				"latestVersion": latestVersion,
+				"latestHash":    latestHash,
+				"applying":      applying,
+			},
+		},
+	}
+}
+
+func testManagedOSImage(osImage string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "rancheros.cattle.io/v1",
+			"kind":       "ManagedOSImage",
+			"metadata": map[string]interface{}{
+				"name":      managedOSImageName,
+				"namespace": managedOSImageNamespace,
+			},
+			"spec": map[string]interface{}{
+				"osImage": osImage,
+			},
+		},
+	}
+}
+
+func testNode(name, hash string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: v1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				planNodeLabelPrefix + testPlanName: hash,
+			},
+		},
+	}
+}
+
+func checkTestUpgrade(t *testing.T, plan *unstructured.Unstructured, previous map[string]string, localNode string, nodes ...runtime.Object) (bool, *upgradeProgress) {
+	t.Helper()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		planGVR:           "PlanList",
+		managedOSImageGVR: "ManagedOSImageList",
+	}, testManagedOSImage(newOSImage), plan)
+	progress := newUpgradeProgress()
+	done, err := checkUpgrade(context.Background(), client, fake.NewSimpleClientset(nodes...), newOSImage, localNode, previous, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return done, progress
+}
+
+func TestCheckUpgrade(t *testing.T) {
+	previous := map[string]string{
+		testPlanName: "old",
+	}
+
+	// the plan is not updated yet and the node still has the label of the old image
+	if done, _ := checkTestUpgrade(t, testPlan("v0.1.0", "v0.1.0", "old"), previous, "", testNode("node1", "old")); done {
+		t.Errorf("upgrade is done before the plan targets %s", newOSImage)
+	}
+
+	// the plan targets the new image but SUC did not compute its new hash yet
+	if done, _ := checkTestUpgrade(t, testPlan("v0.2.0", "v0.2.0", "old"), previous, "", testNode("node1", "old")); done {
+		t.Errorf("upgrade is done with the hash of the plan before the upgrade")
+	}
+
+	done, progress := checkTestUpgrade(t, testPlan("v0.2.0", "v0.2.0", "new", "node1"), previous, "", testNode("node1", "old"))
+	if done || progress.phases["node1"] != NodePhaseUpgrading {
+		t.Errorf("done = %v, phase = %s, expected node1 to be upgrading", done, progress.phases["node1"])
+	}
+
+	done, progress = checkTestUpgrade(t, testPlan("v0.2.0", "v0.2.0", "new"), previous, "", testNode("node1", "new"))
+	if !done || progress.phases["node1"] != NodePhaseUpgraded {
+		t.Errorf("done = %v, phase = %s, expected node1 to be upgraded", done, progress.phases["node1"])
+	}
+
+	// the plan already targeted the image before the upgrade
+	if done, _ := checkTestUpgrade(t, testPlan("v0.2.0", "v0.2.0", "new"), map[string]string{}, "", testNode("node1", "new")); !done {
+		t.Errorf("upgrade to the current image is not done")
+	}
+}
+
+func TestCheckUpgradeLocalNode(t *testing.T) {
+	previous := map[string]string{
+		testPlanName: "old",
+	}
+
+	// the local node reboots when it is upgraded, only node2 is waited for
+	done, progress := checkTestUpgrade(t, testPlan("v0.2.0", "v0.2.0", "new"), previous, "node1",
+		testNode("node1", "old"), testNode("node2", "new"))
+	if !done {
+		t.Errorf("upgrade is not done while only the local node is pending")
+	}
+	if _, ok := progress.phases["node1"]; ok {
+		t.Errorf("progress of the local node is tracked: %s", progress.phases["node1"])
+	}
+
+	if done, _ := checkTestUpgrade(t, testPlan("v0.2.0", "v0.2.0", "new", "node2"), previous, "node1",
+		testNode("node1", "new"), testNode("node2", "old")); done {
+		t.Errorf("upgrade is done while node2 is upgrading")
+	}
+}
+
+func TestUpgradeProgressStuck(t *testing.T) {
+	start := time.Now()
+	progress := newUpgradeProgress()
+	progress.update("node1", NodePhaseUpgrading, start)
+	progress.update("node2", NodePhasePending, start)
+
+	if err := progress.stuck(start.Add(10*time.Minute), 15*time.Minute); err != nil {
+		t.Errorf("nodes are stuck before the node timeout: %v", err)
+	}
+	if err := progress.stuck(start.Add(20*time.Minute), 15*time.Minute); err == nil {
+		t.Errorf("expected node1 to be stuck upgrading")
+	}
+
+	// pending nodes are waiting for other nodes as long as they make progress
+	progress.update("node1", NodePhaseUpgraded, start.Add(14*time.Minute))
+	progress.update("node3", NodePhaseUpgrading, start.Add(14*time.Minute))
+	if err := progress.stuck(start.Add(20*time.Minute), 15*time.Minute); err != nil {
+		t.Errorf("pending node is stuck while other nodes make progress: %v", err)
+	}
+	progress.update("node3", NodePhaseUpgraded, start.Add(21*time.Minute))
+	if err := progress.stuck(start.Add(40*time.Minute), 15*time.Minute); err == nil {
+		t.Errorf("expected node2 to be stuck pending")
+	}
+}
+
+func TestPlanTargets(t *testing.T) {
+	plan := testPlan("v0.2.0", "v0.2.0", "new")
+	if !planTargets(*plan, newOSImage) {
+		t.Errorf("plan with a private registry prefix does not target %s", newOSImage)
+	}
+	if planTargets(*plan, oldOSImage) {
+		t.Errorf("plan targets %s", oldOSImage)
+	}
+	if planTargets(*testPlan("v0.2.0", "v0.1.0", "old"), newOSImage) {
+		t.Errorf("plan targets %s before it is resolved", newOSImage)
+	}
+}
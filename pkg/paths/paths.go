@@ -0,0 +1,59 @@
+package paths
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// RootEnv is the environment variable of the root prefix. SetRoot sets it so that the
+// rancherd commands run by plans resolve the same paths.
+const RootEnv = "RANCHERD_ROOT"
+
+// SetRoot sets the dir prepended to all host paths
+func SetRoot(root string) error {
+	if root == "" {
+		return nil
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return fmt.Errorf("resolving root %s: %w", root, err)
+	}
+	return os.Setenv(RootEnv, abs)
+}
+
+// Root returns the dir prepended to all host paths, "/" if not set
+func Root() string {
+	if root := os.Getenv(RootEnv); root != "" {
+		return root
+	}
+	return "/"
+}
+
+// RequireHost returns an error if a root is set. The installers, scripts and systemctl
+// run by command act on the running host, they can not be redirected to the root.
+func RequireHost(command string) error {
+	if root := Root(); root != "/" {
+		return fmt.Errorf("%s changes the running host and can not be used with root %s, only prepare, bootstrap --dry-run and upgrade --plan-only support a root", command, root)
+	}
+	return nil
+}
+
+// Host returns the absolute host path prefixed with the root, relative paths are
+// returned unchanged
+func Host(path string) string {
+	root := Root()
+	if root == "/" || !filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+// HostAll returns the host paths prefixed with the root
+func HostAll(paths ...string) []string {
+	result := make([]string, 0, len(paths))
+	for _, path := range paths {
+		result = append(result, Host(path))
+	}
+	return result
+}
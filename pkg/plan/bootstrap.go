@@ -1,7 +1,6 @@
 package plan
 
 import (
-	"context"
 	"fmt"
 	"strings"
 
@@ -9,9 +8,11 @@ import (
 
 	"github.com/rancher/rancherd/pkg/cacerts"
 	"github.com/rancher/rancherd/pkg/config"
-	"github.com/rancher/rancherd/pkg/discovery"
+	"github.com/rancher/rancherd/pkg/images"
+	"github.com/rancher/rancherd/pkg/imageverify"
 	"github.com/rancher/rancherd/pkg/join"
 	"github.com/rancher/rancherd/pkg/kubectl"
+	"github.com/rancher/rancherd/pkg/paths"
 	"github.com/rancher/rancherd/pkg/probe"
 	"github.com/rancher/rancherd/pkg/rancher"
 	"github.com/rancher/rancherd/pkg/registry"
@@ -23,7 +24,11 @@ import (
 type plan applyinator.Plan
 
 func toInitPlan(config *config.Config, dataDir string) (*applyinator.Plan, error) {
-	if err := assignTokenIfUnset(config); err != nil {
+	if err := assignTokenIfUnset(config, dataDir); err != nil {
+		return nil, err
+	}
+
+	if err := assignSealedRegistries(config, dataDir); err != nil {
 		return nil, err
 	}
 
@@ -44,6 +49,10 @@ func toInitPlan(config *config.Config, dataDir string) (*applyinator.Plan, error
 }
 
 func toJoinPlan(cfg *config.Config, dataDir string) (*applyinator.Plan, error) {
+	if err := assignSealedRegistries(cfg, dataDir); err != nil {
+		return nil, err
+	}
+
 	if cfg.Server == "" {
 		return nil, fmt.Errorf("server is required in config for all roles besides cluster-init")
 	}
@@ -64,7 +73,7 @@ func toJoinPlan(cfg *config.Config, dataDir string) (*applyinator.Plan, error) {
 	if err := plan.addInstruction(join.ToInstruction(cfg, dataDir)); err != nil {
 		return nil, err
 	}
-	if err := plan.addInstruction(probe.ToInstruction()); err != nil {
+	if err := plan.addInstruction(probe.ToJoinInstruction(GetPlanFile(dataDir), cfg.Role)); err != nil {
 		return nil, err
 	}
 	if err := plan.addProbesForJoin(cfg); err != nil {
@@ -74,11 +83,10 @@ func toJoinPlan(cfg *config.Config, dataDir string) (*applyinator.Plan, error) {
 	return (*applyinator.Plan)(&plan), nil
 }
 
-func ToPlan(ctx context.Context, config *config.Config, dataDir string) (*applyinator.Plan, error) {
+// ToPlan returns the bootstrap plan of config. The server and role must already be
+// discovered. Generating the plan has no side effects, secrets are sealed by SealSecrets.
+func ToPlan(config *config.Config, dataDir string) (*applyinator.Plan, error) {
 	newCfg := *config
-	if err := discovery.DiscoverServerAndRole(ctx, &newCfg); err != nil {
-		return nil, err
-	}
 	if newCfg.Role == "cluster-init" {
 		return toInitPlan(&newCfg, dataDir)
 	}
@@ -95,7 +103,7 @@ func (p *plan) addInstructions(cfg *config.Config, dataDir string) error {
 		return err
 	}
 
-	if err := p.addInstruction(probe.ToInstruction()); err != nil {
+	if err := p.addInstruction(probe.ToInstruction(GetPlanFile(dataDir))); err != nil {
 		return err
 	}
 
@@ -131,7 +139,7 @@ func (p *plan) addInstructions(cfg *config.Config, dataDir string) error {
 		return err
 	}
 
-	if err := p.addInstruction(resources.ToInstruction(cfg.RancherInstallerImage, cfg.SystemDefaultRegistry, k8sVersion, dataDir)); err != nil {
+	if err := p.addInstruction(resources.ToInstruction(cfg.RancherInstallerImage, cfg.SystemDefaultRegistry, k8sVersion, getBootstrapManifests(cfg, dataDir))); err != nil {
 		return err
 	}
 
@@ -155,6 +163,42 @@ func (p *plan) addInstructions(cfg *config.Config, dataDir string) error {
 	}
 
 	p.addPrePostInstructions(cfg, k8sVersion)
+
+	return p.addImageVerification(cfg, map[string]string{
+		runtime.InstallerImage(cfg.RuntimeInstallerImage, cfg.SystemDefaultRegistry, k8sVersion):              cfg.RuntimeInstallerDigest,
+		images.GetRancherInstallerImage(cfg.RancherInstallerImage, cfg.SystemDefaultRegistry, rancherVersion): cfg.RancherInstallerDigest,
+	}, k8sVersion, dataDir)
+}
+
+// addImageVerification puts an instruction verifying each image used by the plan at the
+// start of the plan, so no instruction runs if an image fails verification. digests maps
+// the installer images to their pinned digest.
+func (p *plan) addImageVerification(cfg *config.Config, digests map[string]string, k8sVersion, dataDir string) error {
+	var (
+		instructions   []applyinator.Instruction
+		verified       = map[string]bool{}
+		registriesFile = registry.GetConfigFile(runtime.ForVersion(k8sVersion).Name())
+	)
+	if sealing(cfg) && cfg.Registries != nil {
+		registriesFile = paths.Host(getUnsealedFile(unsealedRegistriesFile))
+	}
+
+	for _, inst := range p.Instructions {
+		if inst.Image == "" || verified[inst.Image] {
+			continue
+		}
+		verified[inst.Image] = true
+
+		verify, err := imageverify.ToInstruction(cfg, "verify-"+inst.Name, inst.Image, digests[inst.Image],
+			registriesFile, dataDir)
+		if err != nil {
+			return err
+		} else if verify != nil {
+			instructions = append(instructions, *verify)
+		}
+	}
+
+	p.Instructions = append(instructions, p.Instructions...)
 	return nil
 }
 
@@ -193,10 +237,17 @@ func (p *plan) addFiles(cfg *config.Config, dataDir string) error {
 	if err != nil {
 		return err
 	}
-	runtimeName := config.GetRuntime(k8sVersions)
+	runtimeName := runtime.ForVersion(k8sVersions).Name()
 
 	// config.yaml
-	if err := p.addFile(runtime.ToFile(&cfg.RuntimeConfig, runtimeName, true)); err != nil {
+	runtimeConfig := &cfg.RuntimeConfig
+	if sealing(cfg) {
+		runtimeConfig = sealedRuntimeConfig(cfg)
+		if err := p.addFile(toUnsealDropInFile(runtimeName, dataDir)); err != nil {
+			return err
+		}
+	}
+	if err := p.addFile(runtime.ToFile(runtimeConfig, runtimeName, true)); err != nil {
 		return err
 	}
 
@@ -205,19 +256,25 @@ func (p *plan) addFiles(cfg *config.Config, dataDir string) error {
 		return err
 	}
 
-	// registries.yaml
-	if err := p.addFile(registry.ToFile(cfg.Registries, runtimeName)); err != nil {
-		return err
+	// registries.yaml, the runtime reads the unsealed copy when sealing
+	if !sealing(cfg) {
+		if err := p.addFile(registry.ToFile(cfg.Registries, runtimeName)); err != nil {
+			return err
+		}
 	}
 
 	// bootstrap manifests
-	if err := p.addFile(resources.ToBootstrapFile(cfg, resources.GetBootstrapManifests(dataDir))); err != nil {
+	if err := p.addFile(resources.ToBootstrapFile(cfg, getBootstrapManifests(cfg, dataDir))); err != nil {
 		return err
 	}
 
 	// rancher values.yaml
-	return p.addFile(rancher.ToFile(cfg, dataDir))
+	if err := p.addFile(rancher.ToFile(cfg, dataDir)); err != nil {
+		return err
+	}
 
+	// public keys of the installer images
+	return p.addFile(imageverify.ToFile(cfg, dataDir))
 }
 
 func (p *plan) addFile(file *applyinator.File, err error) error {
@@ -238,6 +295,6 @@ func (p *plan) addProbes(cfg *config.Config) error {
 	if err != nil {
 		return err
 	}
-	p.Probes = probe.AllProbes(config.GetRuntime(k8sVersion))
+	p.Probes = probe.AllProbes(runtime.ForVersion(k8sVersion).Name())
 	return nil
 }
@@ -0,0 +1,83 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/rancherd/pkg/self"
+	"github.com/rancher/system-agent/pkg/applyinator"
+)
+
+const executionPwdEnv = "CATTLE_AGENT_EXECUTION_PWD"
+
+var imageDirReplacer = strings.NewReplacer("/", "_", ":", "_", "@", "_")
+
+// PreparedImagesDir returns where prepare extracts the images of the bootstrap plan.
+// The dir is outside of the work dir of the plans, which is removed on every apply.
+func PreparedImagesDir(dataDir string) string {
+	return filepath.Join(dataDir, "prepared-images")
+}
+
+// PreparedImageDir returns where prepare extracts image
+func PreparedImageDir(dataDir, image string) string {
+	return filepath.Join(PreparedImagesDir(dataDir), imageDirReplacer.Replace(image))
+}
+
+// UsePreparedImages changes the instructions of the plan using an image extracted by
+// prepare to run from the extracted dir, so the image is not extracted again. The
+// instructions are run by "rancherd run-prepared" which sets up the dir as the
+// applyinator does for the dirs it extracts.
+func UsePreparedImages(p *applyinator.Plan, dataDir string) error {
+	cmd, err := self.Self()
+	if err != nil {
+		return fmt.Errorf("resolving location of %s: %w", os.Args[0], err)
+	}
+
+	for i, inst := range p.Instructions {
+		if inst.Image == "" {
+			continue
+		}
+		dir := PreparedImageDir(dataDir, inst.Image)
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+
+		command := inst.Command
+		if command == "" {
+			command = filepath.Join(dir, "run.sh")
+		}
+		inst.Args = append([]string{"run-prepared", dir, command}, inst.Args...)
+		inst.Command = cmd
+		inst.Image = ""
+		p.Instructions[i] = inst
+	}
+
+	return nil
+}
+
+// RunPrepared runs args in dir extracted by prepare the way the applyinator runs an
+// instruction in the dir it extracts the image of the instruction to.
+func RunPrepared(ctx context.Context, dir string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command to run in %s", dir)
+	}
+
+	// the command is looked up with the dir in PATH, as the applyinator does
+	if err := os.Setenv("PATH", os.Getenv("PATH")+":"+dir); err != nil {
+		return err
+	}
+	if err := os.Setenv(executionPwdEnv, dir); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
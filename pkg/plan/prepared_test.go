@@ -0,0 +1,78 @@
+package plan
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rancher/rancherd/pkg/self"
+	"github.com/rancher/system-agent/pkg/applyinator"
+)
+
+func TestUsePreparedImages(t *testing.T) {
+	dataDir := t.TempDir()
+	k3sDir := PreparedImageDir(dataDir, "rancher/system-agent-installer-k3s:v1.24.10-k3s1")
+	rancherDir := PreparedImageDir(dataDir, "rancher/system-agent-installer-rancher:v2.7.1")
+	for _, dir := range []string{k3sDir, rancherDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd, err := self.Self()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &applyinator.Plan{
+		Instructions: []applyinator.Instruction{
+			{Name: "k3s", Image: "rancher/system-agent-installer-k3s:v1.24.10-k3s1", Env: []string{"RESTART_STAMP=1"}},
+			{Name: "probes", Command: "/usr/bin/rancherd", Args: []string{"probe"}},
+			{Name: "bootstrap", Image: "rancher/system-agent-installer-rancher:v2.7.1", Command: "/usr/bin/rancherd", Args: []string{"retry", "kubectl"}},
+			{Name: "not-prepared", Image: "example.com/other:v1"},
+		},
+	}
+
+	if err := UsePreparedImages(p, dataDir); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []applyinator.Instruction{
+		{Name: "k3s", Command: cmd, Args: []string{"run-prepared", k3sDir, filepath.Join(k3sDir, "run.sh")}, Env: []string{"RESTART_STAMP=1"}},
+		{Name: "probes", Command: "/usr/bin/rancherd", Args: []string{"probe"}},
+		{Name: "bootstrap", Command: cmd, Args: []string{"run-prepared", rancherDir, "/usr/bin/rancherd", "retry", "kubectl"}},
+		{Name: "not-prepared", Image: "example.com/other:v1"},
+	}
+	if !reflect.DeepEqual(p.Instructions, expected) {
+		t.Errorf("expected instructions %+v, got %+v", expected, p.Instructions)
+	}
+}
+
+func TestRunPrepared(t *testing.T) {
+	t.Setenv("PATH", os.Getenv("PATH"))
+	t.Setenv(executionPwdEnv, "")
+
+	dir := t.TempDir()
+	out := filepath.Join(t.TempDir(), "out")
+	script := "#!/bin/sh\necho \"$(pwd) $" + executionPwdEnv + " $*\" > " + out + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "installer.sh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// the command is found in the dir like the scripts of an extracted image
+	if err := RunPrepared(context.Background(), dir, []string{"installer.sh", "arg"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := dir + " " + dir + " arg"; strings.TrimSpace(string(data)) != expected {
+		t.Errorf("expected %q, got %q", expected, strings.TrimSpace(string(data)))
+	}
+}
@@ -0,0 +1,55 @@
+package plan
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/rancher/system-agent/pkg/applyinator"
+	"github.com/rancher/system-agent/pkg/prober"
+)
+
+type printedFile struct {
+	Path        string `json:"path,omitempty"`
+	Permissions string `json:"permissions,omitempty"`
+	Directory   bool   `json:"directory,omitempty"`
+	Content     string `json:"content,omitempty"`
+}
+
+type printedPlan struct {
+	Files        []printedFile             `json:"files,omitempty"`
+	Instructions []applyinator.Instruction `json:"instructions,omitempty"`
+	Probes       map[string]prober.Probe   `json:"probes,omitempty"`
+}
+
+// Print writes the plan to w as JSON with the file contents decoded so the
+// rendered files can be reviewed. All occurrences of secrets are redacted.
+func Print(w io.Writer, plan *applyinator.Plan) error {
+	plan, err := Redact(plan)
+	if err != nil {
+		return err
+	}
+
+	out := printedPlan{
+		Instructions: plan.Instructions,
+		Probes:       plan.Probes,
+	}
+
+	for _, file := range plan.Files {
+		content, err := base64.StdEncoding.DecodeString(file.Content)
+		if err != nil {
+			return fmt.Errorf("decoding content of %s: %w", file.Path, err)
+		}
+		out.Files = append(out.Files, printedFile{
+			Path:        file.Path,
+			Permissions: file.Permissions,
+			Directory:   file.Directory,
+			Content:     string(content),
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
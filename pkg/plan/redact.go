@@ -0,0 +1,45 @@
+package plan
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/system-agent/pkg/applyinator"
+)
+
+// Redact returns a copy of the plan with all secrets known to config.Redact replaced
+// in file contents, instruction environment and arguments
+func Redact(plan *applyinator.Plan) (*applyinator.Plan, error) {
+	result := &applyinator.Plan{
+		Probes: plan.Probes,
+	}
+
+	for _, file := range plan.Files {
+		content, err := base64.StdEncoding.DecodeString(file.Content)
+		if err != nil {
+			return nil, fmt.Errorf("decoding content of %s: %w", file.Path, err)
+		}
+		file.Content = base64.StdEncoding.EncodeToString([]byte(config.Redact(string(content))))
+		result.Files = append(result.Files, file)
+	}
+
+	for _, inst := range plan.Instructions {
+		inst.Env = redactAll(inst.Env)
+		inst.Args = redactAll(inst.Args)
+		result.Instructions = append(result.Instructions, inst)
+	}
+
+	return result, nil
+}
+
+func redactAll(strs []string) []string {
+	if strs == nil {
+		return nil
+	}
+	result := make([]string, 0, len(strs))
+	for _, str := range strs {
+		result = append(result, config.Redact(str))
+	}
+	return result
+}
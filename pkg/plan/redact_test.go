@@ -0,0 +1,64 @@
+package plan
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/system-agent/pkg/applyinator"
+)
+
+func TestRedact(t *testing.T) {
+	config.AddSecrets("plan-token")
+
+	plan := &applyinator.Plan{
+		Files: []applyinator.File{
+			{
+				Path:    "/etc/rancher/k3s/config.yaml.d/40-rancherd.yaml",
+				Content: base64.StdEncoding.EncodeToString([]byte("token: plan-token\n")),
+			},
+		},
+		Instructions: []applyinator.Instruction{
+			{
+				Name: "join",
+				Env:  []string{"CATTLE_TOKEN=plan-token"},
+				Args: []string{"--token", "plan-token"},
+			},
+		},
+	}
+
+	dataDir := t.TempDir()
+	redacted, err := Redact(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writePlan(redacted, dataDir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(GetPlanFile(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "plan-token") || strings.Contains(string(data), base64.StdEncoding.EncodeToString([]byte("plan-token"))) {
+		t.Errorf("plan.json has the token:\n%s", data)
+	}
+
+	written := &applyinator.Plan{}
+	if err := json.Unmarshal(data, written); err != nil {
+		t.Fatal(err)
+	}
+	if env := written.Instructions[0].Env[0]; env != "CATTLE_TOKEN=--redacted--" {
+		t.Errorf("env = %q", env)
+	}
+	if arg := written.Instructions[0].Args[1]; arg != "--redacted--" {
+		t.Errorf("args = %q", written.Instructions[0].Args)
+	}
+
+	// the plan that is applied keeps the secrets
+	if plan.Instructions[0].Env[0] != "CATTLE_TOKEN=plan-token" {
+		t.Errorf("Redact changed the plan: %q", plan.Instructions[0].Env)
+	}
+}
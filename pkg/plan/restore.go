@@ -0,0 +1,47 @@
+package plan
+
+import (
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/etcdsnapshot"
+	"github.com/rancher/rancherd/pkg/imageverify"
+	"github.com/rancher/rancherd/pkg/probe"
+	"github.com/rancher/rancherd/pkg/rancher"
+	"github.com/rancher/rancherd/pkg/runtime"
+	"github.com/rancher/system-agent/pkg/applyinator"
+)
+
+// Restore returns the plan that resets the cluster to the etcd snapshot at path and
+// waits for Kubernetes and Rancher to be ready again
+func Restore(cfg *config.Config, k8sVersion, path, dataDir string) (*applyinator.Plan, error) {
+	p := plan{}
+
+	runtimeName := runtime.ForVersion(k8sVersion).Name()
+	instructions, err := etcdsnapshot.ToRestoreInstructions(runtimeName, path)
+	if err != nil {
+		return nil, err
+	}
+	p.Instructions = append(p.Instructions, instructions...)
+
+	if err := p.addInstruction(probe.ToInstruction(GetPlanFile(dataDir))); err != nil {
+		return nil, err
+	}
+	if err := p.addInstruction(rancher.ToWaitRancherInstruction("", cfg.SystemDefaultRegistry, k8sVersion)); err != nil {
+		return nil, err
+	}
+	if err := p.addInstruction(rancher.ToWaitRancherWebhookInstruction("", cfg.SystemDefaultRegistry, k8sVersion)); err != nil {
+		return nil, err
+	}
+	if err := p.addInstruction(runtime.ToWaitKubernetesInstruction("", cfg.SystemDefaultRegistry, k8sVersion)); err != nil {
+		return nil, err
+	}
+	p.Probes = probe.AllProbes(runtimeName)
+
+	if err := p.addFile(imageverify.ToFile(cfg, dataDir)); err != nil {
+		return nil, err
+	}
+	if err := p.addImageVerification(cfg, nil, k8sVersion, dataDir); err != nil {
+		return nil, err
+	}
+
+	return (*applyinator.Plan)(&p), nil
+}
@@ -5,12 +5,15 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
-	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/rancher/rancherd/pkg/bundle"
 	"github.com/rancher/rancherd/pkg/config"
-	"github.com/rancher/rancherd/pkg/registry"
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/runtime"
 	"github.com/rancher/rancherd/pkg/versions"
 	"github.com/rancher/system-agent/pkg/applyinator"
 	"github.com/rancher/system-agent/pkg/image"
@@ -26,15 +29,28 @@ func Run(ctx context.Context, cfg *config.Config, plan *applyinator.Plan, dataDi
 }
 
 func RunWithKubernetesVersion(ctx context.Context, k8sVersion string, plan *applyinator.Plan, dataDir string) error {
-	runtime := config.GetRuntime(k8sVersion)
+	if err := paths.RequireHost("applying a plan"); err != nil {
+		return err
+	}
 
-	if err := writePlan(plan, dataDir); err != nil {
+	runtimeName := runtime.ForVersion(k8sVersion).Name()
+
+	redactedPlan, err := Redact(plan)
+	if err != nil {
 		return err
 	}
 
-	images := image.NewUtility("", "", "", registry.GetConfigFile(runtime))
-	apply := applyinator.NewApplyinator(filepath.Join(dataDir, "plan", "work"), false,
-		filepath.Join(dataDir, "plan", "applied"), images)
+	if err := writePlan(redactedPlan, dataDir); err != nil {
+		return err
+	}
+
+	if err := recordFiles(plan, dataDir); err != nil {
+		return err
+	}
+
+	images := image.NewUtility(bundle.ImagesDir(), "", "", getRegistriesFile(runtimeName))
+	// The history of applied plans is not kept as it would store the plan with all secrets
+	apply := applyinator.NewApplyinator(filepath.Join(dataDir, "plan", "work"), false, "", images)
 
 	output, err := apply.Apply(ctx, applyinator.CalculatedPlan{
 		Plan: *plan,
@@ -58,7 +74,11 @@ func saveOutput(data []byte, dataDir string) error {
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(f, in)
+	output, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte(config.Redact(string(output))))
 	return err
 }
 
@@ -80,6 +100,50 @@ func writePlan(plan *applyinator.Plan, dataDir string) error {
 	return enc.Encode(plan)
 }
 
+// recordFiles adds the files of plan to the list of all files written by rancherd, which
+// is used to remove them on reset. plan.json only has the files of the last plan.
+func recordFiles(plan *applyinator.Plan, dataDir string) error {
+	files, err := GetWrittenFiles(dataDir)
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, file := range files {
+		seen[file] = true
+	}
+	for _, file := range plan.Files {
+		if !seen[file.Path] {
+			seen[file.Path] = true
+			files = append(files, file.Path)
+		}
+	}
+
+	return ioutil.WriteFile(getWrittenFilesPath(dataDir), []byte(strings.Join(files, "\n")+"\n"), 0600)
+}
+
+// GetWrittenFiles returns the paths of all files written by the plans applied by rancherd
+func GetWrittenFiles(dataDir string) ([]string, error) {
+	data, err := ioutil.ReadFile(getWrittenFilesPath(dataDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, file := range strings.Split(string(data), "\n") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func getWrittenFilesPath(dataDir string) string {
+	return filepath.Join(dataDir, "plan", "files")
+}
+
 func GetPlanFile(dataDir string) string {
 	return filepath.Join(dataDir, "plan", "plan.json")
 }
@@ -0,0 +1,218 @@
+package plan
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/registry"
+	"github.com/rancher/rancherd/pkg/resources"
+	"github.com/rancher/rancherd/pkg/runtime"
+	"github.com/rancher/rancherd/pkg/self"
+	"github.com/rancher/rancherd/pkg/tpm"
+	"github.com/rancher/system-agent/pkg/applyinator"
+	"github.com/rancher/wharfie/pkg/registries"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	sealedTokenName      = "token"
+	sealedRegistriesName = "registries"
+
+	// unsealedDir is on tmpfs, so the unsealed secrets do not survive a reboot. The
+	// runtime unseals them again before it starts, see toUnsealDropInFile.
+	unsealedDir            = "/run/rancherd/unsealed"
+	unsealedTokenFile      = "token"
+	unsealedRegistriesFile = "registries.yaml"
+	unsealedBootstrapFile  = "bootstrapmanifests.yaml"
+	unsealDropInName       = "10-rancherd-unseal.conf"
+	systemdSystemUnitDir   = "/etc/systemd/system"
+)
+
+// sealing returns true if the secrets of cfg are sealed to the TPM
+func sealing(cfg *config.Config) bool {
+	return cfg.TPM != nil && cfg.TPM.SealSecrets
+}
+
+func sealedStore(cfg *config.Config, dataDir string) (*tpm.SealedStore, error) {
+	if !sealing(cfg) {
+		return nil, nil
+	}
+	t, err := config.NewTPM(*cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &tpm.SealedStore{
+		TPM:  t,
+		Dir:  filepath.Join(dataDir, "sealed"),
+		PCRs: cfg.TPM.PCRs,
+	}, nil
+}
+
+// GetUnsealedDir returns the dir of the unsealed secrets
+func GetUnsealedDir() string {
+	return paths.Host(unsealedDir)
+}
+
+// getUnsealedFile returns the path of the unsealed secret called name as read by the runtime
+func getUnsealedFile(name string) string {
+	return filepath.Join(unsealedDir, name)
+}
+
+// SealSecrets seals the cluster token of cluster-init and the registries to the TPM if
+// tpm.sealSecrets is enabled, and unseals them to the tmpfs files the plan points the
+// runtime at. The token of the other roles is not needed by the runtime, they join
+// through Rancher. Nothing is done without tpm.sealSecrets.
+func SealSecrets(cfg *config.Config, dataDir string) error {
+	store, err := sealedStore(cfg, dataDir)
+	if err != nil || store == nil {
+		return err
+	}
+	return sealSecrets(cfg, store)
+}
+
+func sealSecrets(cfg *config.Config, store *tpm.SealedStore) error {
+	if cfg.Role == "cluster-init" {
+		if err := assignToken(cfg, store); err != nil {
+			return err
+		}
+		if err := setSealed(store, sealedTokenName, cfg.Token); err != nil {
+			return fmt.Errorf("sealing token: %w", err)
+		}
+	}
+
+	if cfg.Registries != nil {
+		data, err := json.Marshal(cfg.Registries)
+		if err != nil {
+			return err
+		}
+		if err := setSealed(store, sealedRegistriesName, string(data)); err != nil {
+			return fmt.Errorf("sealing registries: %w", err)
+		}
+	}
+
+	return unsealSecrets(store)
+}
+
+// setSealed seals value unless the same value is already sealed, so the sealed files
+// only change if the secret does
+func setSealed(store *tpm.SealedStore, name, value string) error {
+	existing, err := store.Get(name)
+	if err == nil && existing == value {
+		return nil
+	}
+	return store.Set(name, value)
+}
+
+// UnsealSecrets writes the secrets sealed by SealSecrets to the tmpfs files read by
+// the runtime. It is run by the runtime service before it starts.
+func UnsealSecrets(cfg *config.Config, dataDir string) error {
+	store, err := sealedStore(cfg, dataDir)
+	if err != nil || store == nil {
+		return err
+	}
+	return unsealSecrets(store)
+}
+
+func unsealSecrets(store *tpm.SealedStore) error {
+	token, err := store.Get(sealedTokenName)
+	if err != nil {
+		return err
+	}
+
+	var registriesData []byte
+	data, err := store.Get(sealedRegistriesName)
+	if err != nil {
+		return err
+	} else if data != "" {
+		registry := &registries.Registry{}
+		if err := json.Unmarshal([]byte(data), registry); err != nil {
+			return fmt.Errorf("unmarshalling sealed registries: %w", err)
+		}
+		registriesData, err = yaml.Marshal(registry)
+		if err != nil {
+			return err
+		}
+	}
+
+	dir := GetUnsealedDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("mkdir %s: %w", dir, err)
+	}
+	if token != "" {
+		if err := writeUnsealed(unsealedTokenFile, []byte(token)); err != nil {
+			return err
+		}
+	}
+	if registriesData != nil {
+		return writeUnsealed(unsealedRegistriesFile, registriesData)
+	}
+	return nil
+}
+
+func writeUnsealed(name string, data []byte) error {
+	path := paths.Host(getUnsealedFile(name))
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// sealedRuntimeConfig returns runtimeConfig with the token and registries replaced by
+// the unsealed files, so neither is written to the runtime config in plain text
+func sealedRuntimeConfig(cfg *config.Config) *config.RuntimeConfig {
+	result := cfg.RuntimeConfig
+	result.Token = ""
+	result.ConfigValues = map[string]interface{}{}
+	for k, v := range cfg.RuntimeConfig.ConfigValues {
+		result.ConfigValues[k] = v
+	}
+
+	if cfg.Role == "cluster-init" {
+		result.ConfigValues["token-file"] = getUnsealedFile(unsealedTokenFile)
+	}
+	if cfg.Registries != nil {
+		result.ConfigValues["private-registry"] = getUnsealedFile(unsealedRegistriesFile)
+	}
+	return &result
+}
+
+// getBootstrapManifests returns the path of the bootstrap manifests, which contain the
+// cluster token. When sealing they are kept on tmpfs.
+func getBootstrapManifests(cfg *config.Config, dataDir string) string {
+	if sealing(cfg) {
+		return paths.Host(getUnsealedFile(unsealedBootstrapFile))
+	}
+	return resources.GetBootstrapManifests(dataDir)
+}
+
+// getRegistriesFile returns the registries config used to pull the installer images,
+// the unsealed copy if the registries are sealed
+func getRegistriesFile(runtimeName config.Runtime) string {
+	unsealed := paths.Host(getUnsealedFile(unsealedRegistriesFile))
+	if _, err := os.Stat(unsealed); err == nil {
+		return unsealed
+	}
+	return registry.GetConfigFile(runtimeName)
+}
+
+// toUnsealDropInFile returns a systemd drop-in for the runtime service that unseals the
+// secrets before the runtime starts, as tmpfs is empty after a reboot
+func toUnsealDropInFile(runtimeName config.Runtime, dataDir string) (*applyinator.File, error) {
+	cmd, err := self.Self()
+	if err != nil {
+		return nil, fmt.Errorf("resolving location of %s: %w", os.Args[0], err)
+	}
+
+	content := fmt.Sprintf("[Service]\nExecStartPre=%s unseal-secrets --data-dir %s\n", cmd, dataDir)
+	return &applyinator.File{
+		Content:     base64.StdEncoding.EncodeToString([]byte(content)),
+		Path:        paths.Host(filepath.Join(systemdSystemUnitDir, runtime.Get(runtimeName).ServerService()+".service.d", unsealDropInName)),
+		Permissions: "0644",
+	}, nil
+}
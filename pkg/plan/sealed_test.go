@@ -0,0 +1,205 @@
+package plan
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/tpm"
+	"github.com/rancher/rancherd/pkg/tpm/tpmtest"
+	"github.com/rancher/wharfie/pkg/registries"
+	"sigs.k8s.io/yaml"
+)
+
+const testK8sVersion = "v1.24.10+k3s1"
+
+func testSealingConfig(role string) *config.Config {
+	return &config.Config{
+		KubernetesVersion: testK8sVersion,
+		RancherVersion:    "v2.7.1",
+		RuntimeConfig: config.RuntimeConfig{
+			Role: role,
+			ConfigValues: map[string]interface{}{
+				"node-ip": "10.0.0.5",
+			},
+		},
+		Registries: &registries.Registry{
+			Configs: map[string]registries.RegistryConfig{
+				"registry.example.com": {
+					Auth: &registries.AuthConfig{
+						Username: "user",
+						Password: "registry-password",
+					},
+				},
+			},
+		},
+		TPM: &config.TPMConfig{
+			SealSecrets: true,
+		},
+	}
+}
+
+func testStore(t *testing.T) *tpm.SealedStore {
+	return &tpm.SealedStore{
+		TPM: tpmtest.New(t, tpm.Options{}),
+		Dir: filepath.Join(t.TempDir(), "sealed"),
+	}
+}
+
+func readDir(t *testing.T, dir string) string {
+	t.Helper()
+	var result strings.Builder
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result.Write(data)
+	}
+	return result.String()
+}
+
+func TestSealSecrets(t *testing.T) {
+	t.Setenv(paths.RootEnv, t.TempDir())
+	store := testStore(t)
+
+	cfg := testSealingConfig("cluster-init")
+	if err := sealSecrets(cfg, store); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Token == "" {
+		t.Fatal("expected a generated token")
+	}
+
+	// only the sealed blobs are stored
+	sealed := readDir(t, store.Dir)
+	if strings.Contains(sealed, cfg.Token) || strings.Contains(sealed, "registry-password") {
+		t.Errorf("sealed dir contains a plain text secret")
+	}
+
+	token, err := ioutil.ReadFile(paths.Host(getUnsealedFile(unsealedTokenFile)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(token) != cfg.Token {
+		t.Errorf("unsealed token = %q, expected %q", token, cfg.Token)
+	}
+
+	registriesFile := paths.Host(getUnsealedFile(unsealedRegistriesFile))
+	data, err := ioutil.ReadFile(registriesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registry registries.Registry
+	if err := yaml.Unmarshal(data, &registry); err != nil {
+		t.Fatal(err)
+	}
+	if password := registry.Configs["registry.example.com"].Auth.Password; password != "registry-password" {
+		t.Errorf("unsealed registry password = %q", password)
+	}
+	if info, err := os.Stat(registriesFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("unsealed registries mode = %v, %v, expected 0600", info.Mode().Perm(), err)
+	}
+	if getRegistriesFile(config.RuntimeK3S) != registriesFile {
+		t.Errorf("installer images are not pulled with the unsealed registries")
+	}
+
+	// the next bootstrap, without the secrets in the config, uses the sealed token
+	if err := os.RemoveAll(GetUnsealedDir()); err != nil {
+		t.Fatal(err)
+	}
+	next := testSealingConfig("cluster-init")
+	next.Registries = nil
+	if err := sealSecrets(next, store); err != nil {
+		t.Fatal(err)
+	}
+	if next.Token != cfg.Token {
+		t.Errorf("token = %q, expected the sealed token %q", next.Token, cfg.Token)
+	}
+	if _, err := os.Stat(registriesFile); err != nil {
+		t.Errorf("sealed registries were not unsealed: %v", err)
+	}
+}
+
+func TestSealSecretsJoin(t *testing.T) {
+	t.Setenv(paths.RootEnv, t.TempDir())
+	store := testStore(t)
+
+	cfg := testSealingConfig("agent")
+	cfg.Token = "join-token"
+	if err := sealSecrets(cfg, store); err != nil {
+		t.Fatal(err)
+	}
+
+	// the join token is not needed by the runtime
+	if _, err := os.Stat(store.Path(sealedTokenName)); !os.IsNotExist(err) {
+		t.Errorf("expected the join token not to be sealed: %v", err)
+	}
+	if _, err := os.Stat(paths.Host(getUnsealedFile(unsealedRegistriesFile))); err != nil {
+		t.Errorf("registries of joining nodes are not sealed: %v", err)
+	}
+}
+
+func TestSealedFiles(t *testing.T) {
+	t.Setenv(paths.RootEnv, t.TempDir())
+
+	cfg := testSealingConfig("cluster-init")
+	cfg.Token = "cluster-token"
+
+	p := plan{}
+	if err := p.addFiles(cfg, "/var/lib/rancher/rancherd"); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{}
+	for _, file := range p.Files {
+		data, err := base64.StdEncoding.DecodeString(file.Content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(file.Path, "/run/") {
+			continue
+		}
+		if strings.Contains(string(data), "cluster-token") || strings.Contains(string(data), "registry-password") {
+			t.Errorf("%s contains a plain text secret", file.Path)
+		}
+		files[file.Path] = string(data)
+	}
+
+	runtimeConfig := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(files[paths.Host("/etc/rancher/k3s/config.yaml.d/40-rancherd.yaml")]), &runtimeConfig); err != nil {
+		t.Fatal(err)
+	}
+	if runtimeConfig["token-file"] != "/run/rancherd/unsealed/token" {
+		t.Errorf("token-file = %v", runtimeConfig["token-file"])
+	}
+	if runtimeConfig["private-registry"] != "/run/rancherd/unsealed/registries.yaml" {
+		t.Errorf("private-registry = %v", runtimeConfig["private-registry"])
+	}
+	if runtimeConfig["node-ip"] != "10.0.0.5" {
+		t.Errorf("node-ip = %v", runtimeConfig["node-ip"])
+	}
+
+	if _, ok := files[paths.Host("/etc/rancher/k3s/registries.yaml")]; ok {
+		t.Errorf("registries.yaml is written in plain text")
+	}
+
+	dropIn := files[paths.Host("/etc/systemd/system/k3s.service.d/10-rancherd-unseal.conf")]
+	if !strings.Contains(dropIn, "unseal-secrets --data-dir /var/lib/rancher/rancherd") {
+		t.Errorf("unseal drop-in = %q", dropIn)
+	}
+
+	// the config is not modified
+	if cfg.ConfigValues["token-file"] != nil {
+		t.Errorf("addFiles modified the config")
+	}
+}
@@ -1,26 +1,58 @@
 package plan
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 
 	"github.com/rancher/rancherd/pkg/config"
 	"github.com/rancher/rancherd/pkg/runtime"
+	"github.com/rancher/rancherd/pkg/tpm"
 	"github.com/rancher/rancherd/pkg/versions"
+	"github.com/rancher/wharfie/pkg/registries"
 	"github.com/rancher/wrangler/pkg/data/convert"
 	"github.com/rancher/wrangler/pkg/randomtoken"
 	"github.com/rancher/wrangler/pkg/yaml"
 )
 
-func assignTokenIfUnset(cfg *config.Config) error {
+func assignTokenIfUnset(cfg *config.Config, dataDir string) error {
 	if cfg.Token != "" {
 		return nil
 	}
 
-	token, err := existingToken(cfg)
+	store, err := sealedStore(cfg, dataDir)
 	if err != nil {
 		return err
 	}
+	return assignToken(cfg, store)
+}
+
+// assignToken sets the token to the sealed token, the token of an existing install or a
+// new random token if it is not set. Nothing is written, the token is sealed by SealSecrets.
+func assignToken(cfg *config.Config, store *tpm.SealedStore) error {
+	if cfg.Token != "" {
+		return nil
+	}
+
+	var (
+		token string
+		err   error
+	)
+
+	if store != nil {
+		token, err = store.Get(sealedTokenName)
+		if err != nil {
+			return err
+		}
+	}
+
+	if token == "" {
+		token, err = existingToken(cfg)
+		if err != nil {
+			return err
+		}
+	}
 
 	if token == "" {
 		token, err = randomtoken.Generate()
@@ -29,17 +61,44 @@ func assignTokenIfUnset(cfg *config.Config) error {
 		}
 	}
 
+	config.AddSecrets(token)
 	cfg.Token = token
 	return nil
 }
 
+// assignSealedRegistries restores the registry config from the sealed copy if it is not
+// set in the config
+func assignSealedRegistries(cfg *config.Config, dataDir string) error {
+	if cfg.Registries != nil {
+		return nil
+	}
+
+	store, err := sealedStore(cfg, dataDir)
+	if err != nil || store == nil {
+		return err
+	}
+
+	data, err := store.Get(sealedRegistriesName)
+	if err != nil || data == "" {
+		return err
+	}
+
+	registry := &registries.Registry{}
+	if err := json.Unmarshal([]byte(data), registry); err != nil {
+		return fmt.Errorf("unmarshalling sealed registries: %w", err)
+	}
+	cfg.Registries = registry
+	config.AddSecrets(cfg.Secrets()...)
+	return nil
+}
+
 func existingToken(cfg *config.Config) (string, error) {
 	k8sVersion, err := versions.K8sVersion(cfg.KubernetesVersion)
 	if err != nil {
 		return "", err
 	}
 
-	cfgFile := runtime.GetConfigLocation(config.GetRuntime(k8sVersion))
+	cfgFile := runtime.GetConfigLocation(runtime.ForVersion(k8sVersion).Name())
 	data, err := ioutil.ReadFile(cfgFile)
 	if os.IsNotExist(err) {
 		return "", nil
@@ -2,15 +2,28 @@ package plan
 
 import (
 	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/etcdsnapshot"
+	"github.com/rancher/rancherd/pkg/images"
+	"github.com/rancher/rancherd/pkg/imageverify"
 	"github.com/rancher/rancherd/pkg/os"
 	"github.com/rancher/rancherd/pkg/rancher"
 	"github.com/rancher/rancherd/pkg/runtime"
 	"github.com/rancher/system-agent/pkg/applyinator"
 )
 
-func Upgrade(cfg *config.Config, k8sVersion, rancherVersion, rancherOSVersion, dataDir string) (*applyinator.Plan, error) {
+// Upgrade returns the plan to upgrade to the given versions, empty versions are not
+// changed. If snapshotName is set the plan starts by saving an etcd snapshot and is
+// aborted if the snapshot fails. The installer images are verified like on bootstrap, the
+// pinned digests of the config are not used as they are for the configured versions.
+func Upgrade(cfg *config.Config, k8sVersion, rancherVersion, rancherOSVersion, snapshotName, dataDir string) (*applyinator.Plan, error) {
 	p := plan{}
 
+	if snapshotName != "" {
+		if err := p.addInstruction(etcdsnapshot.ToSaveInstruction(snapshotName)); err != nil {
+			return nil, err
+		}
+	}
+
 	if rancherVersion != "" {
 		if err := p.addInstruction(rancher.ToUpgradeInstruction("", cfg.SystemDefaultRegistry, k8sVersion, rancherVersion, dataDir)); err != nil {
 			return nil, err
@@ -30,10 +43,21 @@ func Upgrade(cfg *config.Config, k8sVersion, rancherVersion, rancherOSVersion, d
 	}
 
 	if rancherOSVersion != "" {
-		if err := p.addInstruction(os.ToUpgradeInstruction(k8sVersion, rancherOSVersion)); err != nil {
+		if err := p.addInstruction(os.ToUpgradeInstruction(k8sVersion, rancherOSVersion, cfg.NodeName)); err != nil {
 			return nil, err
 		}
 	}
 
+	if err := p.addFile(imageverify.ToFile(cfg, dataDir)); err != nil {
+		return nil, err
+	}
+
+	if err := p.addImageVerification(cfg, map[string]string{
+		runtime.InstallerImage("", cfg.SystemDefaultRegistry, k8sVersion):              "",
+		images.GetRancherInstallerImage("", cfg.SystemDefaultRegistry, rancherVersion): "",
+	}, k8sVersion, dataDir); err != nil {
+		return nil, err
+	}
+
 	return (*applyinator.Plan)(&p), nil
 }
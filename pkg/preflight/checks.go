@@ -0,0 +1,273 @@
+package preflight
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/roles"
+	"github.com/rancher/rancherd/pkg/runtime"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	rancherDir   = "/var/lib/rancher"
+	minDiskSpace = 5 << 30
+	maxClockSkew = time.Minute
+)
+
+var (
+	requiredControllers = []string{"cpu", "cpuset", "memory", "pids"}
+)
+
+func init() {
+	Register(existingRuntimeCheck{})
+	Register(portsCheck{})
+	Register(diskCheck{})
+	Register(iptablesCheck{})
+	Register(swapCheck{})
+	Register(cgroupCheck{})
+	Register(clockSkewCheck{})
+}
+
+// existingRuntimeCheck fails if the other Kubernetes runtime is already installed. Rancher
+// selects the runtime of joining nodes, so nothing is checked if the runtime is unknown.
+type existingRuntimeCheck struct{}
+
+func (existingRuntimeCheck) Name() string       { return "existing-runtime" }
+func (existingRuntimeCheck) Severity() Severity { return SeverityFail }
+
+func (existingRuntimeCheck) Run(ctx context.Context, params Params) error {
+	if runtime.Get(params.Runtime) == nil {
+		return nil
+	}
+	for _, installed := range runtime.All() {
+		if installed.Name() == params.Runtime {
+			continue
+		}
+		if file := firstExisting(installed.InstalledFiles()); file != "" {
+			return fmt.Errorf("%s is already installed (%s) but %s is configured", installed.Name(), file, params.Runtime)
+		}
+	}
+	return nil
+}
+
+// portsCheck fails if the ports used by the runtime and Rancher are in use. Only the ports
+// common to all runtimes are checked if the runtime is unknown.
+type portsCheck struct{}
+
+func (portsCheck) Name() string       { return "ports" }
+func (portsCheck) Severity() Severity { return SeverityFail }
+
+func (portsCheck) Run(ctx context.Context, params Params) error {
+	configured := runtime.Get(params.Runtime)
+	for _, installed := range runtime.All() {
+		if (configured == nil || installed == configured) && firstExisting(installed.InstalledFiles()) != "" {
+			// the ports are expected to be in use when bootstrap is run again
+			return nil
+		}
+	}
+
+	ports := []int{10250}
+	if roles.IsControlPlane(params.Config.Role) {
+		ports = append(ports, 6443, 8443)
+		if configured != nil {
+			ports = append(ports, configured.ServerPorts()...)
+		}
+	}
+
+	var inUse []string
+	for _, port := range ports {
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			inUse = append(inUse, fmt.Sprint(port))
+			continue
+		}
+		l.Close()
+	}
+
+	if len(inUse) > 0 {
+		return fmt.Errorf("ports %s are already in use", strings.Join(inUse, ", "))
+	}
+	return nil
+}
+
+// diskCheck fails if there is not enough free space for the runtime and images
+type diskCheck struct{}
+
+func (diskCheck) Name() string       { return "disk" }
+func (diskCheck) Severity() Severity { return SeverityFail }
+
+func (diskCheck) Run(ctx context.Context, params Params) error {
+	// /var/lib/rancher is usually created by the runtime, check the filesystem it will be on
+	dir := paths.Host(rancherDir)
+	for {
+		if _, err := os.Stat(dir); err == nil || dir == "/" {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return fmt.Errorf("checking free space of %s: %w", dir, err)
+	}
+
+	free := stat.Bavail * uint64(stat.Bsize)
+	if free < minDiskSpace {
+		return fmt.Errorf("only %d MiB free in %s, at least %d MiB are required", free>>20, dir, minDiskSpace>>20)
+	}
+	return nil
+}
+
+// iptablesCheck warns if iptables is not installed on the host
+type iptablesCheck struct{}
+
+func (iptablesCheck) Name() string       { return "iptables" }
+func (iptablesCheck) Severity() Severity { return SeverityWarn }
+
+func (iptablesCheck) Run(ctx context.Context, params Params) error {
+	if _, err := exec.LookPath("iptables"); err != nil {
+		return fmt.Errorf("iptables is not installed")
+	}
+	return nil
+}
+
+// swapCheck warns if swap is enabled, which the kubelet does not support
+type swapCheck struct{}
+
+func (swapCheck) Name() string       { return "swap" }
+func (swapCheck) Severity() Severity { return SeverityWarn }
+
+func (swapCheck) Run(ctx context.Context, params Params) error {
+	f, err := os.Open("/proc/swaps")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var devices []string
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		// Filename Type Size Used Priority
+		fields := strings.Fields(scan.Text())
+		if len(fields) > 0 && fields[0] != "Filename" {
+			devices = append(devices, fields[0])
+		}
+	}
+
+	if len(devices) > 0 {
+		return fmt.Errorf("swap is enabled on %s", strings.Join(devices, ", "))
+	}
+	return nil
+}
+
+// cgroupCheck fails if the cgroup controllers required by the kubelet are not available
+type cgroupCheck struct{}
+
+func (cgroupCheck) Name() string       { return "cgroup" }
+func (cgroupCheck) Severity() Severity { return SeverityFail }
+
+func (cgroupCheck) Run(ctx context.Context, params Params) error {
+	available := map[string]bool{}
+
+	if data, err := ioutil.ReadFile("/sys/fs/cgroup/cgroup.controllers"); err == nil {
+		// cgroup v2
+		for _, controller := range strings.Fields(string(data)) {
+			available[controller] = true
+		}
+	} else {
+		// cgroup v1, every controller is mounted in its own hierarchy
+		for _, controller := range requiredControllers {
+			if _, err := os.Stat(filepath.Join("/sys/fs/cgroup", controller)); err == nil {
+				available[controller] = true
+			}
+		}
+	}
+
+	var missing []string
+	for _, controller := range requiredControllers {
+		if !available[controller] {
+			missing = append(missing, controller)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("cgroup controllers %s are not available", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// clockSkewCheck fails if the clock of a joining node differs too much from the server
+type clockSkewCheck struct{}
+
+func (clockSkewCheck) Name() string       { return "clock-skew" }
+func (clockSkewCheck) Severity() Severity { return SeverityFail }
+
+func (clockSkewCheck) Run(ctx context.Context, params Params) error {
+	if params.Config.Server == "" {
+		return nil
+	}
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				// only the Date header is used
+				InsecureSkipVerify: true,
+			},
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, params.Config.Server, nil)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		// joining will wait for the server anyway
+		logrus.Debugf("skipping clock skew check, failed to contact %s: %v", params.Config.Server, err)
+		return nil
+	}
+	resp.Body.Close()
+
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("%s did not return its time: %w", params.Config.Server, err)
+	}
+
+	localTime := start.Add(time.Since(start) / 2)
+	skew := localTime.Sub(serverTime)
+	if skew < 0 {
+		skew = -skew
+	}
+	// the Date header has a resolution of one second
+	if skew > maxClockSkew+time.Second {
+		return fmt.Errorf("clock differs by %s from %s", skew.Round(time.Second), params.Config.Server)
+	}
+	return nil
+}
+
+func firstExisting(files []string) string {
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+	return ""
+}
@@ -0,0 +1,119 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/sirupsen/logrus"
+)
+
+type Severity string
+
+var (
+	// SeverityFail aborts bootstrap if the check fails
+	SeverityFail Severity = "fail"
+	// SeverityWarn logs a warning if the check fails
+	SeverityWarn Severity = "warn"
+	// SeverityIgnore skips the check
+	SeverityIgnore Severity = "ignore"
+)
+
+// Params describe the node that is about to be bootstrapped
+type Params struct {
+	Config *config.Config
+	// Runtime is the runtime that bootstrap installs, config.RuntimeUnknown if Rancher
+	// selects it
+	Runtime config.Runtime
+}
+
+// Check verifies one requirement of the host before bootstrap
+type Check interface {
+	// Name identifies the check in the output and in the preflight.severity config
+	Name() string
+	// Severity is the severity of a failed check unless overridden in the config
+	Severity() Severity
+	// Run returns an error describing why the host does not meet the requirement
+	Run(ctx context.Context, params Params) error
+}
+
+// Result is the outcome of a single check
+type Result struct {
+	Name     string
+	Severity Severity
+	Err      error
+}
+
+var checks []Check
+
+// Register adds a check that is run by Run
+func Register(check Check) {
+	checks = append(checks, check)
+}
+
+// Checks returns all registered checks
+func Checks() []Check {
+	return checks
+}
+
+// Run runs all checks and logs the failed ones. An error is returned if a check
+// with severity fail did not pass.
+func Run(ctx context.Context, params Params) ([]Result, error) {
+	if params.Config.Preflight != nil && params.Config.Preflight.Skip {
+		logrus.Infof("Skipping pre-flight checks")
+		return nil, nil
+	}
+
+	var (
+		results []Result
+		failed  []string
+	)
+
+	for _, check := range checks {
+		severity, err := severity(params.Config, check)
+		if err != nil {
+			return nil, err
+		}
+		if severity == SeverityIgnore {
+			continue
+		}
+
+		result := Result{
+			Name:     check.Name(),
+			Severity: severity,
+			Err:      check.Run(ctx, params),
+		}
+		results = append(results, result)
+
+		switch {
+		case result.Err == nil:
+			logrus.Debugf("Pre-flight check %s passed", result.Name)
+		case severity == SeverityFail:
+			logrus.Errorf("Pre-flight check %s failed: %v", result.Name, result.Err)
+			failed = append(failed, result.Name)
+		default:
+			logrus.Warnf("Pre-flight check %s failed: %v", result.Name, result.Err)
+		}
+	}
+
+	if len(failed) > 0 {
+		return results, fmt.Errorf("pre-flight checks failed: %s, set preflight.severity.<check>: warn to continue anyway",
+			strings.Join(failed, ", "))
+	}
+	return results, nil
+}
+
+func severity(cfg *config.Config, check Check) (Severity, error) {
+	if cfg.Preflight == nil || cfg.Preflight.Severity[check.Name()] == "" {
+		return check.Severity(), nil
+	}
+
+	switch severity := Severity(cfg.Preflight.Severity[check.Name()]); severity {
+	case SeverityFail, SeverityWarn, SeverityIgnore:
+		return severity, nil
+	default:
+		return "", fmt.Errorf("invalid severity %q for pre-flight check %s, must be %s, %s or %s",
+			severity, check.Name(), SeverityFail, SeverityWarn, SeverityIgnore)
+	}
+}
@@ -0,0 +1,133 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/paths"
+)
+
+type fakeCheck struct {
+	severity Severity
+	err      error
+}
+
+func (fakeCheck) Name() string                                   { return "fake" }
+func (f fakeCheck) Severity() Severity                           { return f.severity }
+func (f fakeCheck) Run(ctx context.Context, params Params) error { return f.err }
+
+func TestSeverityOverride(t *testing.T) {
+	registered := checks
+	defer func() { checks = registered }()
+	checks = []Check{fakeCheck{severity: SeverityFail, err: errors.New("failed")}}
+
+	tests := []struct {
+		name     string
+		severity string
+		results  int
+		err      string
+	}{
+		{name: "default", results: 1, err: "pre-flight checks failed: fake"},
+		{name: "warn", severity: "warn", results: 1},
+		{name: "ignore", severity: "ignore"},
+		{name: "fail", severity: "fail", results: 1, err: "pre-flight checks failed: fake"},
+		{name: "invalid", severity: "error", err: `invalid severity "error" for pre-flight check fake`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			if test.severity != "" {
+				cfg.Preflight = &config.PreflightConfig{
+					Severity: map[string]string{"fake": test.severity},
+				}
+			}
+
+			results, err := Run(context.Background(), Params{Config: cfg})
+			if test.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+			if len(results) != test.results {
+				t.Fatalf("expected %d results, got %v", test.results, results)
+			}
+			if test.severity != "" && len(results) > 0 && string(results[0].Severity) != test.severity {
+				t.Errorf("expected severity %s, got %s", test.severity, results[0].Severity)
+			}
+		})
+	}
+}
+
+func TestSkip(t *testing.T) {
+	registered := checks
+	defer func() { checks = registered }()
+	checks = []Check{fakeCheck{severity: SeverityFail, err: errors.New("failed")}}
+
+	results, err := Run(context.Background(), Params{
+		Config: &config.Config{Preflight: &config.PreflightConfig{Skip: true}},
+	})
+	if err != nil || len(results) != 0 {
+		t.Fatalf("expected the checks to be skipped, got %v, %v", results, err)
+	}
+}
+
+func TestRuntimeChecks(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv(paths.RootEnv, root)
+
+	// rke2 is installed, e.g. a node that joined an rke2 cluster re-running bootstrap
+	rke2 := filepath.Join(root, "/usr/local/bin/rke2")
+	if err := os.MkdirAll(filepath.Dir(rke2), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(rke2, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		role    string
+		runtime config.Runtime
+		err     string
+	}{
+		{name: "join", role: "agent", runtime: config.RuntimeUnknown},
+		{name: "join control plane", role: "server", runtime: config.RuntimeUnknown},
+		{name: "cluster-init same runtime", role: "cluster-init", runtime: config.RuntimeRKE2},
+		{name: "cluster-init other runtime", role: "cluster-init", runtime: config.RuntimeK3S,
+			err: "rke2 is already installed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Role = test.role
+			params := Params{
+				Config:  cfg,
+				Runtime: test.runtime,
+			}
+
+			err := existingRuntimeCheck{}.Run(context.Background(), params)
+			if test.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+
+			if test.err == "" {
+				// the ports of the installed runtime are in use when bootstrap is run again
+				if err := (portsCheck{}).Run(context.Background(), params); err != nil {
+					t.Errorf("unexpected error of ports check: %v", err)
+				}
+			}
+		})
+	}
+}
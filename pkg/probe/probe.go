@@ -3,97 +3,41 @@ package probe
 import (
 	"fmt"
 	"os"
-	"strings"
 
 	"github.com/rancher/rancherd/pkg/config"
 	"github.com/rancher/rancherd/pkg/roles"
+	"github.com/rancher/rancherd/pkg/runtime"
 	"github.com/rancher/rancherd/pkg/self"
 	"github.com/rancher/system-agent/pkg/applyinator"
 	"github.com/rancher/system-agent/pkg/prober"
 )
 
-var probes = map[string]prober.Probe{
-	"kube-apiserver": {
-		InitialDelaySeconds: 1,
-		TimeoutSeconds:      5,
-		SuccessThreshold:    1,
-		FailureThreshold:    2,
-		HTTPGetAction: prober.HTTPGetAction{
-			URL:        "https://127.0.0.1:6443/readyz",
-			CACert:     "/var/lib/rancher/%s/server/tls/server-ca.crt",
-			ClientCert: "/var/lib/rancher/%s/server/tls/client-kube-apiserver.crt",
-			ClientKey:  "/var/lib/rancher/%s/server/tls/client-kube-apiserver.key",
-		},
-	},
-	"kube-scheduler": {
-		InitialDelaySeconds: 1,
-		TimeoutSeconds:      5,
-		SuccessThreshold:    1,
-		FailureThreshold:    2,
-		HTTPGetAction: prober.HTTPGetAction{
-			URL:      "https://127.0.0.1:10259/healthz",
-			Insecure: true,
-		},
-	},
-	"kube-controller-manager": {
-		InitialDelaySeconds: 1,
-		TimeoutSeconds:      5,
-		SuccessThreshold:    1,
-		FailureThreshold:    2,
-		HTTPGetAction: prober.HTTPGetAction{
-			URL:      "https://127.0.0.1:10257/healthz",
-			Insecure: true,
-		},
-	},
-	"kubelet": {
-		InitialDelaySeconds: 1,
-		TimeoutSeconds:      5,
-		SuccessThreshold:    1,
-		FailureThreshold:    2,
-		HTTPGetAction: prober.HTTPGetAction{
-			URL: "http://127.0.0.1:10248/healthz",
-		},
-	},
+// ProbesForJoin returns the probes of a node joining with the role of cfg. The runtime is
+// not known until the join script installed it, the probes that need the files of the
+// runtime are added to the plan file when the probes of ToJoinInstruction run.
+func ProbesForJoin(cfg *config.RuntimeConfig) map[string]prober.Probe {
+	return ProbesForRole(nil, cfg.Role)
 }
 
-func replaceRuntime(str string, runtime config.Runtime) string {
-	if !strings.Contains(str, "%s") {
-		return str
+// ProbesForRole returns the probes of a node of the runtime with role, see runtime.Probes
+func ProbesForRole(rt runtime.Runtime, role string) map[string]prober.Probe {
+	probes := runtime.Probes(rt)
+	if roles.IsControlPlane(role) {
+		return probes
 	}
-	return fmt.Sprintf(str, runtime)
-}
-
-func ProbesForJoin(cfg *config.RuntimeConfig) map[string]prober.Probe {
-	if roles.IsControlPlane(cfg.Role) {
-		return AllProbes(config.RuntimeUnknown)
+	return map[string]prober.Probe{
+		runtime.KubeletProbe: probes[runtime.KubeletProbe],
 	}
-	return replaceRuntimeForProbes(map[string]prober.Probe{
-		"kubelet": probes["kubelet"],
-	}, config.RuntimeUnknown)
 }
 
-func AllProbes(runtime config.Runtime) map[string]prober.Probe {
-	return replaceRuntimeForProbes(probes, runtime)
+// AllProbes returns the probes of a server of the runtime, the probes that need the files
+// of the runtime are left out if the runtime is unknown
+func AllProbes(name config.Runtime) map[string]prober.Probe {
+	return runtime.Probes(runtime.Get(name))
 }
 
-func replaceRuntimeForProbes(probes map[string]prober.Probe, runtime config.Runtime) map[string]prober.Probe {
-	result := map[string]prober.Probe{}
-	for k, v := range probes {
-		// we don't know the runtime to find the file
-		if runtime == config.RuntimeUnknown && (v.HTTPGetAction.CACert+
-			v.HTTPGetAction.ClientCert+
-			v.HTTPGetAction.ClientKey) != "" {
-			continue
-		}
-		v.HTTPGetAction.CACert = replaceRuntime(v.HTTPGetAction.CACert, runtime)
-		v.HTTPGetAction.ClientCert = replaceRuntime(v.HTTPGetAction.ClientCert, runtime)
-		v.HTTPGetAction.ClientKey = replaceRuntime(v.HTTPGetAction.ClientKey, runtime)
-		result[k] = v
-	}
-	return result
-}
-
-func ToInstruction() (*applyinator.Instruction, error) {
+// ToInstruction returns the instruction running the probes of the plan in planFile
+func ToInstruction(planFile string) (*applyinator.Instruction, error) {
 	cmd, err := self.Self()
 	if err != nil {
 		return nil, fmt.Errorf("resolving location of %s: %w", os.Args[0], err)
@@ -101,7 +45,20 @@ func ToInstruction() (*applyinator.Instruction, error) {
 	return &applyinator.Instruction{
 		Name:       "probes",
 		SaveOutput: true,
-		Args:       []string{"probe"},
+		Args:       []string{"probe", "--file", planFile},
 		Command:    cmd,
 	}, nil
 }
+
+// ToJoinInstruction returns the instruction running the probes of a node joining with
+// role. The instruction waits for the runtime to be installed and runs the probes of role
+// for the detected runtime instead of the probes of the plan in planFile, which are
+// replaced with them.
+func ToJoinInstruction(planFile, role string) (*applyinator.Instruction, error) {
+	inst, err := ToInstruction(planFile)
+	if err != nil {
+		return nil, err
+	}
+	inst.Args = append(inst.Args, "--role", role)
+	return inst, nil
+}
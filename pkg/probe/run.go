@@ -3,16 +3,22 @@ package probe
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"time"
 
+	"github.com/rancher/rancherd/pkg/runtime"
 	"github.com/rancher/system-agent/pkg/applyinator"
 	"github.com/rancher/system-agent/pkg/prober"
 	"github.com/sirupsen/logrus"
 )
 
-func RunProbes(ctx context.Context, planFile string, interval time.Duration) error {
+// RunProbes runs the probes of the plan in planFile until all are healthy. If role is set
+// the probes of a node with role for the installed runtime are run instead, after waiting
+// for a runtime to be installed, and replace the probes of the plan in planFile.
+func RunProbes(ctx context.Context, planFile string, interval time.Duration, role string) error {
 	f, err := os.Open(planFile)
 	if err != nil {
 		return fmt.Errorf("opening plan %s: %w", planFile, err)
@@ -24,6 +30,12 @@ func RunProbes(ctx context.Context, planFile string, interval time.Duration) err
 		return err
 	}
 
+	if role != "" {
+		if err := setProbesForRole(ctx, planFile, plan, interval, role); err != nil {
+			return err
+		}
+	}
+
 	if len(plan.Probes) == 0 {
 		logrus.Infof("No probes defined in %s", planFile)
 		return nil
@@ -67,3 +79,47 @@ func RunProbes(ctx context.Context, planFile string, interval time.Duration) err
 
 	return nil
 }
+
+// setProbesForRole waits for the runtime to be installed and sets the probes of a node with
+// role for the installed runtime in plan. The plan is written back to planFile, so later
+// runs of the probes of the plan, without role, run the probes of the installed runtime too.
+func setProbesForRole(ctx context.Context, planFile string, plan *applyinator.Plan, interval time.Duration, role string) error {
+	installed, err := waitForRuntime(ctx, interval)
+	if err != nil {
+		return err
+	}
+	plan.Probes = ProbesForRole(installed, role)
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := planFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, planFile)
+}
+
+func waitForRuntime(ctx context.Context, interval time.Duration) (runtime.Runtime, error) {
+	logged := false
+	for {
+		installed, err := runtime.Detect()
+		if err == nil {
+			logrus.Infof("Detected installed runtime %s", installed.Name())
+			return installed, nil
+		} else if !errors.Is(err, runtime.ErrNotInstalled) {
+			return nil, err
+		}
+
+		if !logged {
+			logrus.Info("Waiting for the runtime to be installed")
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/runtime"
+	"github.com/rancher/system-agent/pkg/applyinator"
+)
+
+func TestSetProbesForRole(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv(paths.RootEnv, root)
+
+	k3s := runtime.Get(config.RuntimeK3S)
+	binary := k3s.InstalledFiles()[0]
+	if err := os.MkdirAll(filepath.Dir(binary), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(binary, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	planFile := filepath.Join(root, "plan.json")
+	plan := &applyinator.Plan{
+		Probes: ProbesForJoin(&config.RuntimeConfig{Role: "server"}),
+	}
+	if _, ok := plan.Probes[runtime.APIServerProbe]; ok {
+		t.Fatalf("join plan has the %s probe before the runtime is known", runtime.APIServerProbe)
+	}
+
+	if err := setProbesForRole(context.Background(), planFile, plan, time.Millisecond, "server"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(planFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := &applyinator.Plan{}
+	if err := json.Unmarshal(data, written); err != nil {
+		t.Fatal(err)
+	}
+	probe, ok := written.Probes[runtime.APIServerProbe]
+	if !ok {
+		t.Fatalf("plan file does not have the %s probe: %v", runtime.APIServerProbe, written.Probes)
+	}
+	if probe.HTTPGetAction.ClientCert != k3s.APIServerTLS().ClientCert {
+		t.Errorf("%s probe uses client certificate %q", runtime.APIServerProbe, probe.HTTPGetAction.ClientCert)
+	}
+}
@@ -0,0 +1,31 @@
+package rancher
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+const machineGroup = "cluster.x-k8s.io"
+
+// MachineGVR returns the resource of the cluster API machines in the preferred version
+// served by the cluster. The version depends on the Rancher release, v1alpha4 for
+// Rancher 2.6 and v1beta1 later.
+func MachineGVR(client discovery.DiscoveryInterface) (schema.GroupVersionResource, error) {
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return schema.GroupVersionResource{}, fmt.Errorf("discovering API groups: %w", err)
+	}
+
+	for _, group := range groups.Groups {
+		if group.Name == machineGroup && group.PreferredVersion.Version != "" {
+			return schema.GroupVersionResource{
+				Group:    machineGroup,
+				Version:  group.PreferredVersion.Version,
+				Resource: "machines",
+			}, nil
+		}
+	}
+	return schema.GroupVersionResource{}, fmt.Errorf("%s machines are not served, Rancher does not manage the local cluster", machineGroup)
+}
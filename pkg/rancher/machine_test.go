@@ -0,0 +1,39 @@
+package rancher
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMachineGVR(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	discovery := client.Discovery()
+
+	if _, err := MachineGVR(discovery); err == nil {
+		t.Errorf("expected an error without cluster API machines")
+	}
+
+	for _, version := range []string{"v1alpha4", "v1beta1"} {
+		client.Resources = []*metav1.APIResourceList{
+			{
+				GroupVersion: "cluster.x-k8s.io/" + version,
+				APIResources: []metav1.APIResource{
+					{
+						Name:       "machines",
+						Namespaced: true,
+						Kind:       "Machine",
+					},
+				},
+			},
+		}
+		gvr, err := MachineGVR(discovery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gvr.Group != "cluster.x-k8s.io" || gvr.Version != version || gvr.Resource != "machines" {
+			t.Errorf("GVR = %v, expected cluster.x-k8s.io/%s machines", gvr, version)
+		}
+	}
+}
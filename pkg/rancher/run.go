@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"fmt"
 
+	"github.com/rancher/rancherd/pkg/bundle"
 	"github.com/rancher/rancherd/pkg/config"
 	"github.com/rancher/rancherd/pkg/images"
 	"github.com/rancher/rancherd/pkg/kubectl"
@@ -44,12 +45,18 @@ func ToFile(cfg *config.Config, dataDir string) (*applyinator.File, error) {
 	}, nil
 }
 
+// ToInstruction installs Rancher with the installer image. If a loaded bundle has the
+// chart of rancherVersion the installer installs the bundled chart.
 func ToInstruction(imageOverride, systemDefaultRegistry, k8sVersion, rancherVersion, dataDir string) (*applyinator.Instruction, error) {
+	env := append(kubectl.Env(k8sVersion), fmt.Sprintf("RANCHER_VALUES=%s", GetRancherValues(dataDir)))
+	if chart := bundle.Chart(dataDir, rancherVersion); chart != "" {
+		env = append(env, fmt.Sprintf("RANCHER_CHART=%s", chart))
+	}
 	return &applyinator.Instruction{
 		Name:       "rancher",
 		SaveOutput: true,
 		Image:      images.GetRancherInstallerImage(imageOverride, systemDefaultRegistry, rancherVersion),
-		Env:        append(kubectl.Env(k8sVersion), fmt.Sprintf("RANCHER_VALUES=%s", GetRancherValues(dataDir))),
+		Env:        env,
 	}, nil
 }
 
@@ -0,0 +1,90 @@
+package rancherd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/rancher/rancherd/pkg/bundle"
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/registry"
+	"github.com/rancher/rancherd/pkg/runtime"
+	"github.com/rancher/rancherd/pkg/versions"
+	"github.com/sirupsen/logrus"
+)
+
+type BundleConfig struct {
+	// KubernetesVersion and RancherVersion override the versions of the config
+	KubernetesVersion string
+	RancherVersion    string
+	// Images are additional images to bundle
+	Images    []string
+	ChartRepo string
+	// Output is the path of the bundle
+	Output string
+}
+
+// BundleCreate writes a bundle with the images of the plans of the config, to bootstrap
+// nodes without access to a registry
+func (r *Rancherd) BundleCreate(ctx context.Context, bundleConfig BundleConfig) error {
+	cfg, err := config.LoadLocal(r.cfg.ConfigPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	if bundleConfig.KubernetesVersion == "" {
+		bundleConfig.KubernetesVersion = cfg.KubernetesVersion
+	}
+	if bundleConfig.RancherVersion == "" {
+		bundleConfig.RancherVersion = cfg.RancherVersion
+	}
+
+	k8sVersion, err := versions.K8sVersion(bundleConfig.KubernetesVersion)
+	if err != nil {
+		return err
+	}
+
+	rancherVersion, err := versions.RancherVersion(bundleConfig.RancherVersion)
+	if err != nil {
+		return err
+	}
+
+	channels := bundle.Channels{}
+	if bundleConfig.KubernetesVersion != k8sVersion {
+		channels.Kubernetes = map[string]string{bundleConfig.KubernetesVersion: k8sVersion}
+	}
+	if bundleConfig.RancherVersion != rancherVersion {
+		channels.Rancher = map[string]string{bundleConfig.RancherVersion: rancherVersion}
+	}
+
+	manifest, err := bundle.Create(ctx, bundle.CreateOptions{
+		Config:            &cfg,
+		KubernetesVersion: k8sVersion,
+		RancherVersion:    rancherVersion,
+		Channels:          channels,
+		Images:            bundleConfig.Images,
+		ChartRepo:         bundleConfig.ChartRepo,
+		RegistriesFile:    registry.GetConfigFile(runtime.ForVersion(k8sVersion).Name()),
+		Output:            bundleConfig.Output,
+		NodePath:          filepath.Join(DefaultDataDir, filepath.Base(bundleConfig.Output)),
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Successfully created bundle %s with %d images for Rancher (%s/%s)", bundleConfig.Output,
+		len(manifest.Images), manifest.RancherVersion, manifest.KubernetesVersion)
+	return nil
+}
+
+// BundleLoad loads the images of a bundle, so plans use them instead of pulling them
+func (r *Rancherd) BundleLoad(ctx context.Context, path string) error {
+	manifest, err := bundle.Load(path, r.cfg.DataDir)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Successfully loaded bundle %s with %d images for Rancher (%s/%s)", path,
+		len(manifest.Images), manifest.RancherVersion, manifest.KubernetesVersion)
+	return nil
+}
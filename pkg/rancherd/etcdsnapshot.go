@@ -0,0 +1,158 @@
+package rancherd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/etcdsnapshot"
+	"github.com/rancher/rancherd/pkg/kubectl"
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/plan"
+	"github.com/rancher/rancherd/pkg/runtime"
+	"github.com/rancher/rancherd/pkg/versions"
+)
+
+type EtcdSnapshotConfig struct {
+	// Name of the snapshot to save or restore
+	Name string
+	// Dir overrides the snapshot dir of the config
+	Dir string
+}
+
+// EtcdSnapshotSave saves an etcd snapshot recording the current versions of the cluster
+func (r *Rancherd) EtcdSnapshotSave(ctx context.Context, snapshotConfig EtcdSnapshotConfig) error {
+	if err := paths.RequireHost("etcd-snapshot save"); err != nil {
+		return err
+	}
+	cfg, err := r.loadConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	rancherVersion, k8sVersion, rancherOSVersion := r.getExistingVersions(ctx)
+	runtime, err := r.installedRuntime(k8sVersion)
+	if err != nil {
+		return err
+	}
+
+	_, err = etcdsnapshot.Save(ctx, etcdsnapshot.SaveOptions{
+		Runtime:           runtime,
+		Dir:               snapshotDir(&cfg, snapshotConfig.Dir),
+		Name:              snapshotConfig.Name,
+		NodeName:          r.nodeName(),
+		KubernetesVersion: k8sVersion,
+		RancherVersion:    rancherVersion,
+		RancherOSVersion:  rancherOSVersion,
+	})
+	return err
+}
+
+// EtcdSnapshotList prints the etcd snapshots and the versions they were taken with
+func (r *Rancherd) EtcdSnapshotList(ctx context.Context, snapshotConfig EtcdSnapshotConfig) error {
+	cfg, err := r.loadConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	runtime, err := r.installedRuntime("")
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := etcdsnapshot.List(runtime, snapshotDir(&cfg, snapshotConfig.Dir))
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tCREATED\tKUBERNETES\tRANCHER\tRANCHEROS\n")
+	for _, snapshot := range snapshots {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", snapshot.Name, snapshot.Created.Local().Format(time.RFC3339),
+			orNone(snapshot.KubernetesVersion), orNone(snapshot.RancherVersion), orNone(snapshot.RancherOSVersion))
+	}
+	return w.Flush()
+}
+
+// EtcdSnapshotRestore resets the cluster to an etcd snapshot and waits for Kubernetes and
+// Rancher to be ready again
+func (r *Rancherd) EtcdSnapshotRestore(ctx context.Context, snapshotConfig EtcdSnapshotConfig) error {
+	if err := paths.RequireHost("etcd-snapshot restore"); err != nil {
+		return err
+	}
+	cfg, err := r.loadConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, existingK8sVersion, _ := r.getExistingVersions(ctx)
+	installed, err := r.installedRuntime(existingK8sVersion)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := etcdsnapshot.Get(installed, snapshotDir(&cfg, snapshotConfig.Dir), snapshotConfig.Name)
+	if err != nil {
+		return err
+	}
+
+	k8sVersion := snapshot.KubernetesVersion
+	if k8sVersion == "" {
+		k8sVersion = existingK8sVersion
+	}
+	if k8sVersion == "" {
+		if k8sVersion, err = versions.K8sVersion(cfg.KubernetesVersion); err != nil {
+			return err
+		}
+	}
+	if runtime.ForVersion(k8sVersion).Name() != installed {
+		return fmt.Errorf("etcd snapshot %s was taken with %s but %s is installed", snapshot.Name, k8sVersion, installed)
+	}
+
+	fmt.Printf("\nRestoring etcd snapshot:\n\n")
+	fmt.Printf("    Snapshot:   %s\n", snapshot.Path)
+	fmt.Printf("    Created:    %s\n", snapshot.Created.Local().Format(time.RFC3339))
+	fmt.Printf("    Kubernetes: %s\n", orNone(snapshot.KubernetesVersion))
+	fmt.Printf("    Rancher:    %s\n", orNone(snapshot.RancherVersion))
+	if existingK8sVersion != "" && snapshot.KubernetesVersion != "" && existingK8sVersion != snapshot.KubernetesVersion {
+		fmt.Printf("\nWARNING: Kubernetes %s is installed, the snapshot is from %s\n", existingK8sVersion, snapshot.KubernetesVersion)
+	}
+
+	if err := r.confirm(ctx); err != nil {
+		return err
+	}
+
+	nodePlan, err := plan.Restore(&cfg, k8sVersion, snapshot.Path, r.cfg.DataDir)
+	if err != nil {
+		return err
+	}
+
+	return plan.RunWithKubernetesVersion(ctx, k8sVersion, nodePlan, r.cfg.DataDir)
+}
+
+// installedRuntime returns the runtime installed on this node or, if none is found, the
+// runtime of k8sVersion
+func (r *Rancherd) installedRuntime(k8sVersion string) (config.Runtime, error) {
+	installed, err := kubectl.InstalledRuntime()
+	if err != nil && k8sVersion != "" {
+		return runtime.ForVersion(k8sVersion).Name(), nil
+	}
+	return installed, err
+}
+
+func snapshotDir(cfg *config.Config, dir string) string {
+	if dir == "" && cfg.EtcdSnapshot != nil {
+		return cfg.EtcdSnapshot.Dir
+	}
+	return dir
+}
+
+func orNone(str string) string {
+	if str == "" {
+		return "<none>"
+	}
+	return str
+}
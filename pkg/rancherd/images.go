@@ -0,0 +1,82 @@
+package rancherd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/images"
+	"github.com/rancher/rancherd/pkg/runtime"
+	"github.com/rancher/rancherd/pkg/versions"
+)
+
+type ImagesConfig struct {
+	// KubernetesVersion, RancherVersion and RancherOSVersion override the versions of the config
+	KubernetesVersion string
+	RancherVersion    string
+	RancherOSVersion  string
+	// Output is text, json or mirror
+	Output string
+	// Registry is the registry images are mirrored to with the mirror output
+	Registry string
+}
+
+// Images prints the images the plans of the config pull, without contacting the cluster
+// or the machine inventory
+func (r *Rancherd) Images(ctx context.Context, imagesConfig ImagesConfig) error {
+	cfg, err := config.LoadLocal(r.cfg.ConfigPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	if imagesConfig.KubernetesVersion == "" {
+		imagesConfig.KubernetesVersion = cfg.KubernetesVersion
+	}
+	if imagesConfig.RancherVersion == "" {
+		imagesConfig.RancherVersion = cfg.RancherVersion
+	}
+
+	k8sVersion, err := versions.K8sVersion(imagesConfig.KubernetesVersion)
+	if err != nil {
+		return err
+	}
+
+	rancherVersion, err := versions.RancherVersion(imagesConfig.RancherVersion)
+	if err != nil {
+		return err
+	}
+
+	var rancherOSVersion string
+	if imagesConfig.RancherOSVersion != "" {
+		if rancherOSVersion, err = versions.RancherOSVersion(imagesConfig.RancherOSVersion); err != nil {
+			return err
+		}
+	}
+
+	list := images.List(&cfg, runtime.ForVersion(k8sVersion).Name(), k8sVersion, rancherVersion, rancherOSVersion)
+
+	switch imagesConfig.Output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	case "mirror":
+		for _, line := range images.Mirror(list, imagesConfig.Registry) {
+			fmt.Println(line)
+		}
+		return nil
+	case "", "text":
+	default:
+		return fmt.Errorf("invalid output %q, must be text, json or mirror", imagesConfig.Output)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "IMAGE\tUSE\n")
+	for _, image := range list {
+		fmt.Fprintf(w, "%s\t%s\n", image.Image, image.Use)
+	}
+	return w.Flush()
+}
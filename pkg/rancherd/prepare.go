@@ -0,0 +1,152 @@
+package rancherd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rancher/rancherd/pkg/bundle"
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/images"
+	"github.com/rancher/rancherd/pkg/plan"
+	"github.com/rancher/rancherd/pkg/registry"
+	"github.com/rancher/rancherd/pkg/runtime"
+	"github.com/rancher/rancherd/pkg/versions"
+	"github.com/rancher/system-agent/pkg/image"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// PreparedState is written by Prepare and read by bootstrap on first boot
+type PreparedState struct {
+	Created time.Time `json:"created"`
+	// KubernetesVersion and RancherVersion are the versions or channels of the config
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+	RancherVersion    string `json:"rancherVersion,omitempty"`
+	// ResolvedKubernetesVersion and ResolvedRancherVersion are the versions the
+	// channels resolved to
+	ResolvedKubernetesVersion string         `json:"resolvedKubernetesVersion"`
+	ResolvedRancherVersion    string         `json:"resolvedRancherVersion"`
+	Images                    []images.Image `json:"images"`
+}
+
+// Prepare does the parts of bootstrap that do not depend on the node, for building
+// machine images: the versions are resolved, the images of the plans are staged and
+// extracted with their install scripts. No token is generated and nothing is installed
+// or started.
+func (r *Rancherd) Prepare(ctx context.Context) error {
+	if done, err := r.done(); err != nil {
+		return err
+	} else if done {
+		return fmt.Errorf("system is already bootstrapped, a bootstrapped system can not be prepared")
+	}
+
+	cfg, err := config.LoadLocal(r.cfg.ConfigPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	// the channels of a bundle resolve to the bundled versions
+	if cfg.Bundle != "" {
+		if _, err := bundle.Load(cfg.Bundle, r.cfg.DataDir); err != nil {
+			return fmt.Errorf("loading bundle: %w", err)
+		}
+	}
+
+	k8sVersion, err := versions.K8sVersion(cfg.KubernetesVersion)
+	if err != nil {
+		return err
+	}
+
+	rancherVersion, err := versions.RancherVersion(cfg.RancherVersion)
+	if err != nil {
+		return err
+	}
+
+	state := PreparedState{
+		Created:                   time.Now().UTC(),
+		KubernetesVersion:         cfg.KubernetesVersion,
+		RancherVersion:            cfg.RancherVersion,
+		ResolvedKubernetesVersion: k8sVersion,
+		ResolvedRancherVersion:    rancherVersion,
+		Images:                    images.List(&cfg, runtime.ForVersion(k8sVersion).Name(), k8sVersion, rancherVersion, ""),
+	}
+
+	logrus.Infof("Preparing Rancher (%s/%s)", rancherVersion, k8sVersion)
+
+	registriesFile := registry.GetConfigFile(runtime.ForVersion(k8sVersion).Name())
+	if cfg.Bundle == "" {
+		if err := bundle.Stage(ctx, state.Images, registriesFile); err != nil {
+			return err
+		}
+	}
+
+	if err := r.extractImages(state.Images, registriesFile); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(r.cfg.DataDir, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(r.PreparedStamp(), data, 0600); err != nil {
+		return err
+	}
+
+	logrus.Infof("Successfully prepared Rancher (%s/%s)", rancherVersion, k8sVersion)
+	return nil
+}
+
+// extractImages extracts the staged images to the dirs the bootstrap plan runs them from
+// on first boot
+func (r *Rancherd) extractImages(list []images.Image, registriesFile string) error {
+	dir := plan.PreparedImagesDir(r.cfg.DataDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	util := image.NewUtility(bundle.ImagesDir(), "", "", registriesFile)
+	for _, img := range list {
+		logrus.Infof("Extracting %s (%s)", img.Image, img.Use)
+		if err := util.Stage(plan.PreparedImageDir(r.cfg.DataDir, img.Image), img.Image); err != nil {
+			return fmt.Errorf("extracting %s: %w", img.Image, err)
+		}
+	}
+	return nil
+}
+
+// prepared returns the state written by Prepare if the config was not changed to other
+// versions since
+func (r *Rancherd) prepared(cfg *config.Config) *PreparedState {
+	data, err := ioutil.ReadFile(r.PreparedStamp())
+	if err != nil {
+		return nil
+	}
+
+	state := &PreparedState{}
+	if err := yaml.Unmarshal(data, state); err != nil {
+		logrus.Warnf("ignoring invalid prepared state %s: %v", r.PreparedStamp(), err)
+		return nil
+	}
+
+	if !preparedVersion(cfg.KubernetesVersion, state.KubernetesVersion, state.ResolvedKubernetesVersion) ||
+		!preparedVersion(cfg.RancherVersion, state.RancherVersion, state.ResolvedRancherVersion) {
+		logrus.Infof("Ignoring prepared state %s, the versions of the config changed", r.PreparedStamp())
+		return nil
+	}
+	return state
+}
+
+func preparedVersion(version, prepared, resolved string) bool {
+	return version == "" || version == prepared || version == resolved
+}
+
+func (r *Rancherd) PreparedStamp() string {
+	return filepath.Join(r.cfg.DataDir, "prepared")
+}
@@ -0,0 +1,64 @@
+package rancherd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/rancher/rancherd/pkg/bundle"
+	"github.com/rancher/rancherd/pkg/images"
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/plan"
+)
+
+func TestExtractImages(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv(paths.RootEnv, root)
+
+	const installer = "rancher/system-agent-installer-k3s:v1.24.10-k3s1"
+	layer, err := crane.Layer(map[string][]byte{
+		"run.sh":       []byte("#!/bin/sh\nexec ./installer.sh\n"),
+		"installer.sh": []byte("#!/bin/sh\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := name.NewTag(installer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bundle.SaveImage(tag, img); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(Config{DataDir: DefaultDataDir})
+	// a stale dir of an earlier prepare is removed
+	stale := plan.PreparedImageDir(r.cfg.DataDir, "rancher/system-agent-installer-k3s:v1.23.0-k3s1")
+	if err := os.MkdirAll(stale, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.extractImages([]images.Image{{Image: installer, Use: "k3s installer"}}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// the install script is extracted outside of the work dir of the plans
+	dir := plan.PreparedImageDir(filepath.Join(root, DefaultDataDir), installer)
+	for _, file := range []string{"run.sh", "installer.sh"} {
+		if _, err := ioutil.ReadFile(filepath.Join(dir, file)); err != nil {
+			t.Errorf("%s was not extracted: %v", file, err)
+		}
+	}
+	if _, err := ioutil.ReadDir(stale); err == nil {
+		t.Errorf("stale dir %s was not removed", stale)
+	}
+}
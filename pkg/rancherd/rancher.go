@@ -2,15 +2,28 @@ package rancherd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/rancher/rancherd/pkg/bundle"
 	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/discovery"
+	"github.com/rancher/rancherd/pkg/facts"
+	"github.com/rancher/rancherd/pkg/leave"
+	"github.com/rancher/rancherd/pkg/paths"
 	"github.com/rancher/rancherd/pkg/plan"
+	"github.com/rancher/rancherd/pkg/preflight"
+	"github.com/rancher/rancherd/pkg/reset"
+	"github.com/rancher/rancherd/pkg/runtime"
+	"github.com/rancher/rancherd/pkg/selfupdate"
+	"github.com/rancher/rancherd/pkg/tpm"
 	"github.com/rancher/rancherd/pkg/version"
 	"github.com/rancher/rancherd/pkg/versions"
+	"github.com/rancher/wrangler/pkg/data/convert"
 	"github.com/sirupsen/logrus"
 	"sigs.k8s.io/yaml"
 )
@@ -23,7 +36,9 @@ const (
 )
 
 type Config struct {
-	Force      bool
+	Force  bool
+	DryRun bool
+	// DataDir and ConfigPath are host paths, they are prefixed with the root of pkg/paths
 	DataDir    string
 	ConfigPath string
 }
@@ -33,13 +48,38 @@ type UpgradeConfig struct {
 	KubernetesVersion string
 	RancherOSVersion  string
 	Force             bool
+	// Snapshot saves an etcd snapshot before upgrading, also enabled by
+	// etcdSnapshot.beforeUpgrade in the config
+	Snapshot bool
+	// RancherdVersion upgrades rancherd to this version first and then runs the
+	// upgrade with the new binary
+	RancherdVersion string
+	// SelfUpdate configures how the new rancherd binary is fetched and verified
+	SelfUpdate selfupdate.Options
+}
+
+type ResetConfig struct {
+	Drain bool
 }
 
 type Rancherd struct {
 	cfg Config
+	// preflighted is set once the pre-flight checks of bootstrap passed
+	preflighted bool
+}
+
+// preflightError is a failed pre-flight check, which aborts bootstrap
+type preflightError struct {
+	err error
+}
+
+func (p *preflightError) Error() string {
+	return p.err.Error()
 }
 
 func New(cfg Config) *Rancherd {
+	cfg.DataDir = paths.Host(cfg.DataDir)
+	cfg.ConfigPath = paths.Host(cfg.ConfigPath)
 	return &Rancherd{
 		cfg: cfg,
 	}
@@ -53,99 +93,291 @@ func (r *Rancherd) Info(ctx context.Context) error {
 	if rancherOSVersion != "" {
 		fmt.Printf("    RancherOS:  %s\n", rancherOSVersion)
 	}
-	fmt.Printf("    Rancherd:   %s\n\n", version.FriendlyVersion())
+	fmt.Printf("    Rancherd:   %s\n", version.FriendlyVersion())
+	if ek, err := r.tpmEK(); err == nil {
+		fmt.Printf("    TPM EK:     %s %s\n", ek.Algorithm, ek.Hash)
+	}
+	fmt.Println()
 	return nil
 }
 
-func (r *Rancherd) Upgrade(ctx context.Context, upgradeConfig UpgradeConfig) error {
-	cfg, err := config.Load(r.cfg.ConfigPath)
+// Inventory prints the config last downloaded from the machine inventory
+func (r *Rancherd) Inventory(ctx context.Context) error {
+	cfg, err := config.LoadLocal(r.cfg.ConfigPath)
 	if err != nil {
 		return fmt.Errorf("loading config: %w", err)
 	}
 
-	rancherVersion, err := versions.RancherVersion(upgradeConfig.RancherVersion)
+	t, err := config.NewTPM(cfg)
 	if err != nil {
 		return err
 	}
 
-	k8sVersion, err := versions.K8sVersion(upgradeConfig.KubernetesVersion)
+	cache, err := config.ReadInventoryCache(t, r.cfg.DataDir, cfg.Token)
 	if err != nil {
 		return err
 	}
+	if cache == nil {
+		fmt.Printf("No config has been downloaded from the machine inventory\n")
+		return nil
+	}
+
+	var inventoryConfig config.Config
+	if err := convert.ToObj(cache.Config, &inventoryConfig); err != nil {
+		return err
+	}
+	config.AddSecrets(inventoryConfig.Secrets()...)
 
-	rancherOSVersion, err := versions.RancherOSVersion(upgradeConfig.RancherOSVersion)
+	data, err := yaml.Marshal(cache.Config)
 	if err != nil {
 		return err
 	}
 
-	existingRancherVersion, existingK8sVersion, existingRancherOSVersion := r.getExistingVersions(ctx)
-	if existingRancherVersion == rancherVersion &&
-		existingK8sVersion == k8sVersion &&
-		(existingRancherOSVersion == "" || existingRancherOSVersion == rancherOSVersion) {
-		fmt.Printf("\nNothing to upgrade:\n\n")
-		fmt.Printf("    Rancher:    %s\n", rancherVersion)
-		if existingRancherOSVersion != "" {
-			fmt.Printf("    RancherOS:  %s\n", rancherOSVersion)
+	fmt.Printf("    Server:     %s\n", cache.Server)
+	fmt.Printf("    Version:    %s\n", cache.Version)
+	fmt.Printf("    Fetched:    %s\n", cache.Fetched.Local().Format(time.RFC3339))
+	fmt.Printf("    Cache:      %s\n\n", config.InventoryCachePath(r.cfg.DataDir))
+	fmt.Print(config.Redact(string(data)))
+	return nil
+}
+
+// Preflight runs the pre-flight checks of bootstrap and prints the results
+func (r *Rancherd) Preflight(ctx context.Context) error {
+	cfg, err := r.loadConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	k8sVersion, err := versions.K8sVersion(cfg.KubernetesVersion)
+	if err != nil {
+		return err
+	}
+
+	results, err := r.preflight(ctx, &cfg, k8sVersion)
+	for _, result := range results {
+		status := "ok"
+		if result.Err != nil {
+			status = fmt.Sprintf("%s: %v", result.Severity, result.Err)
 		}
-		fmt.Printf("    Kubernetes: %s\n\n", k8sVersion)
-		return nil
+		fmt.Printf("    %-18s%s\n", result.Name+":", status)
 	}
+	return err
+}
 
-	if existingRancherVersion == rancherVersion {
-		rancherVersion = ""
+func (r *Rancherd) preflight(ctx context.Context, cfg *config.Config, k8sVersion string) ([]preflight.Result, error) {
+	// only cluster-init installs the runtime of the Kubernetes version, Rancher installs
+	// the runtime of the cluster on joining nodes
+	runtimeName := config.RuntimeUnknown
+	if cfg.Role == "cluster-init" {
+		runtimeName = runtime.ForVersion(k8sVersion).Name()
 	}
-	if existingK8sVersion == k8sVersion {
-		k8sVersion = ""
+	return preflight.Run(ctx, preflight.Params{
+		Config:  cfg,
+		Runtime: runtimeName,
+	})
+}
+
+// tpmEK returns the EK identifying this machine, selected with the tpm settings the
+// machine was bootstrapped with
+func (r *Rancherd) tpmEK() (*tpm.EKInfo, error) {
+	var cfg config.Config
+	if data, err := ioutil.ReadFile(r.DoneStamp()); err == nil {
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			cfg = config.Config{}
+		}
 	}
-	if existingRancherOSVersion == "" || existingRancherOSVersion == rancherOSVersion {
-		rancherOSVersion = ""
+
+	t, err := config.NewTPM(cfg)
+	if err != nil {
+		return nil, err
 	}
+	return t.SelectedEK()
+}
 
-	if k8sVersion != "" && existingK8sVersion != "" {
-		existingRuntime := config.GetRuntime(existingK8sVersion)
-		newRuntime := config.GetRuntime(k8sVersion)
-		if existingRuntime != newRuntime {
-			return fmt.Errorf("existing %s version %s is not compatible with %s version %s",
-				existingRuntime, existingK8sVersion, newRuntime, k8sVersion)
+// UnsealSecrets writes the secrets sealed to the TPM during bootstrap to the files read
+// by the runtime. The tpm settings are read from the config bootstrap ran with, as the
+// config may be gone by the time the runtime restarts.
+func (r *Rancherd) UnsealSecrets(ctx context.Context) error {
+	var cfg config.Config
+	for _, stamp := range []string{r.DoneStamp(), r.WorkingStamp()} {
+		data, err := ioutil.ReadFile(stamp)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
 		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("parsing %s: %w", stamp, err)
+		}
+		break
 	}
 
-	fmt.Printf("\nUpgrading to:\n\n")
-	if rancherVersion != "" {
-		fmt.Printf("    Rancher:    %s => %s\n", existingRancherVersion, rancherVersion)
+	return plan.UnsealSecrets(&cfg, r.cfg.DataDir)
+}
+
+func (r *Rancherd) Upgrade(ctx context.Context, upgradeConfig UpgradeConfig) error {
+	if err := paths.RequireHost("upgrade"); err != nil {
+		return err
 	}
-	if k8sVersion != "" {
-		fmt.Printf("    Kubernetes: %s => %s\n", existingK8sVersion, k8sVersion)
+	if err := r.upgradeSelf(ctx, upgradeConfig); err != nil {
+		return err
 	}
-	if rancherOSVersion != "" {
-		fmt.Printf("    RancherOS:  %s => %s\n", existingRancherOSVersion, rancherOSVersion)
+
+	upgradePlan, err := r.PlanUpgrade(ctx, upgradeConfig)
+	if err != nil {
+		return err
 	}
 
-	if !r.cfg.Force {
-		go func() {
-			<-ctx.Done()
-			logrus.Fatalf("Aborting")
-		}()
+	if upgradePlan.Status == UpgradeStatusIncompatible {
+		return errors.New(upgradePlan.Reason)
+	}
 
-		fmt.Printf("\nPress any key to continue, or CTRL+C to cancel\n")
-		_, err := os.Stdin.Read(make([]byte, 1))
-		if err != nil {
-			return err
+	if err := upgradePlan.Print(os.Stdout, ""); err != nil {
+		return err
+	}
+	if upgradePlan.Status == UpgradeStatusUpToDate {
+		return nil
+	}
+
+	if err := r.confirm(ctx); err != nil {
+		return err
+	}
+
+	return plan.RunWithKubernetesVersion(ctx, upgradePlan.k8sVersion, upgradePlan.plan, r.cfg.DataDir)
+}
+
+// Reset uninstalls Rancher and Kubernetes from this node and removes all files
+// written by bootstrap so the node can be bootstrapped again
+func (r *Rancherd) Reset(ctx context.Context, resetConfig ResetConfig) error {
+	if err := paths.RequireHost("reset"); err != nil {
+		return err
+	}
+	nodeName := r.nodeName()
+
+	fmt.Printf("\nResetting node %s:\n\n", nodeName)
+	if resetConfig.Drain {
+		fmt.Printf("    Drain and remove the node from the cluster\n")
+	}
+	fmt.Printf("    Uninstall Rancher and Kubernetes\n")
+	fmt.Printf("    Remove %s\n", r.cfg.DataDir)
+
+	if err := r.confirm(ctx); err != nil {
+		return err
+	}
+
+	if err := reset.Reset(ctx, reset.Options{
+		DataDir:  r.cfg.DataDir,
+		NodeName: nodeName,
+		Drain:    resetConfig.Drain,
+	}); err != nil {
+		return err
+	}
+
+	logrus.Infof("Successfully reset node %s", nodeName)
+	return nil
+}
+
+// Leave removes this node from the cluster and then resets it
+func (r *Rancherd) Leave(ctx context.Context) error {
+	if err := paths.RequireHost("leave"); err != nil {
+		return err
+	}
+	nodeName := r.nodeName()
+
+	fmt.Printf("\nRemoving node %s from the cluster:\n\n", nodeName)
+	fmt.Printf("    Drain the node and remove its etcd member\n")
+	fmt.Printf("    Delete the Rancher machine and the node\n")
+	fmt.Printf("    Uninstall Rancher and Kubernetes\n")
+	fmt.Printf("    Remove %s\n", r.cfg.DataDir)
+
+	if err := r.confirm(ctx); err != nil {
+		return err
+	}
+
+	if err := leave.Leave(ctx, leave.Options{
+		DataDir:  r.cfg.DataDir,
+		NodeName: nodeName,
+	}); err != nil {
+		return err
+	}
+
+	logrus.Infof("Node %s successfully left the cluster", nodeName)
+	return nil
+}
+
+// nodeName returns the name of this node in the cluster as it was bootstrapped
+func (r *Rancherd) nodeName() string {
+	if data, err := ioutil.ReadFile(r.DoneStamp()); err == nil {
+		var cfg config.Config
+		if err := yaml.Unmarshal(data, &cfg); err == nil && cfg.NodeName != "" {
+			return cfg.NodeName
 		}
 	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
 
-	nodePlan, err := plan.Upgrade(&cfg, k8sVersion, rancherVersion, rancherOSVersion, DefaultDataDir)
+func (r *Rancherd) confirm(ctx context.Context) error {
+	if r.cfg.Force {
+		return nil
+	}
+
+	go func() {
+		<-ctx.Done()
+		logrus.Fatalf("Aborting")
+	}()
+
+	fmt.Printf("\nPress any key to continue, or CTRL+C to cancel\n")
+	_, err := os.Stdin.Read(make([]byte, 1))
+	return err
+}
+
+func (r *Rancherd) loadConfig(ctx context.Context) (config.Config, error) {
+	cfg, err := config.Load(ctx, r.cfg.ConfigPath, r.cfg.DataDir)
+	if err != nil {
+		return cfg, fmt.Errorf("loading config: %w", err)
+	}
+
+	t, err := config.NewTPM(cfg)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg, err = config.Render(cfg, facts.Gather(t))
+	if err != nil {
+		return cfg, fmt.Errorf("rendering config: %w", err)
+	}
+	return cfg, nil
+}
+
+func (r *Rancherd) dryRun(ctx context.Context) error {
+	cfg, err := r.loadConfig(ctx)
 	if err != nil {
 		return err
 	}
 
-	return plan.RunWithKubernetesVersion(ctx, k8sVersion, nodePlan, DefaultDataDir)
+	if cfg.Role == "" {
+		logrus.Infof("No role defined, nothing would be bootstrapped")
+		return nil
+	}
+
+	nodeCfg := cfg
+	if err := discovery.DiscoverServerAndRole(ctx, &nodeCfg); err != nil {
+		return err
+	}
+
+	nodePlan, err := plan.ToPlan(&nodeCfg, r.cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("generating plan: %w", err)
+	}
+
+	return plan.Print(os.Stdout, nodePlan)
 }
 
 func (r *Rancherd) execute(ctx context.Context) error {
-	cfg, err := config.Load(r.cfg.ConfigPath)
+	cfg, err := r.loadConfig(ctx)
 	if err != nil {
-		return fmt.Errorf("loading config: %w", err)
+		return err
 	}
 
 	if err := r.setWorking(cfg); err != nil {
@@ -157,6 +389,18 @@ func (r *Rancherd) execute(ctx context.Context) error {
 		return nil
 	}
 
+	prepared := r.prepared(&cfg)
+	if prepared != nil {
+		// the images were staged and extracted and the channels resolved by prepare
+		logrus.Infof("Using the state prepared at %s", prepared.Created.Local().Format(time.RFC3339))
+		cfg.KubernetesVersion = prepared.ResolvedKubernetesVersion
+		cfg.RancherVersion = prepared.ResolvedRancherVersion
+	} else if cfg.Bundle != "" {
+		if _, err := bundle.Load(cfg.Bundle, r.cfg.DataDir); err != nil {
+			return fmt.Errorf("loading bundle: %w", err)
+		}
+	}
+
 	k8sVersion, err := versions.K8sVersion(cfg.KubernetesVersion)
 	if err != nil {
 		return err
@@ -169,12 +413,36 @@ func (r *Rancherd) execute(ctx context.Context) error {
 
 	logrus.Infof("Bootstrapping Rancher (%s/%s)", rancherVersion, k8sVersion)
 
-	nodePlan, err := plan.ToPlan(ctx, &cfg, r.cfg.DataDir)
+	nodeCfg := cfg
+	if err := discovery.DiscoverServerAndRole(ctx, &nodeCfg); err != nil {
+		return err
+	}
+
+	// the checks run once with the discovered role, a failed check aborts bootstrap
+	// instead of being retried
+	if !r.preflighted {
+		if _, err := r.preflight(ctx, &nodeCfg, k8sVersion); err != nil {
+			return &preflightError{err: err}
+		}
+		r.preflighted = true
+	}
+
+	if err := plan.SealSecrets(&nodeCfg, r.cfg.DataDir); err != nil {
+		return fmt.Errorf("sealing secrets: %w", err)
+	}
+
+	nodePlan, err := plan.ToPlan(&nodeCfg, r.cfg.DataDir)
 	if err != nil {
 		return fmt.Errorf("generating plan: %w", err)
 	}
 
-	if err := plan.Run(ctx, &cfg, nodePlan, r.cfg.DataDir); err != nil {
+	if prepared != nil {
+		if err := plan.UsePreparedImages(nodePlan, r.cfg.DataDir); err != nil {
+			return err
+		}
+	}
+
+	if err := plan.Run(ctx, &nodeCfg, nodePlan, r.cfg.DataDir); err != nil {
 		return fmt.Errorf("running plan: %w", err)
 	}
 
@@ -182,11 +450,25 @@ func (r *Rancherd) execute(ctx context.Context) error {
 		return err
 	}
 
+	if prepared != nil {
+		// the extracted images are not used once bootstrapped
+		if err := os.RemoveAll(plan.PreparedImagesDir(r.cfg.DataDir)); err != nil {
+			logrus.Warnf("failed to remove %s: %v", plan.PreparedImagesDir(r.cfg.DataDir), err)
+		}
+	}
+
 	logrus.Infof("Successfully Bootstrapped Rancher (%s/%s)", rancherVersion, k8sVersion)
 	return nil
 }
 
 func (r *Rancherd) Run(ctx context.Context) error {
+	if r.cfg.DryRun {
+		return r.dryRun(ctx)
+	}
+	if err := paths.RequireHost("bootstrap"); err != nil {
+		return err
+	}
+
 	if done, err := r.done(); err != nil {
 		return fmt.Errorf("checking done stamp [%s]: %w", r.DoneStamp(), err)
 	} else if done {
@@ -199,6 +481,10 @@ func (r *Rancherd) Run(ctx context.Context) error {
 		if err == nil {
 			return nil
 		}
+		var preflightErr *preflightError
+		if errors.As(err, &preflightErr) {
+			return preflightErr.err
+		}
 		logrus.Infof("failed to bootstrap system, will retry: %v", err)
 		select {
 		case <-ctx.Done():
@@ -209,10 +495,10 @@ func (r *Rancherd) Run(ctx context.Context) error {
 }
 
 func (r *Rancherd) writeConfig(path string, cfg config.Config) error {
-	if err := os.MkdirAll(filepath.Dir(path), 0600); err != nil {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
 		return fmt.Errorf("mkdir %s: %w", filepath.Dir(path), err)
 	}
-	f, err := os.Create(path)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
@@ -221,7 +507,7 @@ func (r *Rancherd) writeConfig(path string, cfg config.Config) error {
 	if err != nil {
 		return err
 	}
-	_, err = f.Write(data)
+	_, err = f.Write([]byte(config.Redact(string(data))))
 	return err
 }
 
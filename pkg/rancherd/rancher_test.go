@@ -0,0 +1,72 @@
+package rancherd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/rancherd/pkg/paths"
+)
+
+// captureStdout returns what f prints to stdout
+func captureStdout(t *testing.T, f func() error) (string, error) {
+	t.Helper()
+	out, err := ioutil.TempFile(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	stdout := os.Stdout
+	os.Stdout = out
+	err = f()
+	os.Stdout = stdout
+
+	data, readErr := ioutil.ReadFile(out.Name())
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	return string(data), err
+}
+
+func TestRunWithRoot(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv(paths.RootEnv, root)
+
+	configFile := filepath.Join(root, DefaultConfigFile)
+	if err := os.MkdirAll(filepath.Dir(configFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(configFile, []byte("role: cluster-init\nkubernetesVersion: v1.24.10+k3s1\nrancherVersion: v2.7.1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(Config{
+		DryRun:     true,
+		DataDir:    DefaultDataDir,
+		ConfigPath: DefaultConfigFile,
+	})
+	out, err := captureStdout(t, func() error {
+		return r.Run(context.Background())
+	})
+	if err != nil {
+		t.Fatalf("dry run with root: %v", err)
+	}
+	if !strings.Contains(out, filepath.Join(root, "/etc/rancher/k3s")) {
+		t.Errorf("plan does not write the runtime config under the root:\n%s", out)
+	}
+
+	r = New(Config{
+		DataDir:    DefaultDataDir,
+		ConfigPath: DefaultConfigFile,
+	})
+	if err := r.Run(context.Background()); err == nil || !strings.Contains(err.Error(), root) {
+		t.Errorf("expected bootstrap with root to be refused, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, DefaultDataDir)); !os.IsNotExist(err) {
+		t.Errorf("data dir was written under the root: %v", err)
+	}
+}
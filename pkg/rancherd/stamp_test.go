@@ -0,0 +1,43 @@
+package rancherd
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/rancher/rancherd/pkg/config"
+)
+
+func TestStampsRedacted(t *testing.T) {
+	config.AddSecrets("stamp-token")
+	r := &Rancherd{
+		cfg: Config{
+			DataDir: t.TempDir(),
+		},
+	}
+	cfg := config.Config{
+		RuntimeConfig: config.RuntimeConfig{
+			Role:  "server",
+			Token: "stamp-token",
+		},
+	}
+
+	if err := r.setWorking(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.setDone(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, stamp := range []string{r.WorkingStamp(), r.DoneStamp()} {
+		data, err := ioutil.ReadFile(stamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "stamp-token") {
+			t.Errorf("%s has the token:\n%s", stamp, data)
+		}
+		if !strings.Contains(string(data), "role: server") {
+			t.Errorf("%s does not have the config:\n%s", stamp, data)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package rancherd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/rancher/rancherd/pkg/etcdsnapshot"
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/plan"
+	"github.com/rancher/rancherd/pkg/runtime"
+	"github.com/rancher/rancherd/pkg/self"
+	"github.com/rancher/rancherd/pkg/selfupdate"
+	"github.com/rancher/rancherd/pkg/version"
+	"github.com/rancher/rancherd/pkg/versions"
+	"github.com/rancher/system-agent/pkg/applyinator"
+	"github.com/sirupsen/logrus"
+)
+
+type UpgradeStatus string
+
+const (
+	// UpgradeStatusUpToDate means the target versions are already installed
+	UpgradeStatusUpToDate UpgradeStatus = "up-to-date"
+	// UpgradeStatusAvailable means at least one target version is not installed
+	UpgradeStatusAvailable UpgradeStatus = "upgrade-available"
+	// UpgradeStatusIncompatible means the target Kubernetes version uses another runtime
+	UpgradeStatusIncompatible UpgradeStatus = "incompatible"
+
+	// Exit codes of upgrade --plan-only, 1 is used for all errors
+	ExitCodeUpToDate     = 0
+	ExitCodeAvailable    = 2
+	ExitCodeIncompatible = 3
+)
+
+type UpgradeVersions struct {
+	Rancher    string `json:"rancher,omitempty"`
+	Kubernetes string `json:"kubernetes,omitempty"`
+	RancherOS  string `json:"rancherOS,omitempty"`
+}
+
+// UpgradePlan describes what rancherd upgrade would do
+type UpgradePlan struct {
+	Status  UpgradeStatus   `json:"status"`
+	Current UpgradeVersions `json:"current"`
+	Target  UpgradeVersions `json:"target"`
+	// Reason explains why the upgrade is incompatible
+	Reason string `json:"reason,omitempty"`
+	// Snapshot is the name of the etcd snapshot saved before upgrading
+	Snapshot string `json:"snapshot,omitempty"`
+	// Instructions are the redacted instructions of the upgrade plan
+	Instructions []applyinator.Instruction `json:"instructions,omitempty"`
+
+	plan       *applyinator.Plan
+	k8sVersion string
+}
+
+// ExitCode returns the exit code of upgrade --plan-only for the status of the plan
+func (u *UpgradePlan) ExitCode() int {
+	switch u.Status {
+	case UpgradeStatusAvailable:
+		return ExitCodeAvailable
+	case UpgradeStatusIncompatible:
+		return ExitCodeIncompatible
+	}
+	return ExitCodeUpToDate
+}
+
+// selfUpdatedEnv is set when rancherd re-executes itself after updating its binary
+const selfUpdatedEnv = "RANCHERD_SELF_UPDATED"
+
+// SelfUpgrade replaces the rancherd binary with a new verified binary
+func (r *Rancherd) SelfUpgrade(ctx context.Context, opts selfupdate.Options) error {
+	if err := paths.RequireHost("upgrade --self"); err != nil {
+		return err
+	}
+	opts, err := r.selfUpdateOptions(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nUpgrading to:\n\n")
+	fmt.Printf("    Rancherd:   %s => %s\n", version.FriendlyVersion(), selfUpdateTarget(opts))
+
+	if err := r.confirm(ctx); err != nil {
+		return err
+	}
+	return selfUpdate(opts)
+}
+
+// selfUpdateOptions validates opts and fills in the registry settings of the config
+func (r *Rancherd) selfUpdateOptions(ctx context.Context, opts selfupdate.Options) (selfupdate.Options, error) {
+	if opts.File == "" && opts.Version == "" && opts.Image == "" {
+		return opts, fmt.Errorf("a rancherd version, image or file is required")
+	}
+
+	cfg, err := r.loadConfig(ctx)
+	if err != nil {
+		return opts, err
+	}
+	if opts.SystemDefaultRegistry == "" {
+		opts.SystemDefaultRegistry = cfg.SystemDefaultRegistry
+	}
+	if opts.RegistriesFile == "" {
+		// without a runtime there is no registries config and the image is pulled directly
+		installed, err := runtime.Detect()
+		if err == nil {
+			opts.RegistriesFile = installed.RegistriesFile()
+		} else if !errors.Is(err, runtime.ErrNotInstalled) {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// selfUpdateTarget describes the binary rancherd is upgraded to
+func selfUpdateTarget(opts selfupdate.Options) string {
+	if opts.File != "" {
+		return opts.File
+	} else if opts.Image != "" {
+		return opts.Image
+	}
+	return opts.Version
+}
+
+func selfUpdate(opts selfupdate.Options) error {
+	path, err := selfupdate.Update(opts)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Successfully upgraded %s, the previous binary is %s", path, path+selfupdate.PrevSuffix)
+	return nil
+}
+
+// SelfRollback restores the rancherd binary replaced by the last SelfUpgrade
+func (r *Rancherd) SelfRollback(ctx context.Context) error {
+	if err := paths.RequireHost("upgrade --self --rollback"); err != nil {
+		return err
+	}
+	path, err := selfupdate.Rollback()
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Restored the previous binary %s", path)
+	return nil
+}
+
+// upgradeSelf updates rancherd to the rancherd version of the upgrade config or the
+// config file and runs the upgrade again with the new binary, so the plan instructions
+// that re-execute rancherd use the same binary that generated them. The upgrade is
+// confirmed once before rancherd is updated, the new binary runs it with --force.
+func (r *Rancherd) upgradeSelf(ctx context.Context, upgradeConfig UpgradeConfig) error {
+	if os.Getenv(selfUpdatedEnv) != "" {
+		return nil
+	}
+
+	rancherdVersion := upgradeConfig.RancherdVersion
+	if rancherdVersion == "" {
+		cfg, err := r.loadConfig(ctx)
+		if err != nil {
+			return err
+		}
+		rancherdVersion = cfg.RancherdVersion
+	}
+	if rancherdVersion == "" || rancherdVersion == version.Version {
+		return nil
+	}
+
+	opts := upgradeConfig.SelfUpdate
+	opts.Version = rancherdVersion
+	opts, err := r.selfUpdateOptions(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	upgradePlan, err := r.PlanUpgrade(ctx, upgradeConfig)
+	if err != nil {
+		return err
+	}
+	if upgradePlan.Status == UpgradeStatusIncompatible {
+		return errors.New(upgradePlan.Reason)
+	}
+	if err := upgradePlan.Print(os.Stdout, ""); err != nil {
+		return err
+	}
+	fmt.Printf("\nThe upgrade runs with rancherd %s, which replaces rancherd %s\n", selfUpdateTarget(opts), version.FriendlyVersion())
+
+	if err := r.confirm(ctx); err != nil {
+		return err
+	}
+	if err := selfUpdate(opts); err != nil {
+		return err
+	}
+
+	path, err := self.Self()
+	if err != nil {
+		return fmt.Errorf("resolving location of %s: %w", os.Args[0], err)
+	}
+	args := os.Args
+	if !r.cfg.Force {
+		// the upgrade was already confirmed
+		args = append(args[:len(args):len(args)], "--force")
+	}
+	logrus.Infof("Running the upgrade with rancherd %s", rancherdVersion)
+	return syscall.Exec(path, args, append(os.Environ(), selfUpdatedEnv+"=true"))
+}
+
+// PlanUpgrade resolves the target versions and returns the plan to upgrade to them
+// without changing anything
+func (r *Rancherd) PlanUpgrade(ctx context.Context, upgradeConfig UpgradeConfig) (*UpgradePlan, error) {
+	cfg, err := r.loadConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rancherVersion, err := versions.RancherVersion(upgradeConfig.RancherVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	k8sVersion, err := versions.K8sVersion(upgradeConfig.KubernetesVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	rancherOSVersion, err := versions.RancherOSVersion(upgradeConfig.RancherOSVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	existingRancherVersion, existingK8sVersion, existingRancherOSVersion := r.getExistingVersions(ctx)
+
+	result := &UpgradePlan{
+		Status: UpgradeStatusAvailable,
+		Current: UpgradeVersions{
+			Rancher:    existingRancherVersion,
+			Kubernetes: existingK8sVersion,
+			RancherOS:  existingRancherOSVersion,
+		},
+		Target: UpgradeVersions{
+			Rancher:    rancherVersion,
+			Kubernetes: k8sVersion,
+		},
+	}
+	if existingRancherOSVersion != "" {
+		result.Target.RancherOS = rancherOSVersion
+	}
+
+	if existingRancherVersion == rancherVersion &&
+		existingK8sVersion == k8sVersion &&
+		(existingRancherOSVersion == "" || existingRancherOSVersion == rancherOSVersion) {
+		result.Status = UpgradeStatusUpToDate
+		return result, nil
+	}
+
+	if existingRancherVersion == rancherVersion {
+		rancherVersion = ""
+	}
+	if existingK8sVersion == k8sVersion {
+		k8sVersion = ""
+	}
+	if existingRancherOSVersion == "" || existingRancherOSVersion == rancherOSVersion {
+		rancherOSVersion = ""
+	}
+
+	if k8sVersion != "" && existingK8sVersion != "" {
+		existingRuntime := runtime.ForVersion(existingK8sVersion).Name()
+		newRuntime := runtime.ForVersion(k8sVersion).Name()
+		if existingRuntime != newRuntime {
+			result.Status = UpgradeStatusIncompatible
+			result.Reason = fmt.Sprintf("existing %s version %s is not compatible with %s version %s",
+				existingRuntime, existingK8sVersion, newRuntime, k8sVersion)
+			return result, nil
+		}
+	}
+
+	if upgradeConfig.Snapshot || (cfg.EtcdSnapshot != nil && cfg.EtcdSnapshot.BeforeUpgrade) {
+		var changes []string
+		if rancherVersion != "" {
+			changes = append(changes, existingRancherVersion, rancherVersion)
+		}
+		if k8sVersion != "" {
+			changes = append(changes, existingK8sVersion, k8sVersion)
+		}
+		if rancherOSVersion != "" {
+			changes = append(changes, existingRancherOSVersion, rancherOSVersion)
+		}
+		result.Snapshot = etcdsnapshot.UpgradeName(changes...)
+	}
+
+	nodePlan, err := plan.Upgrade(&cfg, k8sVersion, rancherVersion, rancherOSVersion, result.Snapshot, r.cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	redacted, err := plan.Redact(nodePlan)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Instructions = redacted.Instructions
+	result.plan = nodePlan
+	result.k8sVersion = k8sVersion
+	return result, nil
+}
+
+// Print writes the plan to w, as JSON if output is "json"
+func (u *UpgradePlan) Print(w io.Writer, output string) error {
+	switch output {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(u)
+	case "", "text":
+	default:
+		return fmt.Errorf("invalid output %q, must be text or json", output)
+	}
+
+	switch u.Status {
+	case UpgradeStatusUpToDate:
+		fmt.Fprintf(w, "\nNothing to upgrade:\n\n")
+		fmt.Fprintf(w, "    Rancher:    %s\n", u.Target.Rancher)
+		if u.Target.RancherOS != "" {
+			fmt.Fprintf(w, "    RancherOS:  %s\n", u.Target.RancherOS)
+		}
+		fmt.Fprintf(w, "    Kubernetes: %s\n\n", u.Target.Kubernetes)
+		return nil
+	case UpgradeStatusIncompatible:
+		fmt.Fprintf(w, "\nCan not upgrade: %s\n\n", u.Reason)
+		return nil
+	}
+
+	fmt.Fprintf(w, "\nUpgrading to:\n\n")
+	if u.Current.Rancher != u.Target.Rancher {
+		fmt.Fprintf(w, "    Rancher:    %s => %s\n", u.Current.Rancher, u.Target.Rancher)
+	}
+	if u.Current.Kubernetes != u.Target.Kubernetes {
+		fmt.Fprintf(w, "    Kubernetes: %s => %s\n", u.Current.Kubernetes, u.Target.Kubernetes)
+	}
+	if u.Current.RancherOS != u.Target.RancherOS {
+		fmt.Fprintf(w, "    RancherOS:  %s => %s\n", u.Current.RancherOS, u.Target.RancherOS)
+	}
+	if u.Snapshot != "" {
+		fmt.Fprintf(w, "\nAn etcd snapshot is saved before upgrading\n")
+	}
+	return nil
+}
+
+// PrintInstructions writes the names of the instructions of the plan to w
+func (u *UpgradePlan) PrintInstructions(w io.Writer) {
+	if len(u.Instructions) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nInstructions:\n\n")
+	for _, inst := range u.Instructions {
+		fmt.Fprintf(w, "    %s\n", inst.Name)
+	}
+	fmt.Fprintln(w)
+}
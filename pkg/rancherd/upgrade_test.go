@@ -0,0 +1,56 @@
+package rancherd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/selfupdate"
+)
+
+func TestUpgradePlanExitCode(t *testing.T) {
+	tests := map[UpgradeStatus]int{
+		UpgradeStatusUpToDate:     ExitCodeUpToDate,
+		UpgradeStatusAvailable:    ExitCodeAvailable,
+		UpgradeStatusIncompatible: ExitCodeIncompatible,
+	}
+	for status, expected := range tests {
+		plan := &UpgradePlan{Status: status}
+		if code := plan.ExitCode(); code != expected {
+			t.Errorf("exit code of %s = %d, expected %d", status, code, expected)
+		}
+	}
+}
+
+func TestSelfUpdateOptionsRegistries(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv(paths.RootEnv, root)
+	r := New(Config{DataDir: DefaultDataDir})
+
+	opts, err := r.selfUpdateOptions(context.Background(), selfupdate.Options{Version: "v0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.RegistriesFile != "" {
+		t.Errorf("expected no registries config without a runtime, got %s", opts.RegistriesFile)
+	}
+
+	bin := filepath.Join(root, "/usr/local/bin/rke2")
+	if err := os.MkdirAll(filepath.Dir(bin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(bin, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err = r.selfUpdateOptions(context.Background(), selfupdate.Options{Version: "v0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(root, "/etc/rancher/rke2/registries.yaml"); opts.RegistriesFile != expected {
+		t.Errorf("expected registries config %s of the installed runtime, got %s", expected, opts.RegistriesFile)
+	}
+}
@@ -0,0 +1,244 @@
+package rancherd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rancher/rancherd/pkg/kubectl"
+	rancheros "github.com/rancher/rancherd/pkg/os"
+	"github.com/rancher/rancherd/pkg/rancher"
+	"github.com/rancher/wrangler/pkg/data"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	nodeRolePrefix = "node-role.kubernetes.io/"
+)
+
+var provisioningClusterGVR = schema.GroupVersionResource{
+	Group:    "provisioning.cattle.io",
+	Version:  "v1",
+	Resource: "clusters",
+}
+
+// NodeUpgradeStatus is the upgrade progress of one node of the cluster
+type NodeUpgradeStatus struct {
+	Name                 string
+	Roles                string
+	KubeletVersion       string
+	TargetKubeletVersion string
+	OSImage              string
+	TargetOSImage        string
+	Machine              string
+	Phase                string
+}
+
+// ClusterUpgradeStatus is the upgrade progress of the cluster
+type ClusterUpgradeStatus struct {
+	KubernetesVersion string
+	Ready             bool
+	Message           string
+	OSImage           string
+	Nodes             []NodeUpgradeStatus
+}
+
+// UpgradeStatus prints the upgrade progress of every node of the cluster. With watch
+// the status is printed again whenever it changes until ctx is cancelled.
+func (r *Rancherd) UpgradeStatus(ctx context.Context, watch bool) error {
+	kubeconfig, err := kubectl.GetKubeconfig("")
+	if err != nil {
+		return err
+	}
+
+	conf, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	client, err := dynamic.NewForConfig(conf)
+	if err != nil {
+		return err
+	}
+
+	k8s, err := kubernetes.NewForConfig(conf)
+	if err != nil {
+		return err
+	}
+
+	var last string
+	for {
+		status, err := getUpgradeStatus(ctx, client, k8s)
+		if err != nil {
+			if !watch {
+				return err
+			}
+			logrus.Warnf("failed to get upgrade status: %v", err)
+		} else if out := status.String(); out != last {
+			if watch && last != "" {
+				fmt.Printf("\n%s\n", time.Now().Format(time.RFC3339))
+			}
+			fmt.Print(out)
+			last = out
+		}
+
+		if !watch {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func getUpgradeStatus(ctx context.Context, client dynamic.Interface, k8s kubernetes.Interface) (*ClusterUpgradeStatus, error) {
+	status := &ClusterUpgradeStatus{}
+
+	cluster, err := client.Resource(provisioningClusterGVR).Namespace("fleet-local").Get(ctx, "local", metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	} else if err == nil {
+		obj := data.Object(cluster.Object)
+		status.KubernetesVersion = obj.String("spec", "kubernetesVersion")
+		for _, cond := range obj.Slice("status", "conditions") {
+			if cond.String("type") == "Ready" {
+				status.Ready = cond.String("status") == "True"
+				status.Message = cond.String("message")
+			}
+		}
+	}
+
+	osImage, osPhases, err := rancheros.UpgradePhases(ctx, client, k8s)
+	if err != nil {
+		return nil, err
+	}
+	status.OSImage = osImage
+
+	machines, err := machinePhases(ctx, client, k8s)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := k8s.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes.Items {
+		nodeStatus := NodeUpgradeStatus{
+			Name:                 node.Name,
+			Roles:                nodeRoles(&node),
+			KubeletVersion:       node.Status.NodeInfo.KubeletVersion,
+			TargetKubeletVersion: status.KubernetesVersion,
+			OSImage:              node.Status.NodeInfo.OSImage,
+			TargetOSImage:        osImage,
+			Machine:              machines[node.Name],
+		}
+		nodeStatus.Phase = nodePhase(&node, nodeStatus, osPhases[node.Name])
+		status.Nodes = append(status.Nodes, nodeStatus)
+	}
+
+	sort.Slice(status.Nodes, func(i, j int) bool {
+		return status.Nodes[i].Name < status.Nodes[j].Name
+	})
+	return status, nil
+}
+
+// nodePhase summarizes the upgrade of the node, the OS is upgraded before Kubernetes
+func nodePhase(node *corev1.Node, status NodeUpgradeStatus, osPhase rancheros.NodePhase) string {
+	ready := false
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			ready = cond.Status == corev1.ConditionTrue
+		}
+	}
+
+	switch {
+	case !ready:
+		return "not-ready"
+	case osPhase != "" && osPhase != rancheros.NodePhaseUpgraded:
+		return "os-" + string(osPhase)
+	case status.TargetKubeletVersion != "" && status.KubeletVersion != status.TargetKubeletVersion:
+		if node.Spec.Unschedulable {
+			return "kubernetes-upgrading"
+		}
+		return "kubernetes-pending"
+	}
+	return "up-to-date"
+}
+
+// machinePhases returns the phase of the Rancher machine of each node
+func machinePhases(ctx context.Context, client dynamic.Interface, k8s kubernetes.Interface) (map[string]string, error) {
+	machineGVR, err := rancher.MachineGVR(k8s.Discovery())
+	if err != nil {
+		return nil, err
+	}
+
+	machines, err := client.Resource(machineGVR).Namespace("fleet-local").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing machines: %w", err)
+	}
+
+	result := map[string]string{}
+	for _, machine := range machines.Items {
+		obj := data.Object(machine.Object)
+		if node := obj.String("status", "nodeRef", "name"); node != "" {
+			result[node] = obj.String("status", "phase")
+		}
+	}
+	return result, nil
+}
+
+func nodeRoles(node *corev1.Node) string {
+	var roles []string
+	for label, value := range node.Labels {
+		if strings.HasPrefix(label, nodeRolePrefix) && value == "true" {
+			roles = append(roles, strings.TrimPrefix(label, nodeRolePrefix))
+		}
+	}
+	sort.Strings(roles)
+	return strings.Join(roles, ",")
+}
+
+func (c *ClusterUpgradeStatus) String() string {
+	buf := &bytes.Buffer{}
+
+	ready := "not ready"
+	if c.Ready {
+		ready = "ready"
+	}
+	fmt.Fprintf(buf, "    Kubernetes: %s (%s)\n", orNone(c.KubernetesVersion), ready)
+	if c.Message != "" {
+		fmt.Fprintf(buf, "    Message:    %s\n", c.Message)
+	}
+	if c.OSImage != "" {
+		fmt.Fprintf(buf, "    RancherOS:  %s\n", c.OSImage)
+	}
+	fmt.Fprintln(buf)
+
+	w := tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "NODE\tROLES\tKUBELET\tOS\tMACHINE\tPHASE\n")
+	for _, node := range c.Nodes {
+		kubelet := node.KubeletVersion
+		if node.TargetKubeletVersion != "" && node.KubeletVersion != node.TargetKubeletVersion {
+			kubelet += " => " + node.TargetKubeletVersion
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", node.Name, orNone(node.Roles), kubelet,
+			node.OSImage, orNone(node.Machine), node.Phase)
+	}
+	w.Flush()
+	return buf.String()
+}
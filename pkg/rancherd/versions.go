@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/rancher/rancherd/pkg/kubectl"
+	"github.com/rancher/rancherd/pkg/paths"
 	data2 "github.com/rancher/wrangler/pkg/data"
 	"github.com/rancher/wrangler/pkg/data/convert"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -85,7 +86,7 @@ func getK8sVersion(ctx context.Context, k8s kubernetes.Interface) string {
 }
 
 func getRancherOSVersion() string {
-	data, err := ioutil.ReadFile("/usr/lib/rancheros-release")
+	data, err := ioutil.ReadFile(paths.Host("/usr/lib/rancheros-release"))
 	if err != nil {
 		return ""
 	}
@@ -2,15 +2,15 @@ package registry
 
 import (
 	"encoding/base64"
-	"fmt"
 
 	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/runtime"
 	"github.com/rancher/system-agent/pkg/applyinator"
 	"github.com/rancher/wharfie/pkg/registries"
 	"sigs.k8s.io/yaml"
 )
 
-func ToFile(registry *registries.Registry, runtime config.Runtime) (*applyinator.File, error) {
+func ToFile(registry *registries.Registry, runtimeName config.Runtime) (*applyinator.File, error) {
 	if registry == nil {
 		return nil, nil
 	}
@@ -22,12 +22,12 @@ func ToFile(registry *registries.Registry, runtime config.Runtime) (*applyinator
 
 	return &applyinator.File{
 		Content:     base64.StdEncoding.EncodeToString(data),
-		Path:        GetConfigFile(runtime),
+		Path:        GetConfigFile(runtimeName),
 		Permissions: "0400",
 	}, nil
 
 }
 
-func GetConfigFile(runtime config.Runtime) string {
-	return fmt.Sprintf("/etc/rancher/%s/registries.yaml", runtime)
+func GetConfigFile(runtimeName config.Runtime) string {
+	return runtime.Get(runtimeName).RegistriesFile()
 }
@@ -0,0 +1,186 @@
+package reset
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/rancher/rancherd/pkg/cacerts"
+	"github.com/rancher/rancherd/pkg/kubectl"
+	"github.com/rancher/rancherd/pkg/paths"
+	"github.com/rancher/rancherd/pkg/plan"
+	"github.com/rancher/rancherd/pkg/resources"
+	"github.com/rancher/rancherd/pkg/runtime"
+	"github.com/rancher/system-agent/pkg/applyinator"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	systemAgentUninstallScript = "/usr/local/bin/rancher-system-agent-uninstall.sh"
+)
+
+type Options struct {
+	DataDir string
+	// NodeName is the name of this node in the cluster
+	NodeName string
+	// Drain cordons and drains this node and deletes it from the cluster before
+	// the runtime is uninstalled
+	Drain bool
+}
+
+// Reset reverses bootstrap: it uninstalls the runtime, removes all files written by
+// the plans and the rancherd data dir, leaving the host ready to be bootstrapped again
+func Reset(ctx context.Context, opts Options) error {
+	if opts.Drain {
+		if err := Drain(ctx, opts.NodeName); err != nil {
+			return err
+		}
+		if err := DeleteNode(ctx, opts.NodeName); err != nil {
+			return err
+		}
+	}
+
+	if err := runUninstallScripts(ctx); err != nil {
+		return err
+	}
+
+	files, err := planFiles(opts.DataDir)
+	if err != nil {
+		return err
+	}
+	files = append(files, resources.CattleIDFile(), cacerts.CAAnchorFile())
+
+	var caRemoved bool
+	for _, file := range files {
+		removed, err := remove(file)
+		if err != nil {
+			return err
+		}
+		if removed && file == cacerts.CAAnchorFile() {
+			caRemoved = true
+		}
+	}
+
+	if caRemoved {
+		if err := run(ctx, "update-ca-certificates"); err != nil {
+			logrus.Warnf("failed to update CA certificates after removing %s: %v", cacerts.CAAnchorFile(), err)
+		}
+	}
+
+	if err := os.RemoveAll(plan.GetUnsealedDir()); err != nil {
+		return err
+	}
+
+	logrus.Infof("Removing %s", opts.DataDir)
+	return os.RemoveAll(opts.DataDir)
+}
+
+// Drain cordons and drains nodeName using the kubeconfig of the local runtime
+func Drain(ctx context.Context, nodeName string) error {
+	logrus.Infof("Draining node %s", nodeName)
+	return runKubectl(ctx, "drain", nodeName, "--ignore-daemonsets", "--delete-emptydir-data", "--force", "--timeout=5m")
+}
+
+// DeleteNode deletes nodeName from the cluster using the kubeconfig of the local runtime
+func DeleteNode(ctx context.Context, nodeName string) error {
+	logrus.Infof("Deleting node %s", nodeName)
+	return runKubectl(ctx, "delete", "node", nodeName, "--ignore-not-found")
+}
+
+func runKubectl(ctx context.Context, args ...string) error {
+	if _, err := kubectl.GetKubeconfig(""); err != nil {
+		return fmt.Errorf("the node can only be drained on servers: %w", err)
+	}
+	runtimeName, err := kubectl.InstalledRuntime()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, kubectl.CommandForRuntime(runtimeName), args...)
+	cmd.Env = append(os.Environ(), kubectl.EnvForRuntime(runtimeName)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("kubectl %v: %w", args, err)
+	}
+	return nil
+}
+
+// uninstallScripts returns the groups of uninstall scripts, the system agent is uninstalled
+// before the runtimes
+func uninstallScripts() [][]string {
+	result := [][]string{
+		{paths.Host(systemAgentUninstallScript)},
+	}
+	for _, installed := range runtime.All() {
+		result = append(result, installed.UninstallScripts())
+	}
+	return result
+}
+
+// runUninstallScripts runs the scripts in order, only the first existing script of each group
+func runUninstallScripts(ctx context.Context) error {
+	for _, scripts := range uninstallScripts() {
+		for _, script := range scripts {
+			if _, err := os.Stat(script); err != nil {
+				continue
+			}
+			logrus.Infof("Running %s", script)
+			if err := run(ctx, script); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// planFiles returns the files written by all plans applied on this node
+func planFiles(dataDir string) ([]string, error) {
+	files, err := plan.GetWrittenFiles(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// nodes bootstrapped before all written files were recorded only have the last plan
+	data, err := ioutil.ReadFile(plan.GetPlanFile(dataDir))
+	if os.IsNotExist(err) {
+		return files, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var lastPlan applyinator.Plan
+	if err := json.Unmarshal(data, &lastPlan); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", plan.GetPlanFile(dataDir), err)
+	}
+	for _, file := range lastPlan.Files {
+		files = append(files, file.Path)
+	}
+	return files, nil
+}
+
+func remove(path string) (bool, error) {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	logrus.Infof("Removing %s", path)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+func run(ctx context.Context, command string, args ...string) error {
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %s: %w", command, err)
+	}
+	return nil
+}
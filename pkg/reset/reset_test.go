@@ -0,0 +1,120 @@
+package reset
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/rancherd/pkg/paths"
+)
+
+func TestPlanFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		recorded string
+		plan     string
+		expected []string
+	}{
+		{
+			name: "nothing applied",
+		},
+		{
+			name:     "recorded files",
+			recorded: "/etc/rancher/k3s/config.yaml\n/etc/rancher/k3s/registries.yaml\n",
+			expected: []string{"/etc/rancher/k3s/config.yaml", "/etc/rancher/k3s/registries.yaml"},
+		},
+		{
+			name:     "legacy plan.json",
+			plan:     `{"files": [{"path": "/etc/rancher/rke2/config.yaml.d/40-rancherd.yaml"}]}`,
+			expected: []string{"/etc/rancher/rke2/config.yaml.d/40-rancherd.yaml"},
+		},
+		{
+			name:     "recorded files and plan.json",
+			recorded: "/etc/rancher/k3s/config.yaml\n",
+			plan:     `{"files": [{"path": "/etc/rancher/k3s/config.yaml.d/40-rancherd.yaml"}]}`,
+			expected: []string{"/etc/rancher/k3s/config.yaml", "/etc/rancher/k3s/config.yaml.d/40-rancherd.yaml"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dataDir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dataDir, "plan"), 0700); err != nil {
+				t.Fatal(err)
+			}
+			if test.recorded != "" {
+				if err := ioutil.WriteFile(filepath.Join(dataDir, "plan", "files"), []byte(test.recorded), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.plan != "" {
+				if err := ioutil.WriteFile(filepath.Join(dataDir, "plan", "plan.json"), []byte(test.plan), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			files, err := planFiles(dataDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(files, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, files)
+			}
+		})
+	}
+}
+
+func TestPlanFilesInvalidPlan(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dataDir, "plan"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dataDir, "plan", "plan.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := planFiles(dataDir); err == nil {
+		t.Error("expected an error for an invalid plan.json")
+	}
+}
+
+func TestResetRoot(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv(paths.RootEnv, root)
+
+	write := func(path, content string, perm os.FileMode) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the plans write the files under the root and record them with the root
+	dataDir := filepath.Join(root, "/var/lib/rancher/rancherd")
+	configFile := filepath.Join(root, "/etc/rancher/k3s/config.yaml")
+	write(configFile, "", 0600)
+	write(filepath.Join(dataDir, "plan", "files"), configFile+"\n", 0600)
+	write(filepath.Join(root, "/run/rancherd/unsealed/token"), "", 0600)
+
+	uninstalled := filepath.Join(root, "uninstalled")
+	write(filepath.Join(root, "/usr/local/bin/k3s-uninstall.sh"), "#!/bin/sh\ntouch "+uninstalled+"\n", 0755)
+
+	if err := Reset(context.Background(), Options{DataDir: dataDir}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(uninstalled); err != nil {
+		t.Errorf("uninstall script under the root was not run: %v", err)
+	}
+	for _, path := range []string{configFile, filepath.Join(root, "/run/rancherd/unsealed"), dataDir} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed: %v", path, err)
+		}
+	}
+}
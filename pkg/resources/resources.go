@@ -15,28 +15,35 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 
 	"github.com/rancher/rancherd/pkg/config"
-	"github.com/rancher/rancherd/pkg/images"
 	"github.com/rancher/rancherd/pkg/kubectl"
+	"github.com/rancher/rancherd/pkg/paths"
+	rancherdruntime "github.com/rancher/rancherd/pkg/runtime"
 	"github.com/rancher/rancherd/pkg/self"
 	"github.com/rancher/rancherd/pkg/versions"
 )
 
 const (
 	localRKEStateSecretType = "rke.cattle.io/cluster-state"
+	cattleIDFile            = "/etc/rancher/agent/cattle-id"
 )
 
+// CattleIDFile returns the file identifying the node to Rancher
+func CattleIDFile() string {
+	return paths.Host(cattleIDFile)
+}
+
 func writeCattleID(id string) error {
-	if err := os.MkdirAll("/etc/rancher", 0755); err != nil {
+	if err := os.MkdirAll(paths.Host("/etc/rancher"), 0755); err != nil {
 		return fmt.Errorf("mkdir /etc/rancher: %w", err)
 	}
-	if err := os.MkdirAll("/etc/rancher/agent", 0700); err != nil {
+	if err := os.MkdirAll(paths.Host("/etc/rancher/agent"), 0700); err != nil {
 		return fmt.Errorf("mkdir /etc/rancher/agent: %w", err)
 	}
-	return ioutil.WriteFile("/etc/rancher/agent/cattle-id", []byte(id), 0400)
+	return ioutil.WriteFile(CattleIDFile(), []byte(id), 0400)
 }
 
 func getCattleID() (string, error) {
-	data, err := ioutil.ReadFile("/etc/rancher/agent/cattle-id")
+	data, err := ioutil.ReadFile(CattleIDFile())
 	if os.IsNotExist(err) {
 	} else if err != nil {
 		return "", err
@@ -175,6 +182,8 @@ func ToFile(resources []v1.GenericMap, path string) (*applyinator.File, error) {
 	return &applyinator.File{
 		Content: base64.StdEncoding.EncodeToString(data),
 		Path:    path,
+		// the bootstrap resources contain the cluster token
+		Permissions: "0600",
 	}, nil
 }
 
@@ -182,8 +191,8 @@ func GetBootstrapManifests(dataDir string) string {
 	return fmt.Sprintf("%s/bootstrapmanifests/rancherd.yaml", dataDir)
 }
 
-func ToInstruction(imageOverride, systemDefaultRegistry, k8sVersion, dataDir string) (*applyinator.Instruction, error) {
-	bootstrap := GetBootstrapManifests(dataDir)
+// ToInstruction applies the bootstrap manifests at the path bootstrap
+func ToInstruction(imageOverride, systemDefaultRegistry, k8sVersion, bootstrap string) (*applyinator.Instruction, error) {
 	cmd, err := self.Self()
 	if err != nil {
 		return nil, fmt.Errorf("resolving location of %s: %w", os.Args[0], err)
@@ -191,7 +200,7 @@ func ToInstruction(imageOverride, systemDefaultRegistry, k8sVersion, dataDir str
 	return &applyinator.Instruction{
 		Name:       "bootstrap",
 		SaveOutput: true,
-		Image:      images.GetInstallerImage(imageOverride, systemDefaultRegistry, k8sVersion),
+		Image:      rancherdruntime.InstallerImage(imageOverride, systemDefaultRegistry, k8sVersion),
 		Args:       []string{"retry", kubectl.Command(k8sVersion), "apply", "--validate=false", "-f", bootstrap},
 		Command:    cmd,
 		Env:        kubectl.Env(k8sVersion),
@@ -0,0 +1,54 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	// ErrNotInstalled is returned by Detect if no runtime is installed
+	ErrNotInstalled = errors.New("no Kubernetes runtime is installed")
+)
+
+// Detect returns the runtime installed on this node, found by its binaries and systemd
+// units. If the files of more than one runtime exist only the runtimes that also have a
+// data dir are considered, an error is returned if that still leaves more than one.
+func Detect() (Runtime, error) {
+	var installed []Runtime
+	for _, runtime := range runtimes {
+		if anyExists(runtime.InstalledFiles()...) {
+			installed = append(installed, runtime)
+		}
+	}
+
+	if len(installed) > 1 {
+		var withData []Runtime
+		for _, runtime := range installed {
+			if anyExists(runtime.DataDir()) {
+				withData = append(withData, runtime)
+			}
+		}
+		if len(withData) > 0 {
+			installed = withData
+		}
+	}
+
+	switch len(installed) {
+	case 0:
+		return nil, ErrNotInstalled
+	case 1:
+		return installed[0], nil
+	default:
+		return nil, fmt.Errorf("both %s and %s are installed", installed[0].Name(), installed[1].Name())
+	}
+}
+
+func anyExists(paths ...string) bool {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -5,21 +5,23 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/rancher/rancherd/pkg/config"
 	"github.com/rancher/rancherd/pkg/images"
-	"github.com/rancher/rancherd/pkg/kubectl"
 	"github.com/rancher/rancherd/pkg/self"
 	"github.com/rancher/system-agent/pkg/applyinator"
 )
 
+// InstallerImage returns the installer image of the Kubernetes version
+func InstallerImage(imageOverride, systemDefaultRegistry, k8sVersion string) string {
+	return images.GetInstallerImage(imageOverride, systemDefaultRegistry, ForVersion(k8sVersion).Name(), k8sVersion)
+}
+
 func ToInstruction(imageOverride string, systemDefaultRegistry string, k8sVersion string) (*applyinator.Instruction, error) {
-	runtime := config.GetRuntime(k8sVersion)
 	return &applyinator.Instruction{
-		Name: string(runtime),
+		Name: string(ForVersion(k8sVersion).Name()),
 		Env: []string{
-			"RESTART_STAMP=" + images.GetInstallerImage(imageOverride, systemDefaultRegistry, k8sVersion),
+			"RESTART_STAMP=" + InstallerImage(imageOverride, systemDefaultRegistry, k8sVersion),
 		},
-		Image:      images.GetInstallerImage(imageOverride, systemDefaultRegistry, k8sVersion),
+		Image:      InstallerImage(imageOverride, systemDefaultRegistry, k8sVersion),
 		SaveOutput: true,
 	}, nil
 }
@@ -37,11 +39,12 @@ func ToUpgradeInstruction(k8sVersion string) (*applyinator.Instruction, error) {
 	if err != nil {
 		return nil, err
 	}
+	runtime := ForVersion(k8sVersion)
 	return &applyinator.Instruction{
 		Name:       "patch-kubernetes-version",
 		SaveOutput: true,
-		Args:       []string{"retry", kubectl.Command(k8sVersion), "--type=merge", "-n", "fleet-local", "patch", "clusters.provisioning.cattle.io", "local", "-p", string(patch)},
-		Env:        kubectl.Env(k8sVersion),
+		Args:       []string{"retry", runtime.Kubectl(), "--type=merge", "-n", "fleet-local", "patch", "clusters.provisioning.cattle.io", "local", "-p", string(patch)},
+		Env:        KubectlEnv(runtime),
 		Command:    cmd,
 	}, nil
 }
@@ -0,0 +1,97 @@
+package runtime
+
+import (
+	"strings"
+
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/paths"
+)
+
+type k3s struct{}
+
+func (k3s) Name() config.Runtime {
+	return config.RuntimeK3S
+}
+
+func (k3s) Matches(k8sVersion string) bool {
+	return strings.Contains(k8sVersion, "+k3s")
+}
+
+func (k3s) ConfigFile() string {
+	return paths.Host("/etc/rancher/k3s/config.yaml")
+}
+
+func (k3s) ConfigDropInDir() string {
+	return paths.Host("/etc/rancher/k3s/config.yaml.d")
+}
+
+func (k3s) RegistriesFile() string {
+	return paths.Host("/etc/rancher/k3s/registries.yaml")
+}
+
+func (k3s) Kubeconfig() string {
+	return paths.Host("/etc/rancher/k3s/k3s.yaml")
+}
+
+func (k3s) Kubectl() string {
+	return paths.Host("/usr/local/bin/kubectl")
+}
+
+func (k3s) APIServerTLS() TLSFiles {
+	return TLSFiles{
+		CACert:     paths.Host("/var/lib/rancher/k3s/server/tls/server-ca.crt"),
+		ClientCert: paths.Host("/var/lib/rancher/k3s/server/tls/client-kube-apiserver.crt"),
+		ClientKey:  paths.Host("/var/lib/rancher/k3s/server/tls/client-kube-apiserver.key"),
+	}
+}
+
+// BootstrapConfig enables the embedded etcd, k3s defaults to sqlite without it
+func (k3s) BootstrapConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"cluster-init": "true",
+	}
+}
+
+// ServerPorts is empty as the k3s supervisor shares the port of the kube-apiserver
+func (k3s) ServerPorts() []int {
+	return nil
+}
+
+func (k3s) Binaries() []string {
+	return paths.HostAll("/usr/local/bin/k3s")
+}
+
+func (k3s) InstalledFiles() []string {
+	return paths.HostAll(
+		"/usr/local/bin/k3s",
+		"/etc/systemd/system/k3s.service",
+		"/etc/systemd/system/k3s-agent.service",
+	)
+}
+
+func (k3s) DataDir() string {
+	return paths.Host("/var/lib/rancher/k3s")
+}
+
+func (k3s) ServerService() string {
+	return "k3s"
+}
+
+func (k3s) UninstallScripts() []string {
+	return paths.HostAll(
+		"/usr/local/bin/k3s-uninstall.sh",
+		"/usr/local/bin/k3s-agent-uninstall.sh",
+	)
+}
+
+func (k3s) SnapshotDir() string {
+	return paths.Host("/var/lib/rancher/k3s/server/db/snapshots")
+}
+
+func (k3s) SnapshotSaveArgs(name, dir string) []string {
+	return []string{"etcd-snapshot", "save", "--name", name, "--etcd-snapshot-dir", dir}
+}
+
+func (k3s) SnapshotRestoreArgs(path string) []string {
+	return []string{"server", "--cluster-reset", "--cluster-reset-restore-path=" + path}
+}
@@ -0,0 +1,73 @@
+package runtime
+
+import (
+	"github.com/rancher/system-agent/pkg/prober"
+)
+
+const (
+	// APIServerProbe is the name of the probe of the kube-apiserver
+	APIServerProbe = "kube-apiserver"
+	// KubeletProbe is the name of the probe of the kubelet
+	KubeletProbe = "kubelet"
+)
+
+var probes = map[string]prober.Probe{
+	APIServerProbe: {
+		InitialDelaySeconds: 1,
+		TimeoutSeconds:      5,
+		SuccessThreshold:    1,
+		FailureThreshold:    2,
+		HTTPGetAction: prober.HTTPGetAction{
+			URL: "https://127.0.0.1:6443/readyz",
+		},
+	},
+	"kube-scheduler": {
+		InitialDelaySeconds: 1,
+		TimeoutSeconds:      5,
+		SuccessThreshold:    1,
+		FailureThreshold:    2,
+		HTTPGetAction: prober.HTTPGetAction{
+			URL:      "https://127.0.0.1:10259/healthz",
+			Insecure: true,
+		},
+	},
+	"kube-controller-manager": {
+		InitialDelaySeconds: 1,
+		TimeoutSeconds:      5,
+		SuccessThreshold:    1,
+		FailureThreshold:    2,
+		HTTPGetAction: prober.HTTPGetAction{
+			URL:      "https://127.0.0.1:10257/healthz",
+			Insecure: true,
+		},
+	},
+	KubeletProbe: {
+		InitialDelaySeconds: 1,
+		TimeoutSeconds:      5,
+		SuccessThreshold:    1,
+		FailureThreshold:    2,
+		HTTPGetAction: prober.HTTPGetAction{
+			URL: "http://127.0.0.1:10248/healthz",
+		},
+	},
+}
+
+// Probes returns the probes of the Kubernetes components of a server of runtime. The
+// kube-apiserver probe needs the client certificate of the runtime and is left out if
+// runtime is nil.
+func Probes(runtime Runtime) map[string]prober.Probe {
+	result := map[string]prober.Probe{}
+	for name, probe := range probes {
+		if name == APIServerProbe {
+			if runtime == nil {
+				continue
+			}
+			tls := runtime.APIServerTLS()
+			probe.HTTPGetAction.CACert = tls.CACert
+			probe.HTTPGetAction.ClientCert = tls.ClientCert
+			probe.HTTPGetAction.ClientKey = tls.ClientKey
+		}
+		result[name] = probe
+	}
+	return result
+}
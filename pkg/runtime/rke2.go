@@ -0,0 +1,96 @@
+package runtime
+
+import (
+	"strings"
+
+	"github.com/rancher/rancherd/pkg/config"
+	"github.com/rancher/rancherd/pkg/paths"
+)
+
+type rke2 struct{}
+
+func (rke2) Name() config.Runtime {
+	return config.RuntimeRKE2
+}
+
+func (rke2) Matches(k8sVersion string) bool {
+	return strings.Contains(k8sVersion, "+rke2")
+}
+
+func (rke2) ConfigFile() string {
+	return paths.Host("/etc/rancher/rke2/config.yaml")
+}
+
+func (rke2) ConfigDropInDir() string {
+	return paths.Host("/etc/rancher/rke2/config.yaml.d")
+}
+
+func (rke2) RegistriesFile() string {
+	return paths.Host("/etc/rancher/rke2/registries.yaml")
+}
+
+func (rke2) Kubeconfig() string {
+	return paths.Host("/etc/rancher/rke2/rke2.yaml")
+}
+
+func (rke2) Kubectl() string {
+	return paths.Host("/var/lib/rancher/rke2/bin/kubectl")
+}
+
+func (rke2) APIServerTLS() TLSFiles {
+	return TLSFiles{
+		CACert:     paths.Host("/var/lib/rancher/rke2/server/tls/server-ca.crt"),
+		ClientCert: paths.Host("/var/lib/rancher/rke2/server/tls/client-kube-apiserver.crt"),
+		ClientKey:  paths.Host("/var/lib/rancher/rke2/server/tls/client-kube-apiserver.key"),
+	}
+}
+
+// BootstrapConfig is nil as rke2 always runs etcd
+func (rke2) BootstrapConfig() map[string]interface{} {
+	return nil
+}
+
+// ServerPorts is the port of the rke2 supervisor
+func (rke2) ServerPorts() []int {
+	return []int{9345}
+}
+
+func (rke2) Binaries() []string {
+	return paths.HostAll("/usr/local/bin/rke2", "/opt/rke2/bin/rke2")
+}
+
+func (rke2) InstalledFiles() []string {
+	return paths.HostAll(
+		"/usr/local/bin/rke2",
+		"/opt/rke2/bin/rke2",
+		"/usr/local/lib/systemd/system/rke2-server.service",
+		"/usr/local/lib/systemd/system/rke2-agent.service",
+	)
+}
+
+func (rke2) DataDir() string {
+	return paths.Host("/var/lib/rancher/rke2")
+}
+
+func (rke2) ServerService() string {
+	return "rke2-server"
+}
+
+func (rke2) UninstallScripts() []string {
+	return paths.HostAll(
+		"/usr/local/bin/rke2-uninstall.sh",
+		"/opt/rke2/bin/rke2-uninstall.sh",
+	)
+}
+
+func (rke2) SnapshotDir() string {
+	return paths.Host("/var/lib/rancher/rke2/server/db/snapshots")
+}
+
+func (rke2) SnapshotSaveArgs(name, dir string) []string {
+	return []string{"etcd-snapshot", "save", "--name", name, "--etcd-snapshot-dir", dir}
+}
+
+func (rke2) SnapshotRestoreArgs(path string) []string {
+	return []string{"server", "--cluster-reset", "--cluster-reset-restore-path=" + path}
+}
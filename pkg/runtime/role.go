@@ -3,7 +3,7 @@ package runtime
 import (
 	"encoding/base64"
 	"encoding/json"
-	"fmt"
+	"path/filepath"
 	"strings"
 
 	"github.com/rancher/rancherd/pkg/config"
@@ -23,12 +23,11 @@ var (
 )
 
 func ToBootstrapFile(runtime config.Runtime) (*applyinator.File, error) {
-	if runtime != config.RuntimeK3S {
+	bootstrapConfig := Get(runtime).BootstrapConfig()
+	if bootstrapConfig == nil {
 		return nil, nil
 	}
-	data, err := json.Marshal(map[string]interface{}{
-		"cluster-init": "true",
-	})
+	data, err := json.Marshal(bootstrapConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -46,6 +45,8 @@ func ToFile(config *config.RuntimeConfig, runtime config.Runtime, clusterInit bo
 	return &applyinator.File{
 		Content: base64.StdEncoding.EncodeToString(data),
 		Path:    GetConfigLocation(runtime),
+		// contains the cluster token
+		Permissions: "0600",
 	}, nil
 }
 
@@ -84,9 +85,9 @@ func ToConfig(config *config.RuntimeConfig, clusterInit bool) ([]byte, error) {
 }
 
 func GetConfigLocation(runtime config.Runtime) string {
-	return fmt.Sprintf("/etc/rancher/%s/config.yaml.d/40-rancherd.yaml", runtime)
+	return filepath.Join(Get(runtime).ConfigDropInDir(), "40-rancherd.yaml")
 }
 
 func GetRancherConfigLocation(runtime config.Runtime) string {
-	return fmt.Sprintf("/etc/rancher/%s/config.yaml.d/50-rancher.yaml", runtime)
+	return filepath.Join(Get(runtime).ConfigDropInDir(), "50-rancher.yaml")
 }
@@ -0,0 +1,99 @@
+package runtime
+
+import (
+	"github.com/rancher/rancherd/pkg/config"
+)
+
+var (
+	// runtimes are all known runtimes
+	runtimes = []Runtime{
+		k3s{},
+		rke2{},
+	}
+)
+
+// Runtime is a Kubernetes distribution installed by the runtime installer image. All
+// paths are resolved on the host.
+type Runtime interface {
+	// Name is the name of the runtime, as used in the installer image and its paths
+	Name() config.Runtime
+	// Matches returns true if the Kubernetes version is a version of the runtime, which
+	// has the name of the runtime as build metadata, e.g. v1.22.3+k3s1
+	Matches(k8sVersion string) bool
+	// ConfigFile is the main config file of the runtime
+	ConfigFile() string
+	// ConfigDropInDir is the dir of the config files merged into the main config file
+	ConfigDropInDir() string
+	// RegistriesFile is the private registry config of the runtime
+	RegistriesFile() string
+	// Kubeconfig is the admin kubeconfig written by servers
+	Kubeconfig() string
+	// Kubectl is the kubectl binary installed with the runtime
+	Kubectl() string
+	// APIServerTLS are the files to connect to the local kube-apiserver as a client
+	APIServerTLS() TLSFiles
+	// BootstrapConfig is the runtime config the first server of the cluster needs, nil
+	// if none is needed
+	BootstrapConfig() map[string]interface{}
+	// ServerPorts are the ports servers listen on besides the ports of Kubernetes
+	ServerPorts() []int
+	// Binaries are the possible locations of the runtime binary
+	Binaries() []string
+	// InstalledFiles are files only present if the runtime is installed
+	InstalledFiles() []string
+	// DataDir is the dir the runtime keeps its state in
+	DataDir() string
+	// ServerService is the systemd unit of servers
+	ServerService() string
+	// UninstallScripts are the possible uninstall scripts, only the first existing
+	// script is run
+	UninstallScripts() []string
+	// SnapshotDir is the default dir of the etcd snapshots
+	SnapshotDir() string
+	// SnapshotSaveArgs are the arguments of the runtime binary that save an etcd snapshot
+	// called name to dir
+	SnapshotSaveArgs(name, dir string) []string
+	// SnapshotRestoreArgs are the arguments of the runtime binary that reset the cluster
+	// to the etcd snapshot at path
+	SnapshotRestoreArgs(path string) []string
+}
+
+// TLSFiles are the CA and client certificate of a TLS client
+type TLSFiles struct {
+	CACert     string
+	ClientCert string
+	ClientKey  string
+}
+
+// All returns all known runtimes
+func All() []Runtime {
+	return runtimes
+}
+
+// Get returns the runtime with name, nil if the runtime is not known
+func Get(name config.Runtime) Runtime {
+	for _, runtime := range runtimes {
+		if runtime.Name() == name {
+			return runtime
+		}
+	}
+	return nil
+}
+
+// ForVersion returns the runtime of the Kubernetes version, k3s if the version is not of
+// any known runtime
+func ForVersion(k8sVersion string) Runtime {
+	for _, runtime := range runtimes {
+		if runtime.Matches(k8sVersion) {
+			return runtime
+		}
+	}
+	return k3s{}
+}
+
+// KubectlEnv returns the environment for the kubectl of runtime to use its kubeconfig
+func KubectlEnv(runtime Runtime) []string {
+	return []string{
+		"KUBECONFIG=" + runtime.Kubeconfig(),
+	}
+}
@@ -0,0 +1,23 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/rancher/rancherd/pkg/config"
+)
+
+func TestForVersion(t *testing.T) {
+	tests := map[string]config.Runtime{
+		"v1.22.3+k3s1":   config.RuntimeK3S,
+		"v1.22.3+rke2r1": config.RuntimeRKE2,
+		// only the build metadata names the runtime
+		"v1.22.3-k3s.rc1+rke2r1": config.RuntimeRKE2,
+		"":                       config.RuntimeK3S,
+		"v1.22.3":                config.RuntimeK3S,
+	}
+	for version, expected := range tests {
+		if runtime := ForVersion(version).Name(); runtime != expected {
+			t.Errorf("runtime of %q = %s, expected %s", version, runtime, expected)
+		}
+	}
+}
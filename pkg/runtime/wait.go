@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/rancher/rancherd/pkg/kubectl"
 	"github.com/rancher/rancherd/pkg/self"
 	"github.com/rancher/system-agent/pkg/applyinator"
 )
@@ -14,12 +13,13 @@ func ToWaitKubernetesInstruction(imageOverride, systemDefaultRegistry, k8sVersio
 	if err != nil {
 		return nil, fmt.Errorf("resolving location of %s: %w", os.Args[0], err)
 	}
+	runtime := ForVersion(k8sVersion)
 	return &applyinator.Instruction{
 		Name:       "wait-kubernetes-provisioned",
 		SaveOutput: true,
-		Args: []string{"retry", kubectl.Command(k8sVersion), "-n", "fleet-local", "wait",
+		Args: []string{"retry", runtime.Kubectl(), "-n", "fleet-local", "wait",
 			"--for=condition=Provisioned=true", "clusters.provisioning.cattle.io", "local"},
-		Env:     kubectl.Env(k8sVersion),
+		Env:     KubectlEnv(runtime),
 		Command: cmd,
 	}, nil
 }
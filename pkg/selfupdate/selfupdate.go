@@ -0,0 +1,290 @@
+package selfupdate
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/rancher/rancherd/pkg/bundle"
+	"github.com/rancher/rancherd/pkg/images"
+	"github.com/rancher/rancherd/pkg/self"
+	"github.com/rancher/system-agent/pkg/image"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	binaryName = "rancherd"
+	// signatureName is the cosign sign-blob signature of the binary
+	signatureName = binaryName + ".sig"
+	// PrevSuffix is appended to the path of the binary to keep the previous binary
+	PrevSuffix = ".prev"
+)
+
+type Options struct {
+	// Version of rancherd to install from the installer image
+	Version string
+	// Image overrides the installer image of Version
+	Image                 string
+	SystemDefaultRegistry string
+	RegistriesFile        string
+	// File is a local rancherd binary to install instead of an image
+	File string
+	// Checksum is the expected SHA256 of the binary. Checksum or PublicKey is required.
+	Checksum string
+	// PublicKey is a PEM ECDSA public key, if set the binary must have a valid cosign
+	// sign-blob signature, either rancherd.sig of the image or Signature
+	PublicKey string
+	Signature string
+	// Insecure allows a binary from an image without Checksum and PublicKey, it is then
+	// only verified against the sha256sum-<arch>.txt file of the same image
+	Insecure bool
+}
+
+// Update verifies the new rancherd binary and atomically replaces the running binary
+// with it. The running binary is kept with the PrevSuffix for Rollback.
+func Update(opts Options) (string, error) {
+	target, err := self.Self()
+	if err != nil {
+		return "", fmt.Errorf("resolving location of %s: %w", os.Args[0], err)
+	}
+	if target, err = filepath.EvalSymlinks(target); err != nil {
+		return "", err
+	}
+
+	tmpDir, err := ioutil.TempDir(filepath.Dir(target), ".rancherd-update")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	binary, signature, checksums, err := fetch(tmpDir, opts)
+	if err != nil {
+		return "", err
+	}
+
+	if err := verify(binary, signature, checksums, opts); err != nil {
+		return "", err
+	}
+
+	if err := install(binary, target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// Rollback atomically replaces the running binary with the binary it replaced
+func Rollback() (string, error) {
+	target, err := self.Self()
+	if err != nil {
+		return "", fmt.Errorf("resolving location of %s: %w", os.Args[0], err)
+	}
+	if target, err = filepath.EvalSymlinks(target); err != nil {
+		return "", err
+	}
+
+	prev := target + PrevSuffix
+	if _, err := os.Stat(prev); err != nil {
+		return "", fmt.Errorf("no previous binary to roll back to: %w", err)
+	}
+	if err := os.Rename(prev, target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// fetch returns the path of the new binary and, if available, of its signature and checksums
+func fetch(dir string, opts Options) (binary, signature, checksums string, err error) {
+	if opts.File != "" {
+		if opts.Checksum == "" && opts.PublicKey == "" {
+			return "", "", "", fmt.Errorf("a checksum or a public key is required to verify %s", opts.File)
+		}
+		return opts.File, opts.Signature, "", nil
+	}
+
+	installerImage := images.GetRancherdInstallerImage(opts.Image, opts.SystemDefaultRegistry, opts.Version)
+	logrus.Infof("Extracting rancherd from %s", installerImage)
+	if err := image.NewUtility(bundle.ImagesDir(), "", "", opts.RegistriesFile).Stage(dir, installerImage); err != nil {
+		return "", "", "", err
+	}
+
+	binary = filepath.Join(dir, binaryName)
+	if _, err := os.Stat(binary); err != nil {
+		return "", "", "", fmt.Errorf("%s does not contain %s: %w", installerImage, binaryName, err)
+	}
+
+	signature = opts.Signature
+	if signature == "" {
+		if _, err := os.Stat(filepath.Join(dir, signatureName)); err == nil {
+			signature = filepath.Join(dir, signatureName)
+		}
+	}
+
+	checksums = filepath.Join(dir, fmt.Sprintf("sha256sum-%s.txt", runtime.GOARCH))
+	if _, err := os.Stat(checksums); err != nil {
+		checksums = ""
+	}
+	return binary, signature, checksums, nil
+}
+
+func verify(binary, signature, checksums string, opts Options) error {
+	digest, err := sha256File(binary)
+	if err != nil {
+		return err
+	}
+	actual := hex.EncodeToString(digest)
+
+	expected := opts.Checksum
+	if expected == "" && opts.PublicKey == "" {
+		// the checksums of the image only show that the binary is not corrupted, not
+		// that the image comes from a trusted source
+		if !opts.Insecure || checksums == "" {
+			return fmt.Errorf("a checksum or a public key is required to verify the new binary")
+		}
+		if expected, err = checksumFromFile(checksums); err != nil {
+			return err
+		}
+		logrus.Warnf("INSECURE: the new binary is only verified against the checksums of its own image, " +
+			"use a checksum or a public key to verify that it comes from a trusted source")
+	}
+	if expected != "" && !strings.EqualFold(expected, actual) {
+		return fmt.Errorf("sha256 of the new binary does not match %s, got %s", expected, actual)
+	}
+
+	if opts.PublicKey != "" {
+		if signature == "" {
+			return fmt.Errorf("a signature is required to verify the new binary with %s", opts.PublicKey)
+		}
+		if err := verifySignature(digest, signature, opts.PublicKey); err != nil {
+			return err
+		}
+	}
+
+	// make sure the new binary runs on this machine before replacing the running one
+	if err := os.Chmod(binary, 0755); err != nil {
+		return err
+	}
+	if out, err := exec.Command(binary, "--help").CombinedOutput(); err != nil {
+		return fmt.Errorf("new binary failed to run: %s: %w", out, err)
+	}
+	return nil
+}
+
+func verifySignature(digest []byte, signatureFile, publicKeyFile string) error {
+	keyData, err := ioutil.ReadFile(publicKeyFile)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return fmt.Errorf("%s is not a PEM public key", publicKeyFile)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", publicKeyFile, err)
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%s is not an ECDSA public key", publicKeyFile)
+	}
+
+	sigData, err := ioutil.ReadFile(signatureFile)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigData)))
+	if err != nil {
+		return fmt.Errorf("decoding signature %s: %w", signatureFile, err)
+	}
+
+	if !ecdsa.VerifyASN1(ecKey, digest, sig) {
+		return fmt.Errorf("invalid signature of the new binary")
+	}
+	return nil
+}
+
+// install copies binary next to target and renames it over target, so a failure never
+// leaves a partial binary. The replaced binary is kept with the PrevSuffix.
+func install(binary, target string) error {
+	next := target + ".next"
+	if err := copyFile(binary, next, 0755); err != nil {
+		return err
+	}
+	defer os.Remove(next)
+
+	prev := target + PrevSuffix
+	if err := copyFile(target, prev, 0755); err != nil {
+		return fmt.Errorf("keeping previous binary: %w", err)
+	}
+
+	return os.Rename(next, target)
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, mode)
+}
+
+func sha256File(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// checksumFromFile returns the checksum of rancherd from a sha256sum file, the name
+// is prefixed with * for checksums of files read in binary mode
+func checksumFromFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		fields := strings.Fields(scan.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == binaryName {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("%s has no checksum for %s", path, binaryName)
+}
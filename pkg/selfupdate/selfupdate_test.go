@@ -0,0 +1,70 @@
+package selfupdate
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestChecksumFromFile(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "text mode",
+			content:  "aaaa  rancherd-arm64\nbbbb  rancherd\n",
+			expected: "bbbb",
+		},
+		{
+			name:     "binary mode",
+			content:  "aaaa *rancherd.sig\nbbbb *rancherd\n",
+			expected: "bbbb",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checksum, err := checksumFromFile(writeFile(t, dir, "sha256sum.txt", test.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if checksum != test.expected {
+				t.Errorf("checksum = %q, expected %q", checksum, test.expected)
+			}
+		})
+	}
+
+	if _, err := checksumFromFile(writeFile(t, dir, "other.txt", "aaaa  rancherd-amd64\n")); err == nil {
+		t.Errorf("expected no checksum for another binary")
+	}
+}
+
+func TestVerifyRequiresTrustedSource(t *testing.T) {
+	dir := t.TempDir()
+	binary := writeFile(t, dir, binaryName, "binary")
+	checksums := writeFile(t, dir, "sha256sum-amd64.txt",
+		"aaaa  rancherd\n")
+
+	if err := verify(binary, "", checksums, Options{}); err == nil {
+		t.Errorf("expected the checksums of the image not to be enough without --insecure")
+	}
+	if err := verify(binary, "", "", Options{Insecure: true}); err == nil {
+		t.Errorf("expected an error without checksums")
+	}
+	if err := verify(binary, "", checksums, Options{Checksum: "0000"}); err == nil {
+		t.Errorf("expected a wrong checksum to be rejected")
+	}
+}
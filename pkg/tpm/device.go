@@ -0,0 +1,310 @@
+package tpm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm/tpm2"
+)
+
+const (
+	// DeviceHardware uses the TPM exposed by the kernel at /dev/tpmrm0 or /dev/tpm0
+	DeviceHardware = "hardware"
+	// DeviceSimulator uses a TPM simulator speaking the Microsoft simulator protocol,
+	// such as ms-tpm-20-ref or "swtpm socket --tpm2"
+	DeviceSimulator = "simulator"
+
+	// DeviceEnv selects the device type, it overrides the tpm.device config
+	DeviceEnv = "RANCHERD_TPM_DEVICE"
+	// SimulatorAddressEnv is the command address of the simulator, the platform
+	// address is expected on the next port
+	SimulatorAddressEnv = "RANCHERD_TPM_SIMULATOR_ADDRESS"
+
+	defaultSimulatorAddress = "127.0.0.1:2321"
+
+	// Commands of the Microsoft simulator protocol, "D.3.2. Typedefs and Defines"
+	simulatorPowerOn     uint32 = 1
+	simulatorSendCommand uint32 = 8
+	simulatorNVOn        uint32 = 11
+	simulatorSessionEnd  uint32 = 20
+)
+
+// Device opens connections to a TPM 2.0. Every call opens a new connection
+// that must be closed by the caller.
+type Device interface {
+	// Attest opens the TPM for use with go-attestation
+	Attest() (*attest.TPM, error)
+	// Raw opens the TPM as a raw command channel for use with go-tpm
+	Raw() (io.ReadWriteCloser, error)
+}
+
+// Options configure how the TPM is used by this package
+type Options struct {
+	// Device is one of DeviceHardware or DeviceSimulator
+	Device string
+	// SimulatorAddress is the command address of the simulator
+	SimulatorAddress string
+	// Quote includes a quote of the PCRs and the event log when attesting to the
+	// machine inventory, even if the server does not ask for it
+	Quote bool
+	// EKAlgorithm is the algorithm of the EK that identifies the machine, EKAlgorithmRSA
+	// or EKAlgorithmECC, if the TPM has EKs of both
+	EKAlgorithm string
+}
+
+// NewDevice returns a Device of the given kind, an empty kind defaults to DeviceHardware
+func NewDevice(kind, simulatorAddress string) (Device, error) {
+	switch kind {
+	case "", DeviceHardware:
+		return hardware{}, nil
+	case DeviceSimulator:
+		return NewSimulator(simulatorAddress)
+	default:
+		return nil, fmt.Errorf("unknown TPM device %q, must be %s or %s", kind, DeviceHardware, DeviceSimulator)
+	}
+}
+
+// deviceFromEnv returns the device kind and simulator address of opts with the
+// environment variables applied
+func deviceFromEnv(opts Options) (string, string) {
+	kind, simulatorAddress := opts.Device, opts.SimulatorAddress
+	if env := os.Getenv(DeviceEnv); env != "" {
+		kind = env
+	}
+	if env := os.Getenv(SimulatorAddressEnv); env != "" {
+		simulatorAddress = env
+	}
+	if kind == "" && simulatorAddress != "" {
+		kind = DeviceSimulator
+	}
+	return kind, simulatorAddress
+}
+
+type hardware struct{}
+
+func (hardware) Attest() (*attest.TPM, error) {
+	tpm, err := attest.OpenTPM(&attest.OpenConfig{
+		TPMVersion: attest.TPMVersion20,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opening tpm: %w", err)
+	}
+	return tpm, nil
+}
+
+func (hardware) Raw() (io.ReadWriteCloser, error) {
+	var lastErr error
+	for _, path := range devicePaths {
+		rw, err := tpm2.OpenTPM(path)
+		if err == nil {
+			return rw, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("opening tpm: %w", lastErr)
+}
+
+// Simulator is a Device backed by a TPM simulator speaking the Microsoft simulator
+// protocol. A simulator that is already running is used as is, so the PCRs and the
+// loaded objects are kept between connections. A simulator that is powered off is
+// powered on and started.
+type Simulator struct {
+	commandAddress  string
+	platformAddress string
+
+	startLock sync.Mutex
+	started   bool
+}
+
+// NewSimulator returns a simulator Device connecting to the command port at address.
+// An empty address defaults to 127.0.0.1:2321.
+func NewSimulator(address string) (*Simulator, error) {
+	if address == "" {
+		address = defaultSimulatorAddress
+	}
+	platform, err := platformAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return &Simulator{
+		commandAddress:  address,
+		platformAddress: platform,
+	}, nil
+}
+
+func (s *Simulator) Attest() (*attest.TPM, error) {
+	rw, err := s.Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	tpm, err := attest.OpenTPM(&attest.OpenConfig{
+		TPMVersion:     attest.TPMVersion20,
+		CommandChannel: CommandChannel(rw),
+	})
+	if err != nil {
+		rw.Close()
+		return nil, fmt.Errorf("opening tpm simulator: %w", err)
+	}
+	return tpm, nil
+}
+
+func (s *Simulator) Raw() (io.ReadWriteCloser, error) {
+	conn, err := net.Dial("tcp", s.commandAddress)
+	if err != nil {
+		return nil, fmt.Errorf("opening tpm simulator: %w", err)
+	}
+	rw := &simulatorConn{conn: conn}
+
+	if err := s.start(rw); err != nil {
+		rw.Close()
+		return nil, err
+	}
+	return rw, nil
+}
+
+// start runs TPM2_Startup once, powering on the simulator if it is not running
+func (s *Simulator) start(rw io.ReadWriter) error {
+	s.startLock.Lock()
+	defer s.startLock.Unlock()
+
+	if s.started {
+		return nil
+	}
+
+	err := startup(rw)
+	if err != nil {
+		if err := s.powerOn(); err != nil {
+			return err
+		}
+		err = startup(rw)
+	}
+	if err != nil {
+		return fmt.Errorf("starting tpm simulator: %w", err)
+	}
+
+	s.started = true
+	return nil
+}
+
+// powerOn signals power on to the simulator, unlike mssim.Open it does not power
+// cycle a simulator that is already on
+func (s *Simulator) powerOn() error {
+	conn, err := net.Dial("tcp", s.platformAddress)
+	if err != nil {
+		return fmt.Errorf("opening tpm simulator platform: %w", err)
+	}
+	defer conn.Close()
+
+	for _, signal := range []uint32{simulatorPowerOn, simulatorNVOn} {
+		if err := binary.Write(conn, binary.BigEndian, signal); err != nil {
+			return fmt.Errorf("powering on tpm simulator: %w", err)
+		}
+		var rc uint32
+		if err := binary.Read(conn, binary.BigEndian, &rc); err != nil {
+			return fmt.Errorf("powering on tpm simulator: %w", err)
+		}
+		if rc != 0 {
+			return fmt.Errorf("powering on tpm simulator: platform response %#x", rc)
+		}
+	}
+	return binary.Write(conn, binary.BigEndian, simulatorSessionEnd)
+}
+
+// startup runs TPM2_Startup, a TPM that is already started is not an error
+func startup(rw io.ReadWriter) error {
+	err := tpm2.Startup(rw, tpm2.StartupClear)
+	var tpmErr tpm2.Error
+	if errors.As(err, &tpmErr) && tpmErr.Code == tpm2.RCInitialize {
+		return nil
+	}
+	return err
+}
+
+// simulatorConn frames TPM commands for the command port of the simulator
+type simulatorConn struct {
+	conn net.Conn
+	resp *bytes.Reader
+}
+
+func (c *simulatorConn) Write(b []byte) (int, error) {
+	buf := &bytes.Buffer{}
+	_ = binary.Write(buf, binary.BigEndian, simulatorSendCommand)
+	// locality 0
+	buf.WriteByte(0)
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(b)))
+	buf.Write(b)
+
+	if _, err := buf.WriteTo(c.conn); err != nil {
+		return 0, fmt.Errorf("writing tpm simulator command: %w", err)
+	}
+	return len(b), nil
+}
+
+func (c *simulatorConn) Read(b []byte) (int, error) {
+	if c.resp != nil && c.resp.Len() > 0 {
+		return c.resp.Read(b)
+	}
+
+	var size uint32
+	if err := binary.Read(c.conn, binary.BigEndian, &size); err != nil {
+		return 0, fmt.Errorf("reading tpm simulator response: %w", err)
+	}
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(c.conn, resp); err != nil {
+		return 0, fmt.Errorf("reading tpm simulator response: %w", err)
+	}
+	var rc uint32
+	if err := binary.Read(c.conn, binary.BigEndian, &rc); err != nil {
+		return 0, fmt.Errorf("reading tpm simulator response: %w", err)
+	}
+	if rc != 0 {
+		return 0, fmt.Errorf("tpm simulator response code %#x", rc)
+	}
+
+	c.resp = bytes.NewReader(resp)
+	return c.resp.Read(b)
+}
+
+func (c *simulatorConn) Close() error {
+	// ends the session so the simulator accepts the next connection
+	err := binary.Write(c.conn, binary.BigEndian, simulatorSessionEnd)
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// CommandChannel adapts a raw connection to a TPM without firmware, such as a
+// simulator, to attest.CommandChannelTPM20. There is no measurement log.
+func CommandChannel(rw io.ReadWriteCloser) attest.CommandChannelTPM20 {
+	return commandChannel{ReadWriteCloser: rw}
+}
+
+type commandChannel struct {
+	io.ReadWriteCloser
+}
+
+func (commandChannel) MeasurementLog() ([]byte, error) {
+	return nil, nil
+}
+
+func platformAddress(address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid simulator address %q: %w", address, err)
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return "", fmt.Errorf("invalid simulator port %q: %w", port, err)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(portNum+1)), nil
+}
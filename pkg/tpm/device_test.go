@@ -0,0 +1,268 @@
+package tpm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+const (
+	ccStartup   = 0x144
+	ccGetRandom = 0x17b
+
+	rcSuccess    = 0x000
+	rcInitialize = 0x100
+	rcFailure    = 0x101
+)
+
+var fakeRandom = []byte("0123456789abcdef")
+
+// fakeSimulator speaks the Microsoft simulator protocol on a command and a platform
+// port. It answers TPM2_Startup and TPM2_GetRandom, which does not need cgo unlike the
+// simulator of tpmtest.
+type fakeSimulator struct {
+	address string
+
+	lock      sync.Mutex
+	poweredOn bool
+	started   bool
+	powerOns  int
+	startups  int
+}
+
+func newFakeSimulator(t *testing.T) *fakeSimulator {
+	t.Helper()
+
+	// the platform port is the port after the command port
+	for i := 0; i < 10; i++ {
+		command, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := command.Addr().(*net.TCPAddr).Port
+		platform, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port+1)))
+		if err != nil {
+			command.Close()
+			continue
+		}
+		t.Cleanup(func() {
+			command.Close()
+			platform.Close()
+		})
+
+		f := &fakeSimulator{address: command.Addr().String()}
+		go f.serve(command, f.command)
+		go f.serve(platform, f.platform)
+		return f
+	}
+	t.Fatal("no free consecutive ports for the simulator")
+	return nil
+}
+
+func (f *fakeSimulator) serve(l net.Listener, handle func(net.Conn) error) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			_ = handle(conn)
+		}()
+	}
+}
+
+func (f *fakeSimulator) platform(conn net.Conn) error {
+	for {
+		var signal uint32
+		if err := binary.Read(conn, binary.BigEndian, &signal); err != nil {
+			return err
+		}
+		if signal == simulatorSessionEnd {
+			return nil
+		}
+
+		f.lock.Lock()
+		if signal == simulatorPowerOn {
+			f.poweredOn = true
+			f.powerOns++
+		}
+		f.lock.Unlock()
+
+		if err := binary.Write(conn, binary.BigEndian, uint32(0)); err != nil {
+			return err
+		}
+	}
+}
+
+func (f *fakeSimulator) command(conn net.Conn) error {
+	for {
+		var signal uint32
+		if err := binary.Read(conn, binary.BigEndian, &signal); err != nil {
+			return err
+		}
+		if signal == simulatorSessionEnd {
+			return nil
+		}
+
+		var (
+			locality byte
+			size     uint32
+		)
+		if err := binary.Read(conn, binary.BigEndian, &locality); err != nil {
+			return err
+		}
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return err
+		}
+		cmd := make([]byte, size)
+		if _, err := io.ReadFull(conn, cmd); err != nil {
+			return err
+		}
+
+		if err := f.respond(conn, f.run(binary.BigEndian.Uint32(cmd[6:10]))); err != nil {
+			return err
+		}
+	}
+}
+
+// run returns the response of the command with code cc
+func (f *fakeSimulator) run(cc uint32) []byte {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch {
+	case !f.poweredOn:
+		return tpmResponse(rcFailure, nil)
+	case cc == ccStartup:
+		f.startups++
+		if f.started {
+			return tpmResponse(rcInitialize, nil)
+		}
+		f.started = true
+		return tpmResponse(rcSuccess, nil)
+	case cc == ccGetRandom:
+		params := &bytes.Buffer{}
+		_ = binary.Write(params, binary.BigEndian, uint16(len(fakeRandom)))
+		params.Write(fakeRandom)
+		return tpmResponse(rcSuccess, params.Bytes())
+	}
+	return tpmResponse(rcFailure, nil)
+}
+
+func (f *fakeSimulator) respond(conn net.Conn, resp []byte) error {
+	buf := &bytes.Buffer{}
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(resp)))
+	buf.Write(resp)
+	// trailing response code of the simulator protocol
+	_ = binary.Write(buf, binary.BigEndian, uint32(0))
+	_, err := buf.WriteTo(conn)
+	return err
+}
+
+func (f *fakeSimulator) counts() (int, int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.powerOns, f.startups
+}
+
+func tpmResponse(rc uint32, params []byte) []byte {
+	buf := &bytes.Buffer{}
+	// TPM_ST_NO_SESSIONS
+	_ = binary.Write(buf, binary.BigEndian, uint16(0x8001))
+	_ = binary.Write(buf, binary.BigEndian, uint32(10+len(params)))
+	_ = binary.Write(buf, binary.BigEndian, rc)
+	buf.Write(params)
+	return buf.Bytes()
+}
+
+func TestSimulator(t *testing.T) {
+	fake := newFakeSimulator(t)
+
+	device, err := NewDevice(DeviceSimulator, fake.address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getRandom := func() {
+		t.Helper()
+		rw, err := device.Raw()
+		if err != nil {
+			t.Fatal(err)
+		}
+		random, err := tpm2.GetRandom(rw, uint16(len(fakeRandom)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(random, fakeRandom) {
+			t.Errorf("expected random %q, got %q", fakeRandom, random)
+		}
+		if err := rw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the simulator is off, it is powered on and started
+	getRandom()
+	if powerOns, startups := fake.counts(); powerOns != 1 || startups != 1 {
+		t.Errorf("expected the simulator to be powered on and started once, got %d power ons and %d startups", powerOns, startups)
+	}
+
+	// the simulator is only started once per device
+	getRandom()
+	if powerOns, startups := fake.counts(); powerOns != 1 || startups != 1 {
+		t.Errorf("expected the simulator to be started once, got %d power ons and %d startups", powerOns, startups)
+	}
+
+	// a running simulator is used as is
+	device, err = NewDevice(DeviceSimulator, fake.address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getRandom()
+	if powerOns, startups := fake.counts(); powerOns != 1 || startups != 2 {
+		t.Errorf("expected the running simulator not to be powered on again, got %d power ons and %d startups", powerOns, startups)
+	}
+}
+
+func TestDeviceFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		env     map[string]string
+		kind    string
+		address string
+	}{
+		{name: "default"},
+		{name: "config", opts: Options{Device: DeviceSimulator, SimulatorAddress: "tpm:2321"},
+			kind: DeviceSimulator, address: "tpm:2321"},
+		{name: "simulator address selects the simulator", opts: Options{SimulatorAddress: "tpm:2321"},
+			kind: DeviceSimulator, address: "tpm:2321"},
+		{name: "environment overrides config", opts: Options{Device: DeviceSimulator, SimulatorAddress: "tpm:2321"},
+			env:  map[string]string{DeviceEnv: DeviceHardware, SimulatorAddressEnv: "swtpm:2321"},
+			kind: DeviceHardware, address: "swtpm:2321"},
+		{name: "environment simulator address", env: map[string]string{SimulatorAddressEnv: "swtpm:2321"},
+			kind: DeviceSimulator, address: "swtpm:2321"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(DeviceEnv, "")
+			t.Setenv(SimulatorAddressEnv, "")
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+
+			kind, simulatorAddress := deviceFromEnv(test.opts)
+			if kind != test.kind || simulatorAddress != test.address {
+				t.Errorf("expected %q %q, got %q %q", test.kind, test.address, kind, simulatorAddress)
+			}
+		})
+	}
+}
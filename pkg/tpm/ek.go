@@ -0,0 +1,250 @@
+package tpm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"io"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// EKAlgorithmRSA selects the RSA 2048 EK
+	EKAlgorithmRSA = "rsa"
+	// EKAlgorithmECC selects the ECC NIST P256 EK
+	EKAlgorithmECC = "ecc"
+
+	// NV indexes of the EK certificates, defined in the TCG EK Credential Profile
+	rsaEKCertIndex = 0x01c00002
+	eccEKCertIndex = 0x01c0000a
+
+	// Persistent handles of the EKs, defined in the TCG TPM v2.0 Provisioning Guidance
+	rsaEKHandle = 0x81010001
+	eccEKHandle = 0x81010002
+)
+
+var (
+	// Policy A of the TCG EK Credential Profile, PolicySecret of the endorsement hierarchy
+	ekAuthPolicy = []byte{
+		0x83, 0x71, 0x97, 0x67, 0x44, 0x84,
+		0xB3, 0xF8, 0x1A, 0x90, 0xCC, 0x8D,
+		0x46, 0xA5, 0xD7, 0x24, 0xFD, 0x52,
+		0xD7, 0x6E, 0x06, 0x52, 0x0B, 0x64,
+		0xF2, 0xA1, 0xDA, 0x1B, 0x33, 0x14,
+		0x69, 0xAA,
+	}
+	ekAttributes = tpm2.FlagFixedTPM | tpm2.FlagFixedParent | tpm2.FlagSensitiveDataOrigin |
+		tpm2.FlagAdminWithPolicy | tpm2.FlagRestricted | tpm2.FlagDecrypt
+	ekSymmetric = &tpm2.SymScheme{
+		Alg:     tpm2.AlgAES,
+		KeyBits: 128,
+		Mode:    tpm2.AlgCFB,
+	}
+
+	// Default templates of the TCG EK Credential Profile, templates L-1 and L-2
+	rsaEKTemplate = tpm2.Public{
+		Type:       tpm2.AlgRSA,
+		NameAlg:    tpm2.AlgSHA256,
+		Attributes: ekAttributes,
+		AuthPolicy: ekAuthPolicy,
+		RSAParameters: &tpm2.RSAParams{
+			Symmetric:  ekSymmetric,
+			KeyBits:    2048,
+			ModulusRaw: make([]byte, 256),
+		},
+	}
+	eccEKTemplate = tpm2.Public{
+		Type:       tpm2.AlgECC,
+		NameAlg:    tpm2.AlgSHA256,
+		Attributes: ekAttributes,
+		AuthPolicy: ekAuthPolicy,
+		ECCParameters: &tpm2.ECCParams{
+			Symmetric: ekSymmetric,
+			CurveID:   tpm2.CurveNISTP256,
+			Point: tpm2.ECPoint{
+				XRaw: make([]byte, 32),
+				YRaw: make([]byte, 32),
+			},
+		},
+	}
+)
+
+// EKInfo describes an endorsement key of the TPM
+type EKInfo struct {
+	// Algorithm is EKAlgorithmRSA or EKAlgorithmECC
+	Algorithm string `json:"algorithm"`
+	// Hash is the TPM hash derived from this EK
+	Hash string `json:"hash"`
+	// Certificate is true if the TPM has a certificate for this EK
+	Certificate bool `json:"certificate"`
+	// Selected is true for the EK used to identify the machine
+	Selected bool `json:"selected"`
+
+	ek attest.EK
+}
+
+// ListEKs returns the EKs of the TPM, the EK of the preferred algorithm first. The
+// first EK is the one used to identify the machine.
+func (t *TPM) ListEKs() ([]EKInfo, error) {
+	rw, err := t.device.Raw()
+	if err != nil {
+		return nil, err
+	}
+	defer rw.Close()
+
+	var result []EKInfo
+	for _, alg := range t.ekAlgorithms() {
+		ek, err := readEK(rw, alg)
+		if err != nil {
+			logrus.Debugf("no %s EK: %v", alg, err)
+			continue
+		}
+		result = append(result, *ek)
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("failed to find EK")
+	}
+	result[0].Selected = true
+	return result, nil
+}
+
+// SelectedEK returns the EK used to identify the machine, which is the EK of the
+// preferred algorithm if the TPM has one. The EK is only read once.
+func (t *TPM) SelectedEK() (*EKInfo, error) {
+	t.selectedLock.Lock()
+	defer t.selectedLock.Unlock()
+
+	if t.selected != nil {
+		return t.selected, nil
+	}
+
+	rw, err := t.device.Raw()
+	if err != nil {
+		return nil, err
+	}
+	defer rw.Close()
+
+	for _, alg := range t.ekAlgorithms() {
+		ek, err := readEK(rw, alg)
+		if err != nil {
+			logrus.Debugf("no %s EK: %v", alg, err)
+			continue
+		}
+		ek.Selected = true
+		t.selected = ek
+		return ek, nil
+	}
+	return nil, fmt.Errorf("failed to find EK")
+}
+
+func (t *TPM) ekAlgorithms() []string {
+	if t.ekAlgorithm == EKAlgorithmECC {
+		return []string{EKAlgorithmECC, EKAlgorithmRSA}
+	}
+	return []string{EKAlgorithmRSA, EKAlgorithmECC}
+}
+
+// readEK returns the EK of alg. The EK persisted by the platform is used if there is
+// one, then the key of the EK certificate, and only if neither exists the EK is
+// created from the default template.
+func readEK(rw io.ReadWriter, alg string) (*EKInfo, error) {
+	handle, certIndex, template := tpmutil.Handle(rsaEKHandle), tpmutil.Handle(rsaEKCertIndex), rsaEKTemplate
+	if alg == EKAlgorithmECC {
+		handle, certIndex, template = eccEKHandle, eccEKCertIndex, eccEKTemplate
+	}
+
+	var ek attest.EK
+	if public, err := readPersistentEK(rw, handle); err == nil {
+		if keyAlgorithm(public) != alg {
+			return nil, fmt.Errorf("EK at %#x is not %s", handle, alg)
+		}
+		ek.Public = public
+	}
+
+	if data, err := tpm2.NVReadEx(rw, certIndex, tpm2.HandleOwner, "", 0); err == nil {
+		cert, err := attest.ParseEKCertificate(data)
+		if err != nil {
+			return nil, err
+		}
+		if keyAlgorithm(cert.PublicKey) != alg {
+			return nil, fmt.Errorf("EK certificate at %#x is not %s", certIndex, alg)
+		}
+		if ek.Public == nil {
+			ek.Public = cert.PublicKey
+		}
+		if samePublicKey(ek.Public, cert.PublicKey) {
+			ek.Certificate = cert
+		} else {
+			logrus.Warnf("EK certificate at %#x does not match the EK at %#x, ignoring the certificate", certIndex, handle)
+		}
+	}
+
+	if ek.Public == nil {
+		public, err := createEK(rw, template)
+		if err != nil {
+			return nil, err
+		}
+		if keyAlgorithm(public) != alg {
+			return nil, fmt.Errorf("created EK is not %s", alg)
+		}
+		ek.Public = public
+	}
+
+	hash, err := getPubHash(&ek)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EKInfo{
+		Algorithm:   alg,
+		Hash:        hash,
+		Certificate: ek.Certificate != nil,
+		ek:          ek,
+	}, nil
+}
+
+func readPersistentEK(rw io.ReadWriter, handle tpmutil.Handle) (crypto.PublicKey, error) {
+	public, _, _, err := tpm2.ReadPublic(rw, handle)
+	if err != nil {
+		return nil, err
+	}
+	return public.Key()
+}
+
+func samePublicKey(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+func createEK(rw io.ReadWriter, template tpm2.Public) (crypto.PublicKey, error) {
+	handle, public, err := tpm2.CreatePrimary(rw, tpm2.HandleEndorsement, tpm2.PCRSelection{}, "", "", template)
+	if err != nil {
+		return nil, fmt.Errorf("creating EK: %w", err)
+	}
+	defer tpm2.FlushContext(rw, handle)
+	return public, nil
+}
+
+func keyAlgorithm(pub crypto.PublicKey) string {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return EKAlgorithmRSA
+	case *ecdsa.PublicKey:
+		return EKAlgorithmECC
+	}
+	return ""
+}
+
+func validEKAlgorithm(alg string) error {
+	switch alg {
+	case EKAlgorithmRSA, EKAlgorithmECC:
+		return nil
+	}
+	return fmt.Errorf("unknown EK algorithm %q, must be %s or %s", alg, EKAlgorithmRSA, EKAlgorithmECC)
+}
@@ -0,0 +1,82 @@
+package tpm_test
+
+import (
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/rancher/rancherd/pkg/tpm"
+	"github.com/rancher/rancherd/pkg/tpm/tpmtest"
+)
+
+func TestSelectedEKPersistent(t *testing.T) {
+	device := tpmtest.NewDevice(t)
+
+	created, err := tpm.NewWithDevice(device, tpm.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdEK, err := created.SelectedEK()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// persist an EK derived from other unique data than the default template, like a
+	// platform provisioning the EK with its own template
+	rw, err := device.Raw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := tpm2.Public{
+		Type:       tpm2.AlgRSA,
+		NameAlg:    tpm2.AlgSHA256,
+		Attributes: tpm2.FlagFixedTPM | tpm2.FlagFixedParent | tpm2.FlagSensitiveDataOrigin | tpm2.FlagAdminWithPolicy | tpm2.FlagRestricted | tpm2.FlagDecrypt,
+		RSAParameters: &tpm2.RSAParams{
+			Symmetric: &tpm2.SymScheme{
+				Alg:     tpm2.AlgAES,
+				KeyBits: 128,
+				Mode:    tpm2.AlgCFB,
+			},
+			KeyBits:    2048,
+			ModulusRaw: []byte("persistent"),
+		},
+	}
+	handle, public, err := tpm2.CreatePrimary(rw, tpm2.HandleEndorsement, tpm2.PCRSelection{}, "", "", template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tpm2.EvictControl(rw, "", tpm2.HandleOwner, handle, tpmutil.Handle(0x81010001)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tpm2.FlushContext(rw, handle); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := tpm.HashPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the selected EK is read once
+	cached, err := created.SelectedEK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Hash != createdEK.Hash {
+		t.Errorf("selected EK changed from %s to %s", createdEK.Hash, cached.Hash)
+	}
+
+	persisted, err := tpm.NewWithDevice(device, tpm.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := persisted.GetPubHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != expected {
+		t.Errorf("TPM hash = %s, expected the hash of the persistent EK %s", hash, expected)
+	}
+	if hash == createdEK.Hash {
+		t.Errorf("TPM hash is the hash of the EK created from the default template")
+	}
+}
@@ -16,7 +16,26 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func Get(cacerts []byte, url string, header http.Header) ([]byte, error) {
+// ErrNotModified is returned by GetIfNoneMatch when the payload still has the ETag of the client
+var ErrNotModified = errors.New("not modified")
+
+// Get downloads url from a server that authenticates the machine by its TPM, see Serve
+func (t *TPM) Get(cacerts []byte, url string, header http.Header) ([]byte, error) {
+	data, _, err := t.get(cacerts, url, header)
+	return data, err
+}
+
+// GetIfNoneMatch is Get sending etag as If-None-Match. It returns the ETag of the
+// payload sent by the server, or ErrNotModified if the payload still has etag.
+func (t *TPM) GetIfNoneMatch(cacerts []byte, url, etag string) ([]byte, string, error) {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	return t.get(cacerts, url, header)
+}
+
+func (t *TPM) get(cacerts []byte, url string, header http.Header) ([]byte, string, error) {
 	dialer := websocket.DefaultDialer
 	if len(cacerts) > 0 {
 		pool := x509.NewCertPool()
@@ -30,19 +49,19 @@ func Get(cacerts []byte, url string, header http.Header) ([]byte, error) {
 		}
 	}
 
-	attestationData, aikBytes, err := getAttestationData()
+	attestationData, aikBytes, err := t.getAttestationData()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	hash, err := GetPubHash()
+	hash, err := t.GetPubHash()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	token, err := getToken(attestationData)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	if header == nil {
@@ -56,56 +75,59 @@ func Get(cacerts []byte, url string, header http.Header) ([]byte, error) {
 		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
 			data, err := ioutil.ReadAll(resp.Body)
 			if err == nil {
-				return nil, errors.New(string(data))
+				return nil, "", errors.New(string(data))
 			}
 		}
-		return nil, err
+		return nil, "", err
 	}
 	defer conn.Close()
 
 	_, msg, err := conn.NextReader()
 	if err != nil {
-		return nil, fmt.Errorf("reading challenge: %w", err)
+		return nil, "", fmt.Errorf("reading challenge: %w", err)
 	}
 
 	var challenge Challenge
 	if err := json.NewDecoder(msg).Decode(&challenge); err != nil {
-		return nil, fmt.Errorf("unmarshaling Challenge: %w", err)
+		return nil, "", fmt.Errorf("unmarshaling Challenge: %w", err)
 	}
 
-	challengeResp, err := getChallengeResponse(challenge.EC, aikBytes)
+	challengeResp, err := t.getChallengeResponse(&challenge, aikBytes)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	writer, err := conn.NextWriter(websocket.BinaryMessage)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer writer.Close()
 
 	if err := json.NewEncoder(writer).Encode(challengeResp); err != nil {
-		return nil, fmt.Errorf("encoding ChallengeResponse: %w", err)
+		return nil, "", fmt.Errorf("encoding ChallengeResponse: %w", err)
 	}
 
 	if err := writer.Close(); err != nil {
-		return nil, fmt.Errorf("closing websocket writer: %w", err)
+		return nil, "", fmt.Errorf("closing websocket writer: %w", err)
 	}
 
+	etag := resp.Header.Get("ETag")
 	_, msg, err = conn.NextReader()
 	if err != nil {
-		return nil, fmt.Errorf("reading payload from tpm get: %w", err)
+		if closeErr, ok := err.(*websocket.CloseError); ok && closeErr.Code == websocket.CloseNormalClosure && closeErr.Text == notModified {
+			return nil, etag, ErrNotModified
+		}
+		return nil, "", fmt.Errorf("reading payload from tpm get: %w", err)
 	}
 
-	return ioutil.ReadAll(msg)
+	data, err := ioutil.ReadAll(msg)
+	return data, etag, err
 }
 
-func getChallengeResponse(ec *attest.EncryptedCredential, aikBytes []byte) (*ChallengeResponse, error) {
-	tpm, err := attest.OpenTPM(&attest.OpenConfig{
-		TPMVersion: attest.TPMVersion20,
-	})
+func (t *TPM) getChallengeResponse(challenge *Challenge, aikBytes []byte) (*ChallengeResponse, error) {
+	tpm, err := t.device.Attest()
 	if err != nil {
-		return nil, fmt.Errorf("opening tpm: %w", err)
+		return nil, err
 	}
 	defer tpm.Close()
 
@@ -115,11 +137,49 @@ func getChallengeResponse(ec *attest.EncryptedCredential, aikBytes []byte) (*Cha
 	}
 	defer aik.Close(tpm)
 
-	secret, err := aik.ActivateCredential(tpm, *ec)
+	secret, err := aik.ActivateCredential(tpm, *challenge.EC)
 	if err != nil {
 		return nil, fmt.Errorf("failed to activate credential: %w", err)
 	}
-	return &ChallengeResponse{
+
+	resp := &ChallengeResponse{
 		Secret: secret,
+	}
+
+	if len(challenge.Nonce) > 0 || t.quote {
+		// Without a nonce from the server the activated secret is used, which is just as fresh
+		nonce := challenge.Nonce
+		if len(nonce) == 0 {
+			nonce = secret
+		}
+		resp.Platform, err = getPlatformData(tpm, aik, nonce)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+func getPlatformData(tpm *attest.TPM, aik *attest.AK, nonce []byte) (*PlatformData, error) {
+	quote, err := aik.Quote(tpm, nonce, attest.HashSHA256)
+	if err != nil {
+		return nil, fmt.Errorf("quoting PCRs: %w", err)
+	}
+
+	pcrs, err := tpm.PCRs(attest.HashSHA256)
+	if err != nil {
+		return nil, fmt.Errorf("reading PCRs: %w", err)
+	}
+
+	eventLog, err := tpm.MeasurementLog()
+	if err != nil {
+		logrus.Warnf("failed to read the TPM event log, sending the quote without it: %v", err)
+	}
+
+	return &PlatformData{
+		Quote:    quote,
+		PCRs:     pcrs,
+		EventLog: eventLog,
 	}, nil
 }
@@ -0,0 +1,165 @@
+package tpm_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rancher/rancherd/pkg/tpm"
+	"github.com/rancher/rancherd/pkg/tpm/tpmtest"
+)
+
+type testPolicy struct {
+	pcrs   []int
+	err    error
+	quoted map[int][]byte
+}
+
+func (p *testPolicy) PCRs(hash string) ([]int, error) {
+	return p.pcrs, nil
+}
+
+func (p *testPolicy) Verify(hash string, pcrs map[int][]byte) error {
+	p.quoted = pcrs
+	return p.err
+}
+
+// newServer serves payload to the TPM with the given hash
+func newServer(t *testing.T, hash string, payload []byte, policy tpm.PCRPolicy) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		err := tpm.Serve(rw, req, func(reqHash string) ([]byte, error) {
+			if reqHash != hash {
+				return nil, fmt.Errorf("unknown TPM hash %s", reqHash)
+			}
+			return payload, nil
+		}, policy)
+		if err != nil {
+			t.Logf("serve: %v", err)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGet(t *testing.T) {
+	client := tpmtest.New(t, tpm.Options{})
+	hash, err := client.GetPubHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newServer(t, hash, []byte("payload"), nil)
+	data, err := client.Get(nil, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "payload" {
+		t.Errorf("payload = %q, expected payload", data)
+	}
+}
+
+func TestGetIfNoneMatch(t *testing.T) {
+	client := tpmtest.New(t, tpm.Options{})
+	hash, err := client.GetPubHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte("payload")
+	server := newServer(t, hash, payload, nil)
+	data, etag, err := client.GetIfNoneMatch(nil, server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "payload" || etag == "" {
+		t.Fatalf("payload = %q with ETag %q, expected payload with an ETag", data, etag)
+	}
+
+	if _, _, err := client.GetIfNoneMatch(nil, server.URL, etag); !errors.Is(err, tpm.ErrNotModified) {
+		t.Errorf("expected the unchanged payload not to be sent, got %v", err)
+	}
+
+	copy(payload, "changed")
+	data, changed, err := client.GetIfNoneMatch(nil, server.URL, etag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "changed" || changed == etag {
+		t.Errorf("payload = %q with ETag %q, expected the changed payload with a new ETag", data, changed)
+	}
+}
+
+func TestGetUnknownHash(t *testing.T) {
+	client := tpmtest.New(t, tpm.Options{})
+
+	server := newServer(t, "sha256:unknown", []byte("payload"), nil)
+	_, err := client.Get(nil, server.URL, nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown TPM hash") {
+		t.Fatalf("expected the server error for an unknown hash, got %v", err)
+	}
+}
+
+func TestGetPCRPolicy(t *testing.T) {
+	device := tpmtest.NewDevice(t)
+	client, err := tpm.NewWithDevice(device, tpm.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := client.GetPubHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device.ExtendPCR(t, 7, "secure boot")
+	expected, err := client.ReadPCRs([]int{0, 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &testPolicy{
+		pcrs: []int{0, 7},
+	}
+	server := newServer(t, hash, []byte("payload"), policy)
+	data, err := client.Get(nil, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "payload" {
+		t.Errorf("payload = %q, expected payload", data)
+	}
+
+	// the policy is called with the quoted values of the requested PCRs
+	if len(policy.quoted) != 2 {
+		t.Fatalf("quoted PCRs = %v, expected PCRs 0 and 7", policy.quoted)
+	}
+	for index, value := range expected {
+		if !bytes.Equal(policy.quoted[index], value) {
+			t.Errorf("PCR %d = %x, expected %x", index, policy.quoted[index], value)
+		}
+	}
+}
+
+func TestGetPCRPolicyRejected(t *testing.T) {
+	client := tpmtest.New(t, tpm.Options{})
+	hash, err := client.GetPubHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &testPolicy{
+		pcrs: []int{7},
+		err:  errors.New("PCR 7 does not match"),
+	}
+	server := newServer(t, hash, []byte("payload"), policy)
+	data, err := client.Get(nil, server.URL, nil)
+	if err == nil {
+		t.Fatalf("expected an error, got payload %q", data)
+	}
+	if !strings.Contains(err.Error(), "PCR 7 does not match") {
+		t.Errorf("error %q does not contain the policy error", err)
+	}
+}
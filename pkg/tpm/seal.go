@@ -0,0 +1,260 @@
+package tpm
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// maxSealedSize is the largest secret a TPM can seal, larger secrets are encrypted
+// with a random key that is sealed instead
+const maxSealedSize = 128
+
+var (
+	devicePaths = []string{
+		"/dev/tpmrm0",
+		"/dev/tpm0",
+	}
+
+	srkTemplate = tpm2.Public{
+		Type:    tpm2.AlgRSA,
+		NameAlg: tpm2.AlgSHA256,
+		Attributes: tpm2.FlagFixedTPM | tpm2.FlagFixedParent | tpm2.FlagSensitiveDataOrigin |
+			tpm2.FlagUserWithAuth | tpm2.FlagRestricted | tpm2.FlagDecrypt | tpm2.FlagNoDA,
+		RSAParameters: &tpm2.RSAParams{
+			Symmetric: &tpm2.SymScheme{
+				Alg:     tpm2.AlgAES,
+				KeyBits: 128,
+				Mode:    tpm2.AlgCFB,
+			},
+			KeyBits: 2048,
+		},
+	}
+)
+
+// SealedData is a secret sealed to the storage root key of a TPM. It can only be
+// unsealed by the same TPM and, if PCRs is set, only while the SHA256 bank of
+// those PCRs has the same values as when the data was sealed.
+type SealedData struct {
+	Public  []byte `json:"public"`
+	Private []byte `json:"private"`
+	PCRs    []int  `json:"pcrs,omitempty"`
+	// Data is set for secrets larger than a TPM can seal, it is the secret encrypted
+	// with the sealed key
+	Data []byte `json:"data,omitempty"`
+}
+
+// Encode returns the sealed data as a string suitable for storing in config
+func (s *SealedData) Encode() (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeSealedData parses the output of SealedData.Encode
+func DecodeSealedData(str string) (*SealedData, error) {
+	data, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, fmt.Errorf("decoding sealed data: %w", err)
+	}
+	result := &SealedData{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, fmt.Errorf("unmarshalling sealed data: %w", err)
+	}
+	return result, nil
+}
+
+// Seal encrypts data with the storage root key of the local TPM. If pcrs is not empty
+// the data can only be unsealed while those PCRs have their current values.
+func (t *TPM) Seal(data []byte, pcrs []int) (*SealedData, error) {
+	if len(data) <= maxSealedSize {
+		return t.seal(data, pcrs)
+	}
+
+	var (
+		key   [32]byte
+		nonce [24]byte
+	)
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+
+	sealed, err := t.seal(key[:], pcrs)
+	if err != nil {
+		return nil, err
+	}
+	sealed.Data = secretbox.Seal(nonce[:], data, &nonce, &key)
+	return sealed, nil
+}
+
+func (t *TPM) seal(data []byte, pcrs []int) (*SealedData, error) {
+	rw, err := t.device.Raw()
+	if err != nil {
+		return nil, err
+	}
+	defer rw.Close()
+
+	srk, err := createSRK(rw)
+	if err != nil {
+		return nil, err
+	}
+	defer tpm2.FlushContext(rw, srk)
+
+	public := tpm2.Public{
+		Type:       tpm2.AlgKeyedHash,
+		NameAlg:    tpm2.AlgSHA256,
+		Attributes: tpm2.FlagFixedTPM | tpm2.FlagFixedParent | tpm2.FlagUserWithAuth,
+	}
+	if len(pcrs) > 0 {
+		policy, err := pcrPolicyDigest(rw, pcrs)
+		if err != nil {
+			return nil, err
+		}
+		public.Attributes = tpm2.FlagFixedTPM | tpm2.FlagFixedParent
+		public.AuthPolicy = policy
+	}
+
+	privateBlob, publicBlob, _, _, _, err := tpm2.CreateKeyWithSensitive(rw, srk, tpm2.PCRSelection{}, "", "", public, data)
+	if err != nil {
+		return nil, fmt.Errorf("sealing data: %w", err)
+	}
+
+	return &SealedData{
+		Public:  publicBlob,
+		Private: privateBlob,
+		PCRs:    pcrs,
+	}, nil
+}
+
+// Unseal decrypts data previously sealed with Seal on the same TPM
+func (t *TPM) Unseal(sealed *SealedData) ([]byte, error) {
+	data, err := t.unseal(sealed)
+	if err != nil || len(sealed.Data) == 0 {
+		return data, err
+	}
+
+	var (
+		key   [32]byte
+		nonce [24]byte
+	)
+	if len(data) != len(key) || len(sealed.Data) < len(nonce) {
+		return nil, fmt.Errorf("invalid sealed data")
+	}
+	copy(key[:], data)
+	copy(nonce[:], sealed.Data)
+
+	result, ok := secretbox.Open(nil, sealed.Data[len(nonce):], &nonce, &key)
+	if !ok {
+		return nil, fmt.Errorf("decrypting sealed data failed")
+	}
+	return result, nil
+}
+
+func (t *TPM) unseal(sealed *SealedData) ([]byte, error) {
+	rw, err := t.device.Raw()
+	if err != nil {
+		return nil, err
+	}
+	defer rw.Close()
+
+	srk, err := createSRK(rw)
+	if err != nil {
+		return nil, err
+	}
+	defer tpm2.FlushContext(rw, srk)
+
+	handle, _, err := tpm2.Load(rw, srk, "", sealed.Public, sealed.Private)
+	if err != nil {
+		return nil, fmt.Errorf("loading sealed data: %w", err)
+	}
+	defer tpm2.FlushContext(rw, handle)
+
+	if len(sealed.PCRs) == 0 {
+		data, err := tpm2.Unseal(rw, handle, "")
+		if err != nil {
+			return nil, fmt.Errorf("unsealing data: %w", err)
+		}
+		return data, nil
+	}
+
+	session, err := pcrPolicySession(rw, tpm2.SessionPolicy, sealed.PCRs)
+	if err != nil {
+		return nil, err
+	}
+	defer tpm2.FlushContext(rw, session)
+
+	data, err := tpm2.UnsealWithSession(rw, session, handle, "")
+	if err != nil {
+		return nil, fmt.Errorf("unsealing data bound to PCRs %v: %w", sealed.PCRs, err)
+	}
+	return data, nil
+}
+
+// ParsePCRs parses a list of PCR indexes as given on the command line
+func ParsePCRs(strs []string) (result []int, _ error) {
+	for _, str := range strs {
+		pcr, err := strconv.Atoi(strings.TrimSpace(str))
+		if err != nil || pcr < 0 || pcr > 23 {
+			return nil, fmt.Errorf("invalid PCR index %q", str)
+		}
+		result = append(result, pcr)
+	}
+	return result, nil
+}
+
+func pcrSelection(pcrs []int) tpm2.PCRSelection {
+	return tpm2.PCRSelection{
+		Hash: tpm2.AlgSHA256,
+		PCRs: pcrs,
+	}
+}
+
+// pcrPolicySession starts a session and satisfies a policy of the current values of pcrs
+func pcrPolicySession(rw io.ReadWriter, sessionType tpm2.SessionType, pcrs []int) (tpmutil.Handle, error) {
+	session, _, err := tpm2.StartAuthSession(rw, tpm2.HandleNull, tpm2.HandleNull,
+		make([]byte, 16), nil, sessionType, tpm2.AlgNull, tpm2.AlgSHA256)
+	if err != nil {
+		return 0, fmt.Errorf("starting policy session: %w", err)
+	}
+
+	if err := tpm2.PolicyPCR(rw, session, nil, pcrSelection(pcrs)); err != nil {
+		tpm2.FlushContext(rw, session)
+		return 0, fmt.Errorf("applying PCR policy for %v: %w", pcrs, err)
+	}
+	return session, nil
+}
+
+func pcrPolicyDigest(rw io.ReadWriter, pcrs []int) ([]byte, error) {
+	session, err := pcrPolicySession(rw, tpm2.SessionTrial, pcrs)
+	if err != nil {
+		return nil, err
+	}
+	defer tpm2.FlushContext(rw, session)
+
+	digest, err := tpm2.PolicyGetDigest(rw, session)
+	if err != nil {
+		return nil, fmt.Errorf("getting PCR policy digest: %w", err)
+	}
+	return digest, nil
+}
+
+func createSRK(rw io.ReadWriter) (tpmutil.Handle, error) {
+	srk, _, err := tpm2.CreatePrimary(rw, tpm2.HandleOwner, tpm2.PCRSelection{}, "", "", srkTemplate)
+	if err != nil {
+		return 0, fmt.Errorf("creating storage root key: %w", err)
+	}
+	return srk, nil
+}
@@ -0,0 +1,93 @@
+package tpm_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rancher/rancherd/pkg/tpm"
+	"github.com/rancher/rancherd/pkg/tpm/tpmtest"
+)
+
+func TestSeal(t *testing.T) {
+	client := tpmtest.New(t, tpm.Options{})
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "small",
+			data: []byte("token"),
+		},
+		{
+			// larger than the TPM can seal, encrypted with a sealed key
+			name: "large",
+			data: bytes.Repeat([]byte("registries"), 100),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sealed, err := client.Seal(test.data, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			encoded, err := sealed.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := tpm.DecodeSealedData(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := client.Unseal(decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, test.data) {
+				t.Errorf("unsealed %q, expected %q", data, test.data)
+			}
+		})
+	}
+}
+
+func TestSealPCRs(t *testing.T) {
+	device := tpmtest.NewDevice(t)
+	client, err := tpm.NewWithDevice(device, tpm.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device.ExtendPCR(t, 7, "secure boot")
+	sealed, err := client.Seal([]byte("token"), []int{7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := client.Unseal(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "token" {
+		t.Errorf("unsealed %q, expected token", data)
+	}
+
+	// a different boot state can not unseal the data
+	device.ExtendPCR(t, 7, "untrusted bootloader")
+	if _, err := client.Unseal(sealed); err == nil {
+		t.Errorf("expected unsealing to fail after PCR 7 changed")
+	}
+
+	// the same boot state after a reboot can
+	device.Reboot(t)
+	device.ExtendPCR(t, 7, "secure boot")
+	data, err = client.Unseal(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "token" {
+		t.Errorf("unsealed %q after reboot, expected token", data)
+	}
+}
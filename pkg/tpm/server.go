@@ -0,0 +1,246 @@
+package tpm
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// PayloadFunc returns the payload for the machine with the given TPM hash. If
+// the machine is not known it must return an error, which is sent to the client.
+type PayloadFunc func(hash string) ([]byte, error)
+
+// notModified is the reason of the close frame sent instead of the payload when it
+// still has the ETag sent by the client in If-None-Match
+const notModified = "not modified"
+
+var upgrader = websocket.Upgrader{
+	HandshakeTimeout: 45 * time.Second,
+}
+
+// PCRPolicy verifies the boot state of a machine
+type PCRPolicy interface {
+	// PCRs returns the PCRs that must be quoted by the machine with the given
+	// TPM hash, none if the boot state of the machine is not verified
+	PCRs(hash string) ([]int, error)
+	// Verify is called with the quoted values of the PCRs returned by PCRs
+	Verify(hash string, pcrs map[int][]byte) error
+}
+
+// Serve implements the server side of Get. It verifies the attestation data sent
+// by the client, issues a credential activation challenge for the AK bound to the
+// EK and, if the client solved the challenge, writes the payload for its TPM hash.
+// If policy is not nil and returns PCRs for the machine the client must also send
+// a quote of the PCRs, which is verified against the event log and the policy.
+// EK certificates are not validated against the CAs of the TPM manufacturers, the
+// machine is identified by the hash of its EK only.
+// The ETag of the payload is sent in the handshake response, and the payload is not
+// sent again to an attested client that already has it, see GetIfNoneMatch.
+func Serve(rw http.ResponseWriter, req *http.Request, payload PayloadFunc, policy PCRPolicy) error {
+	attestationData, err := getAttestationDataFromRequest(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusUnauthorized)
+		return err
+	}
+
+	ek, err := DecodeEK(attestationData.EK)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusUnauthorized)
+		return err
+	}
+
+	hash, err := HashPublicKey(ek)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusUnauthorized)
+		return err
+	}
+
+	data, err := payload(hash)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusUnauthorized)
+		return fmt.Errorf("TPM hash %s: %w", hash, err)
+	}
+
+	challenge := &Challenge{}
+	if policy != nil {
+		challenge.PCRs, err = policy.PCRs(hash)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusUnauthorized)
+			return fmt.Errorf("PCR policy for TPM hash %s: %w", hash, err)
+		}
+	}
+	if len(challenge.PCRs) > 0 {
+		challenge.Nonce = make([]byte, 32)
+		if _, err := rand.Read(challenge.Nonce); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+	}
+
+	params := attest.ActivationParameters{
+		TPMVersion: attest.TPMVersion20,
+		EK:         ek,
+		AK:         *attestationData.AK,
+	}
+	var secret []byte
+	secret, challenge.EC, err = params.Generate()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusUnauthorized)
+		return fmt.Errorf("generating challenge for TPM hash %s: %w", hash, err)
+	}
+
+	etag := payloadETag(data)
+	conn, err := upgrader.Upgrade(rw, req, http.Header{
+		"ETag": []string{etag},
+	})
+	if err != nil {
+		return fmt.Errorf("upgrading connection for TPM hash %s: %w", hash, err)
+	}
+	defer conn.Close()
+
+	if err := writeJSON(conn, challenge); err != nil {
+		return fmt.Errorf("writing challenge to TPM hash %s: %w", hash, err)
+	}
+
+	_, msg, err := conn.NextReader()
+	if err != nil {
+		return fmt.Errorf("reading challenge response from TPM hash %s: %w", hash, err)
+	}
+
+	var challengeResp ChallengeResponse
+	if err := json.NewDecoder(msg).Decode(&challengeResp); err != nil {
+		return fmt.Errorf("unmarshaling ChallengeResponse from TPM hash %s: %w", hash, err)
+	}
+
+	if subtle.ConstantTimeCompare(secret, challengeResp.Secret) != 1 {
+		closeWithError(conn, "invalid challenge response")
+		return fmt.Errorf("invalid challenge response from TPM hash %s", hash)
+	}
+
+	if len(challenge.PCRs) > 0 {
+		pcrs, err := verifyPlatformData(attestationData.AK, challengeResp.Platform, challenge.Nonce, challenge.PCRs)
+		if err == nil {
+			err = policy.Verify(hash, pcrs)
+		}
+		if err != nil {
+			closeWithError(conn, err.Error())
+			return fmt.Errorf("verifying boot state of TPM hash %s: %w", hash, err)
+		}
+	}
+
+	logrus.Infof("TPM hash %s passed attestation", hash)
+	if req.Header.Get("If-None-Match") == etag {
+		return conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, notModified), time.Time{})
+	}
+
+	writer, err := conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("writing payload to TPM hash %s: %w", hash, err)
+	}
+	return writer.Close()
+}
+
+// verifyPlatformData checks that the quote was signed by the AK over nonce and matches the
+// PCR values and event log, and returns the values of the requested PCRs
+func verifyPlatformData(ak *attest.AttestationParameters, platform *PlatformData, nonce []byte, indexes []int) (map[int][]byte, error) {
+	if platform == nil || platform.Quote == nil {
+		return nil, fmt.Errorf("client did not send a quote of the PCRs")
+	}
+
+	akPub, err := attest.ParseAKPublic(attest.TPMVersion20, ak.Public)
+	if err != nil {
+		return nil, fmt.Errorf("parsing AK: %w", err)
+	}
+
+	if err := akPub.Verify(*platform.Quote, platform.PCRs, nonce); err != nil {
+		return nil, fmt.Errorf("invalid quote: %w", err)
+	}
+
+	if len(platform.EventLog) == 0 {
+		logrus.Warnf("Client did not send an event log, only verifying the quoted PCR values")
+	} else {
+		eventLog, err := attest.ParseEventLog(platform.EventLog)
+		if err != nil {
+			return nil, fmt.Errorf("parsing event log: %w", err)
+		}
+		if _, err := eventLog.Verify(platform.PCRs); err != nil {
+			return nil, fmt.Errorf("event log does not match the PCRs: %w", err)
+		}
+	}
+
+	pcrs := selectPCRs(platform.PCRs, indexes)
+	for _, index := range indexes {
+		if _, ok := pcrs[index]; !ok {
+			return nil, fmt.Errorf("quote is missing PCR %d", index)
+		}
+	}
+	return pcrs, nil
+}
+
+func payloadETag(data []byte) string {
+	hash := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(hash[:]) + `"`
+}
+
+func closeWithError(conn *websocket.Conn, msg string) {
+	// control frames are limited to 125 bytes including the close code
+	if len(msg) > 123 {
+		msg = msg[:123]
+	}
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, msg), time.Time{})
+}
+
+func getAttestationDataFromRequest(req *http.Request) (*AttestationData, error) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !strings.HasPrefix(token, "TPM") {
+		return nil, fmt.Errorf("missing TPM attestation data in Authorization header")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(token, "TPM"))
+	if err != nil {
+		return nil, fmt.Errorf("decoding attestation data: %w", err)
+	}
+
+	var attestationData AttestationData
+	if err := json.Unmarshal(data, &attestationData); err != nil {
+		return nil, fmt.Errorf("unmarshaling attestation data: %w", err)
+	}
+
+	if len(attestationData.EK) == 0 || attestationData.AK == nil {
+		return nil, fmt.Errorf("attestation data is missing EK or AK")
+	}
+
+	return &attestationData, nil
+}
+
+func writeJSON(conn *websocket.Conn, obj interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	writer, err := conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,157 @@
+package tpm_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/gorilla/websocket"
+	"github.com/rancher/rancherd/pkg/tpm"
+	"github.com/rancher/rancherd/pkg/tpm/tpmtest"
+)
+
+func TestServeUnauthorized(t *testing.T) {
+	server := newServer(t, "sha256:unknown", []byte("payload"), nil)
+
+	tests := []struct {
+		name          string
+		authorization string
+	}{
+		{
+			name: "missing",
+		},
+		{
+			name:          "not TPM",
+			authorization: "Bearer token",
+		},
+		{
+			name:          "invalid base64",
+			authorization: "Bearer TPM%%%",
+		},
+		{
+			name:          "missing EK",
+			authorization: "Bearer TPM" + base64.StdEncoding.EncodeToString([]byte(`{"AK":{}}`)),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("status = %d, expected 401", resp.StatusCode)
+			}
+		})
+	}
+}
+
+// TestServeQuoteNonce answers the challenge by hand with a quote over another nonce
+// than the one sent by the server, which must be rejected
+func TestServeQuoteNonce(t *testing.T) {
+	device := tpmtest.NewDevice(t)
+	client, err := tpm.NewWithDevice(device, tpm.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := client.GetPubHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &testPolicy{
+		pcrs: []int{7},
+	}
+	server := newServer(t, hash, []byte("payload"), policy)
+
+	at, err := device.Attest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer at.Close()
+
+	eks, err := at.EKs()
+	if err != nil || len(eks) == 0 {
+		t.Fatalf("EKs = %v, %v", eks, err)
+	}
+	ak, err := at.NewAK(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ak.Close(at)
+
+	ekBytes, err := tpm.EncodeEK(&eks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := ak.AttestationParameters()
+	data, err := json.Marshal(tpm.AttestationData{
+		EK: ekBytes,
+		AK: &params,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer TPM"+base64.StdEncoding.EncodeToString(data))
+	conn, _, err := websocket.DefaultDialer.Dial(strings.Replace(server.URL, "http", "ws", 1), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var challenge tpm.Challenge
+	if err := conn.ReadJSON(&challenge); err != nil {
+		t.Fatal(err)
+	}
+	if len(challenge.Nonce) == 0 || len(challenge.PCRs) != 1 || challenge.PCRs[0] != 7 {
+		t.Fatalf("challenge does not ask for a quote of PCR 7: nonce %x, PCRs %v", challenge.Nonce, challenge.PCRs)
+	}
+
+	secret, err := ak.ActivateCredential(at, *challenge.EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quote, err := ak.Quote(at, []byte("stale nonce"), attest.HashSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcrs, err := at.PCRs(attest.HashSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.WriteJSON(tpm.ChallengeResponse{
+		Secret: secret,
+		Platform: &tpm.PlatformData{
+			Quote: quote,
+			PCRs:  pcrs,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, payload, err := conn.ReadMessage()
+	if err == nil {
+		t.Fatalf("expected the quote to be rejected, got payload %q", payload)
+	}
+	if !strings.Contains(err.Error(), "invalid quote") {
+		t.Errorf("error %q does not reject the quote", err)
+	}
+	if policy.quoted != nil {
+		t.Errorf("policy was called with PCRs of an invalid quote")
+	}
+}
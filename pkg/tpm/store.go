@@ -0,0 +1,66 @@
+package tpm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sealedPrefix matches the tpm-sealed:// secret reference in the config so the stored
+// files can be referenced from the config as tpm-sealed:///path/to/file
+const sealedPrefix = "tpm-sealed://"
+
+// SealedStore keeps secrets sealed to the local TPM as files in Dir. Only the
+// sealed blobs are written to disk.
+type SealedStore struct {
+	TPM *TPM
+	Dir string
+	// PCRs the secrets are bound to when sealed
+	PCRs []int
+}
+
+// Path returns the file name of the secret called name
+func (s *SealedStore) Path(name string) string {
+	return filepath.Join(s.Dir, name)
+}
+
+// Get returns the unsealed secret called name or "" if it has not been stored
+func (s *SealedStore) Get(name string) (string, error) {
+	data, err := ioutil.ReadFile(s.Path(name))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	sealed, err := DecodeSealedData(strings.TrimPrefix(strings.TrimSpace(string(data)), sealedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("decoding %s: %w", s.Path(name), err)
+	}
+
+	value, err := s.TPM.Unseal(sealed)
+	if err != nil {
+		return "", fmt.Errorf("unsealing %s: %w", s.Path(name), err)
+	}
+	return string(value), nil
+}
+
+// Set seals value and stores it as the secret called name
+func (s *SealedStore) Set(name, value string) error {
+	sealed, err := s.TPM.Seal([]byte(value), s.PCRs)
+	if err != nil {
+		return err
+	}
+
+	str, err := sealed.Encode()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return fmt.Errorf("mkdir %s: %w", s.Dir, err)
+	}
+	return ioutil.WriteFile(s.Path(name), []byte(sealedPrefix+str+"\n"), 0600)
+}
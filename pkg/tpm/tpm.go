@@ -1,57 +1,69 @@
 package tpm
 
 import (
+	"crypto"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/google/go-attestation/attest"
 )
 
-func ResolveToken(token string) (bool, string, error) {
-	if !strings.HasPrefix(token, "tpm://") {
-		return false, token, nil
-	}
+// TPM is a TPM 2.0 device with the options it is used with
+type TPM struct {
+	device      Device
+	quote       bool
+	ekAlgorithm string
 
-	hash, err := GetPubHash()
-	return true, hash, err
+	selectedLock sync.Mutex
+	selected     *EKInfo
 }
 
-func GetPubHash() (string, error) {
-	ek, err := getEK()
+// New returns the TPM selected by opts. The environment variables DeviceEnv and
+// SimulatorAddressEnv take precedence over the device options.
+func New(opts Options) (*TPM, error) {
+	device, err := NewDevice(deviceFromEnv(opts))
 	if err != nil {
-		return "", fmt.Errorf("getting EK: %w", err)
+		return nil, err
 	}
+	return NewWithDevice(device, opts)
+}
 
-	hash, err := getPubHash(ek)
-	if err != nil {
-		return "", fmt.Errorf("hashing EK: %w", err)
+// NewWithDevice returns a TPM using device, the device options of opts are ignored
+func NewWithDevice(device Device, opts Options) (*TPM, error) {
+	alg := opts.EKAlgorithm
+	if alg == "" {
+		alg = EKAlgorithmRSA
+	}
+	if err := validEKAlgorithm(alg); err != nil {
+		return nil, err
 	}
 
-	return hash, nil
+	return &TPM{
+		device:      device,
+		quote:       opts.Quote,
+		ekAlgorithm: alg,
+	}, nil
 }
 
-func getEK() (*attest.EK, error) {
-	var err error
-	tpm, err := attest.OpenTPM(&attest.OpenConfig{
-		TPMVersion: attest.TPMVersion20,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("opening tpm: %w", err)
+func (t *TPM) ResolveToken(token string) (bool, string, error) {
+	if !strings.HasPrefix(token, "tpm://") {
+		return false, token, nil
 	}
-	defer tpm.Close()
 
-	eks, err := tpm.EKs()
-	if err != nil {
-		return nil, err
-	}
+	hash, err := t.GetPubHash()
+	return true, hash, err
+}
 
-	if len(eks) == 0 {
-		return nil, fmt.Errorf("failed to find EK")
+// GetPubHash returns the TPM hash of the selected EK, which identifies the machine
+func (t *TPM) GetPubHash() (string, error) {
+	ek, err := t.SelectedEK()
+	if err != nil {
+		return "", fmt.Errorf("getting EK: %w", err)
 	}
-
-	return &eks[0], nil
+	return ek.Hash, nil
 }
 
 func getToken(data *AttestationData) (string, error) {
@@ -63,20 +75,22 @@ func getToken(data *AttestationData) (string, error) {
 	return "Bearer TPM" + base64.StdEncoding.EncodeToString(bytes), nil
 }
 
-func getAttestationData() (*AttestationData, []byte, error) {
-	var err error
-	tpm, err := attest.OpenTPM(&attest.OpenConfig{
-		TPMVersion: attest.TPMVersion20,
-	})
+func (t *TPM) getAttestationData() (*AttestationData, []byte, error) {
+	selected, err := t.SelectedEK()
 	if err != nil {
-		return nil, nil, fmt.Errorf("opening tpm: %w", err)
+		return nil, nil, fmt.Errorf("getting EK: %w", err)
+	}
+	if selected.Algorithm != EKAlgorithmRSA {
+		// credentials are always activated with the RSA EK
+		return nil, nil, fmt.Errorf("attestation with the %s EK is not supported, set tpm.ekAlgorithm to %s", selected.Algorithm, EKAlgorithmRSA)
 	}
-	defer tpm.Close()
 
-	eks, err := tpm.EKs()
+	tpm, err := t.device.Attest()
 	if err != nil {
 		return nil, nil, err
 	}
+	defer tpm.Close()
+
 	ak, err := tpm.NewAK(nil)
 	if err != nil {
 		return nil, nil, err
@@ -84,12 +98,7 @@ func getAttestationData() (*AttestationData, []byte, error) {
 	defer ak.Close(tpm)
 	params := ak.AttestationParameters()
 
-	if len(eks) == 0 {
-		return nil, nil, fmt.Errorf("failed to find EK")
-	}
-
-	ek := &eks[0]
-	ekBytes, err := EncodeEK(ek)
+	ekBytes, err := EncodeEK(&selected.ek)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -104,3 +113,38 @@ func getAttestationData() (*AttestationData, []byte, error) {
 		AK: &params,
 	}, aikBytes, nil
 }
+
+// ReadPCRs returns the values of the SHA256 bank of the given PCRs, all PCRs if none are given
+func (t *TPM) ReadPCRs(indexes []int) (map[int][]byte, error) {
+	tpm, err := t.device.Attest()
+	if err != nil {
+		return nil, err
+	}
+	defer tpm.Close()
+
+	pcrs, err := tpm.PCRs(attest.HashSHA256)
+	if err != nil {
+		return nil, fmt.Errorf("reading PCRs: %w", err)
+	}
+
+	return selectPCRs(pcrs, indexes), nil
+}
+
+func selectPCRs(pcrs []attest.PCR, indexes []int) map[int][]byte {
+	result := map[int][]byte{}
+	for _, pcr := range pcrs {
+		if pcr.DigestAlg != crypto.SHA256 {
+			continue
+		}
+		if len(indexes) == 0 {
+			result[pcr.Index] = pcr.Digest
+			continue
+		}
+		for _, index := range indexes {
+			if pcr.Index == index {
+				result[pcr.Index] = pcr.Digest
+			}
+		}
+	}
+	return result
+}
@@ -17,7 +17,9 @@
 package tpm
 
 import (
+	"crypto"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/pem"
 	"fmt"
 
@@ -32,6 +34,10 @@ type AttestationData struct {
 
 type Challenge struct {
 	EC *attest.EncryptedCredential
+	// Nonce requests a quote of the PCRs over this nonce in the response
+	Nonce []byte `json:",omitempty"`
+	// PCRs are the PCRs the server is going to verify
+	PCRs []int `json:",omitempty"`
 }
 
 type KeyData struct {
@@ -39,19 +45,60 @@ type KeyData struct {
 }
 
 type ChallengeResponse struct {
-	Secret []byte
+	Secret   []byte
+	Platform *PlatformData `json:",omitempty"`
+}
+
+// PlatformData describes the boot state of the machine. The quote is signed by
+// the AK and covers all SHA256 PCRs, the event log allows to verify how the
+// PCR values were measured.
+type PlatformData struct {
+	Quote    *attest.Quote
+	PCRs     []attest.PCR
+	EventLog []byte `json:",omitempty"`
 }
 
 func getPubHash(ek *attest.EK) (string, error) {
-	data, err := pubBytes(ek)
+	return HashPublicKey(ek.Public)
+}
+
+// HashPublicKey returns the TPM hash of an EK public key, the value that
+// identifies a machine in the inventory
+func HashPublicKey(pub crypto.PublicKey) (string, error) {
+	data, err := x509.MarshalPKIXPublicKey(pub)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("error marshaling ec public key: %v", err)
 	}
 	pubHash := sha256.Sum256(data)
 	hashEncoded := fmt.Sprintf("%x", pubHash)
 	return hashEncoded, nil
 }
 
+// DecodeEK parses the output of EncodeEK and returns the public key of the EK
+func DecodeEK(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode EK: no PEM data")
+	}
+
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := attest.ParseEKCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing EK certificate: %w", err)
+		}
+		return cert.PublicKey, nil
+	case "PUBLIC KEY":
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing EK public key: %w", err)
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("failed to decode EK: unknown PEM type %s", block.Type)
+	}
+}
+
 func EncodeEK(ek *attest.EK) ([]byte, error) {
 	if ek.Certificate != nil {
 		return pem.EncodeToMemory(&pem.Block{
@@ -78,3 +125,22 @@ func pubBytes(ek *attest.EK) ([]byte, error) {
 	}
 	return data, nil
 }
+
+// MachinePCRs are the PCR values of a machine as printed by "get-tpm-hash --pcrs"
+// and as stored in the PCR policy of the inventory server
+type MachinePCRs struct {
+	Hash string         `json:"hash,omitempty"`
+	PCRs map[int]string `json:"pcrs"`
+}
+
+// NewMachinePCRs returns the hex encoded values of pcrs
+func NewMachinePCRs(hash string, pcrs map[int][]byte) *MachinePCRs {
+	result := &MachinePCRs{
+		Hash: hash,
+		PCRs: map[int]string{},
+	}
+	for index, digest := range pcrs {
+		result.PCRs[index] = hex.EncodeToString(digest)
+	}
+	return result
+}
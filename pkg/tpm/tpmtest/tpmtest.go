@@ -0,0 +1,90 @@
+// Package tpmtest provides a TPM backed by the in-process simulator of go-tpm-tools
+// for tests. The simulator needs cgo, tests using it are skipped without cgo.
+package tpmtest
+
+import (
+	"crypto/sha256"
+	"io"
+	"testing"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/rancher/rancherd/pkg/tpm"
+)
+
+// Device is a tpm.Device backed by the in-process simulator. Only one Device can
+// exist at a time, it is closed when the test that created it finishes.
+type Device struct {
+	sim *simulator.Simulator
+}
+
+// NewDevice starts a new simulator, skipping the test if the simulator is not
+// available
+func NewDevice(t testing.TB) *Device {
+	t.Helper()
+
+	sim, err := simulator.Get()
+	if err != nil {
+		t.Skipf("TPM simulator is not available: %v", err)
+	}
+	t.Cleanup(func() {
+		sim.Close()
+	})
+	return &Device{
+		sim: sim,
+	}
+}
+
+// New returns a TPM with opts backed by a new simulator Device
+func New(t testing.TB, opts tpm.Options) *tpm.TPM {
+	t.Helper()
+
+	result, err := tpm.NewWithDevice(NewDevice(t), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// Raw returns a connection to the simulator, closing it keeps the simulator running
+func (d *Device) Raw() (io.ReadWriteCloser, error) {
+	return nopCloser{ReadWriter: d.sim}, nil
+}
+
+func (d *Device) Attest() (*attest.TPM, error) {
+	return attest.OpenTPM(&attest.OpenConfig{
+		TPMVersion:     attest.TPMVersion20,
+		CommandChannel: tpm.CommandChannel(nopCloser{ReadWriter: d.sim}),
+	})
+}
+
+// ExtendPCR extends the SHA256 bank of pcr with the digest of data, like a
+// measurement during boot
+func (d *Device) ExtendPCR(t testing.TB, pcr int, data string) {
+	t.Helper()
+
+	digest := sha256.Sum256([]byte(data))
+	if err := tpm2.PCRExtend(d.sim, tpmutil.Handle(pcr), tpm2.AlgSHA256, digest[:], ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Reboot resets the simulator like a reboot of the machine, the PCRs are reset and
+// the seeds are kept
+func (d *Device) Reboot(t testing.TB) {
+	t.Helper()
+
+	if err := d.sim.Reset(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type nopCloser struct {
+	io.ReadWriter
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -83,6 +83,20 @@ func K8sVersion(kubernetesVersion string) (string, error) {
 	return resolved, nil
 }
 
+// SetK8sVersion resolves the Kubernetes channel to version without the channel server
+func SetK8sVersion(channel, version string) {
+	cachedLock.Lock()
+	defer cachedLock.Unlock()
+	cachedK8sVersion[channel] = version
+}
+
+// SetRancherVersion resolves the Rancher channel to version without the chart repository
+func SetRancherVersion(channel, version string) {
+	cachedLock.Lock()
+	defer cachedLock.Unlock()
+	cachedRancherVersion[channel] = version
+}
+
 func RancherVersion(rancherVersion string) (string, error) {
 	cachedLock.Lock()
 	defer cachedLock.Unlock()